@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tarasowski/autospeech/pkg/app"
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+func main() {
+	cfg := config.NewConfig()
+
+	logFile, err := app.SetupLogging(cfg.LogFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer logFile.Close()
+
+	if cfg.ListDevices {
+		if err := app.PrintInputDevices(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Pick the language from -lang or ask on the terminal. Languages without Vosk
+	// models are left to the backends to accept or reject.
+	var lang config.Language
+	if cfg.Language != "" {
+		var ok bool
+		if lang, ok = config.LookupLanguage(cfg.Language); !ok {
+			lang = config.Language{Code: cfg.Language, Name: cfg.Language}
+		}
+	} else {
+		lang = promptLanguage(os.Stdin, os.Stdout)
+	}
+	cfg.Language = lang.Code
+
+	if _, ok := lang.VoskModelPath(); !ok && len(lang.VoskModels) > 0 {
+		fmt.Printf("Warning: no Vosk model for %s found in ~/vosk-models, run the setup script first\n", lang.Name)
+	}
+	fmt.Printf("Language: %s\n", lang.Name)
+	log.Printf("Selected language: %s (%s)", lang.Name, lang.Code)
+
+	log.Println("Starting autospeech (multilingual)")
+	a, err := app.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start: %v\n", err)
+		os.Exit(1)
+	}
+
+	run := a.Run
+	if cfg.Recover {
+		run = a.RunRecover
+	} else if cfg.Headless {
+		run = a.RunHeadless
+	}
+	if err := run(); err != nil {
+		log.Printf("Application error: %v", err)
+		fmt.Fprintf(os.Stderr, "Application error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// promptLanguage asks the user to choose one of the supported languages, defaulting to the first
+func promptLanguage(in io.Reader, out io.Writer) config.Language {
+	fmt.Fprintln(out, "Select recognition language:")
+	for i, lang := range config.Languages {
+		fmt.Fprintf(out, "  %d) %s (%s)\n", i+1, lang.Name, lang.Code)
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprintf(out, "Choice [1]: ")
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return config.Languages[0]
+		}
+		if n, convErr := strconv.Atoi(line); convErr == nil && n >= 1 && n <= len(config.Languages) {
+			return config.Languages[n-1]
+		}
+		if lang, ok := config.LookupLanguage(line); ok {
+			return lang
+		}
+		if err != nil {
+			return config.Languages[0]
+		}
+		fmt.Fprintf(out, "Unknown choice %q\n", line)
+	}
+}
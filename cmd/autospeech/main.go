@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/tarasowski/autospeech/pkg/app"
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+func main() {
+	cfg := config.NewConfig()
+
+	logFile, err := app.SetupLogging(cfg.LogFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer logFile.Close()
+
+	if cfg.ListDevices {
+		if err := app.PrintInputDevices(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	log.Println("Starting autospeech")
+	a, err := app.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start: %v\n", err)
+		os.Exit(1)
+	}
+
+	run := a.Run
+	if cfg.Recover {
+		run = a.RunRecover
+	} else if cfg.Headless {
+		run = a.RunHeadless
+	}
+	if err := run(); err != nil {
+		log.Printf("Application error: %v", err)
+		fmt.Fprintf(os.Stderr, "Application error: %v\n", err)
+		os.Exit(1)
+	}
+}
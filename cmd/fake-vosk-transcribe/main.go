@@ -0,0 +1,57 @@
+// Command fake-vosk-transcribe stands in for the vosk-transcribe script when testing
+// without Python or a model. Install it as vosk-transcribe somewhere in PATH.
+//
+//	fake-vosk-transcribe input.wav   prints one word per second of audio as JSON
+//	fake-vosk-transcribe --worker    speaks the worker protocol on stdin and stdout
+//
+// FAKE_VOSK_LOAD_DELAY (a duration) delays the worker's hello. FAKE_VOSK_CRASH_AFTER,
+// FAKE_VOSK_HANG_AFTER and FAKE_VOSK_WRONG_ID_AFTER (request counts) make the worker
+// exit, stop answering or answer with the wrong id mid-session.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+	"github.com/tarasowski/autospeech/pkg/config"
+	"github.com/tarasowski/autospeech/pkg/transcription/vosktest"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: fake-vosk-transcribe input.wav | --worker")
+		os.Exit(1)
+	}
+
+	if os.Args[1] != "--worker" {
+		data, err := audio.LoadAudio(os.Args[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		json.NewEncoder(os.Stdout).Encode(vosktest.Transcript(len(data), config.SampleRate))
+		return
+	}
+
+	var opts vosktest.Options
+	if delay, err := time.ParseDuration(os.Getenv("FAKE_VOSK_LOAD_DELAY")); err == nil {
+		opts.LoadDelay = delay
+	}
+	if n, err := strconv.Atoi(os.Getenv("FAKE_VOSK_CRASH_AFTER")); err == nil {
+		opts.CrashAfter = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FAKE_VOSK_HANG_AFTER")); err == nil {
+		opts.HangAfter = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("FAKE_VOSK_WRONG_ID_AFTER")); err == nil {
+		opts.WrongIDAfter = n
+	}
+	if err := vosktest.Serve(os.Stdin, os.Stdout, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
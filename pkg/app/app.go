@@ -0,0 +1,754 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/archive"
+	"github.com/tarasowski/autospeech/pkg/audio"
+	"github.com/tarasowski/autospeech/pkg/clipboard"
+	"github.com/tarasowski/autospeech/pkg/config"
+	"github.com/tarasowski/autospeech/pkg/journal"
+	"github.com/tarasowski/autospeech/pkg/transcription"
+	"github.com/tarasowski/autospeech/pkg/ui"
+)
+
+// journalQueueSize is the number of frames the journal may fall behind before holding up the recorder
+const journalQueueSize = 256
+
+// partialQueueSize is the number of frames the partial transcript may fall behind;
+// frames are collected while the recognizer is busy, so it only fills if that stalls
+const partialQueueSize = 256
+
+// Level display settings
+const (
+	levelUpdateInterval = 200 * time.Millisecond
+	mutedWarningAfter   = 3 * time.Second
+)
+
+// App wires the recorder, transcriber, tray menu and clipboard together
+type App struct {
+	cfg         *config.AppConfig
+	state       *config.AppState
+	recorder    *audio.Recorder
+	preroll     *audio.PrerollSource
+	transcriber *transcription.Transcriber
+	tray        *ui.TrayMenu
+	clipMgr     *clipboard.Manager
+	archive     *archive.Archive // Nil unless -archive is set
+	journalDir  string           // Empty when journaling is off
+
+	mu          sync.Mutex
+	sessionDone chan struct{} // Closed when the current session has finished
+	stopSession context.CancelFunc
+	levelWarned bool
+	clipWarned  bool
+	stopLevels  func()
+	stopStates  func()
+	journal     *journal.Writer // Journal of the current session
+	unfinished  []string        // Journals of sessions that never finished
+	recovering  bool
+	quitOnce    sync.Once
+	quit        chan struct{}
+}
+
+// New creates the application and connects the tray callbacks
+func New(cfg *config.AppConfig) (*App, error) {
+	source, err := audio.NewSource(cfg, !cfg.Headless)
+	if err != nil {
+		return nil, err
+	}
+	dsp, err := audio.ParseDSPChain(cfg.DSP)
+	if err != nil {
+		return nil, err
+	}
+	segmenter := audio.DefaultSegmenterConfig()
+	segmenter.MinSegment = cfg.SegmentMin
+	segmenter.MaxSegment = cfg.SegmentMax
+	segmenter.Padding = cfg.SegmentPadding
+	if err := segmenter.Validate(); err != nil {
+		return nil, err
+	}
+	backends, err := transcription.NewBackends(cfg.Backends, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Language != "" && !anyHonoursLanguage(backends) {
+		log.Printf("None of the backends %s select a model by language; -lang %s has no effect", cfg.Backends, cfg.Language)
+	}
+
+	var arch *archive.Archive
+	if cfg.Archive {
+		arch, err = archive.New(cfg.ArchiveDir, archive.Policy{
+			MaxAge:   cfg.ArchiveMaxAge,
+			MaxCount: cfg.ArchiveMaxCount,
+			MaxSize:  cfg.ArchiveMaxSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := arch.SetFormat(cfg.ArchiveFormat); err != nil {
+			return nil, err
+		}
+		log.Printf("Archiving sessions in %s", arch.Dir())
+	}
+
+	state := config.NewAppState(cfg)
+	a := &App{
+		cfg:         cfg,
+		state:       state,
+		recorder:    audio.NewRecorder(state, cfg),
+		transcriber: transcription.NewTranscriber(cfg, state),
+		tray:        ui.NewTrayMenu(state),
+		clipMgr:     clipboard.NewManager(),
+		archive:     arch,
+		quit:        make(chan struct{}),
+	}
+	if cfg.Journal {
+		a.journalDir = cfg.JournalDir
+		if a.journalDir == "" {
+			a.journalDir = journal.DefaultDir()
+		}
+		a.unfinished = a.findUnfinished()
+	}
+	a.recorder.SetSource(source)
+	a.transcriber.SetDSP(dsp)
+	a.transcriber.SetSegmenter(segmenter)
+	a.transcriber.SetBackends(backends)
+	if preroll, ok := source.(*audio.PrerollSource); ok {
+		a.preroll = preroll
+	}
+	a.tray.SetCallbacks(a.StartRecording, a.StopRecording, a.Quit, a.clipMgr.PasteAtCursor)
+	a.tray.SetPauseCallbacks(a.PauseRecording, a.ResumeRecording)
+	a.tray.SetRecoverCallback(a.RecoverDictations)
+
+	levels, stopLevels := a.recorder.Levels().Subscribe()
+	a.stopLevels = stopLevels
+	go a.watchLevels(levels)
+
+	states, stopStates := state.SubscribeState()
+	a.stopStates = stopStates
+	go a.watchState(states)
+	return a, nil
+}
+
+// anyHonoursLanguage reports whether any of the backends uses the -lang setting
+func anyHonoursLanguage(backends []transcription.Backend) bool {
+	for _, b := range backends {
+		if b.Capabilities().Languages {
+			return true
+		}
+	}
+	return false
+}
+
+// Run shows the tray and blocks until the user quits or the process is interrupted
+func (a *App) Run() error {
+	// Start filling the pre-roll window before the first recording
+	if a.preroll != nil {
+		if err := a.preroll.Start(); err != nil {
+			log.Printf("Failed to start pre-roll capture, retrying when recording starts: %v", err)
+		}
+	}
+
+	a.tray.Start()
+	fmt.Println("Speech-to-Text is running. Use the system tray icon to start and stop recording.")
+	if summary := a.unfinishedSummary(); summary != "" {
+		fmt.Printf("%s Choose Recover in the tray menu to transcribe it.\n", summary)
+		a.tray.OfferRecovery(summary)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	select {
+	case <-a.quit:
+	case sig := <-sigChan:
+		log.Printf("Received signal %v, shutting down", sig)
+	}
+
+	a.shutdown()
+	return nil
+}
+
+// RunHeadless records a single session without the tray and prints the transcript.
+// Recording ends when the source runs out of audio or the process is interrupted.
+func (a *App) RunHeadless() error {
+	defer a.transcriber.Close()
+	defer a.state.ResetAudioBuffer()
+	if summary := a.unfinishedSummary(); summary != "" {
+		fmt.Fprintf(os.Stderr, "%s Run with -recover to transcribe it.\n", summary)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	started := time.Now()
+	finishJournal := a.startJournal(started)
+	err := a.recorder.StartRecording(ctx, nil)
+	// A second interrupt while transcribing exits right away
+	stop()
+	if err != nil {
+		finishJournal(false)
+		return err
+	}
+
+	text, err := a.transcribe(started)
+	// Keep the journal until the transcript has been delivered
+	finishJournal(err != nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println(text)
+	return a.state.Transition(config.StateIdle)
+}
+
+// RunRecover transcribes the dictations left unfinished by a crash, prints them and exits
+func (a *App) RunRecover() error {
+	defer a.transcriber.Close()
+	if len(a.unfinished) == 0 {
+		fmt.Println("No unfinished dictations found.")
+		return nil
+	}
+	var failed error
+	for _, path := range a.unfinished {
+		text, err := a.recoverDictation(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not recover %s: %v\n", path, err)
+			failed = err
+			continue
+		}
+		fmt.Println(text)
+	}
+	a.unfinished = nil
+	return failed
+}
+
+// StartRecording begins a new recording session in the background.
+// The session is transcribed once the recording ends, whether stopped by the
+// user or because the source ran out of audio.
+func (a *App) StartRecording() {
+	a.mu.Lock()
+	if a.recovering {
+		a.mu.Unlock()
+		log.Println("Cannot start recording while recovering dictations")
+		return
+	}
+	if a.sessionDone != nil {
+		select {
+		case <-a.sessionDone:
+		default:
+			a.mu.Unlock()
+			log.Printf("Cannot start recording while %v", a.state.State())
+			return
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	a.sessionDone = done
+	a.stopSession = cancel
+	a.levelWarned = false
+	a.clipWarned = false
+	a.mu.Unlock()
+
+	a.state.SetPartialTranscription("")
+	fmt.Println("Recording... speak now.")
+
+	go func() {
+		defer close(done)
+		defer cancel()
+		a.runSession(ctx)
+	}()
+}
+
+// StopRecording ends the current recording; transcription follows in the background
+func (a *App) StopRecording() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopSession != nil {
+		a.stopSession()
+	}
+}
+
+// PauseRecording stops capturing audio without ending the session
+func (a *App) PauseRecording() {
+	if err := a.recorder.Pause(); err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Println("\nPaused.")
+}
+
+// ResumeRecording continues a paused session
+func (a *App) ResumeRecording() {
+	if err := a.recorder.Resume(); err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Println("Resumed... speak now.")
+}
+
+// runSession records until ctx is cancelled or the source ends, then transcribes the result
+func (a *App) runSession(ctx context.Context) {
+	started := time.Now()
+	finishJournal := a.startJournal(started)
+	stopPartials := a.startPartials()
+	err := a.recorder.StartRecording(ctx, nil)
+	stopPartials()
+	if err != nil {
+		finishJournal(false)
+		log.Printf("Recording failed: %v", err)
+		fmt.Fprintf(os.Stderr, "Recording failed: %v\n", err)
+		return
+	}
+
+	// Do not hold up an exit with a transcription nobody will see; the
+	// journal keeps the recording for the next start
+	select {
+	case <-a.quit:
+		finishJournal(true)
+		a.state.Transition(config.StateIdle)
+		return
+	default:
+	}
+
+	fmt.Println("\nProcessing...")
+	text, err := a.transcribe(started)
+	finishJournal(err != nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Transcription failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Transcription: %s\n", text)
+	fmt.Println("(copied to clipboard)")
+	a.tray.SetupForTranscriptionComplete(text)
+	a.state.Transition(config.StateIdle)
+}
+
+// transcribe moves a finished recording to Processing, transcribes it and archives
+// the session. On success the caller delivers the text and returns the session to Idle.
+func (a *App) transcribe(started time.Time) (string, error) {
+	ended := time.Now()
+	if err := a.state.Transition(config.StateProcessing); err != nil {
+		return "", err
+	}
+	result, err := a.transcriber.TranscribeAudio()
+	a.archiveSession(started, ended, result, err)
+	// Nothing reads the audio again; the journal keeps it if transcription failed
+	a.state.ResetAudioBuffer()
+	if err != nil {
+		log.Printf("Transcription failed: %v", err)
+		a.state.Fail(err)
+		return "", err
+	}
+	return result.Text, nil
+}
+
+// archiveSession stores the recording with its transcript and timings, if archiving is enabled.
+// Failures are logged; they never affect the transcription.
+func (a *App) archiveSession(started, ended time.Time, result transcription.Result, transcribeErr error) {
+	if a.archive == nil {
+		return
+	}
+	session := &archive.Session{
+		Started:              started,
+		Ended:                ended,
+		TranscriptionSeconds: time.Since(ended).Seconds(),
+		Language:             a.cfg.Language,
+		Backend:              result.Backend,
+		Input:                a.cfg.Input,
+		DSP:                  a.cfg.DSP,
+		Transcript:           result.Text,
+	}
+	if transcribeErr != nil {
+		session.Backend = ""
+		session.Error = transcribeErr.Error()
+	} else {
+		session.Segments = archiveSegments(result.Segments)
+		if result.Language != "" {
+			session.Language = result.Language
+		}
+	}
+
+	var err error
+	if wavFile := a.state.GetRecordingFile(); wavFile != "" {
+		duration, durErr := audio.AudioDuration(wavFile)
+		if durErr != nil {
+			log.Printf("Not archiving session: %v", durErr)
+			return
+		}
+		session.AudioSeconds = duration.Seconds()
+		err = a.archive.SaveFile(session, wavFile)
+	} else {
+		size := a.state.AudioBufferLen()
+		if size == 0 {
+			log.Println("Not archiving session without audio")
+			return
+		}
+		session.AudioSeconds = float64(size) / float64(config.SampleRate*config.Channels*2)
+		// Stream the buffer so audio spilled to disk is not read back into memory
+		err = a.archive.SaveReader(session, a.state.AudioBufferReader())
+	}
+	if err != nil {
+		log.Printf("Failed to archive session: %v", err)
+		fmt.Fprintf(os.Stderr, "Failed to archive session: %v\n", err)
+	}
+}
+
+// archiveSegments converts utterance transcripts and their words for the archive
+func archiveSegments(segments []transcription.TranscriptSegment) []archive.Segment {
+	var out []archive.Segment
+	for _, seg := range segments {
+		s := archive.Segment{
+			Start: seg.Start.Seconds(),
+			End:   seg.End.Seconds(),
+			Text:  seg.Text,
+		}
+		for _, w := range seg.Words {
+			s.Words = append(s.Words, archive.Word{
+				Text:       w.Text,
+				Start:      w.Start.Seconds(),
+				End:        w.End.Seconds(),
+				Confidence: w.Confidence,
+			})
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Quit requests the application to shut down
+func (a *App) Quit() {
+	a.quitOnce.Do(func() { close(a.quit) })
+}
+
+// shutdown stops any active session before exit
+func (a *App) shutdown() {
+	a.mu.Lock()
+	done := a.sessionDone
+	a.mu.Unlock()
+	a.Quit()
+	a.StopRecording()
+	if done != nil {
+		<-done
+	}
+	// Remove any spill file of a session that was never transcribed
+	a.state.ResetAudioBuffer()
+
+	a.stopLevels()
+	a.stopStates()
+	a.transcriber.Close()
+	if a.preroll != nil {
+		if err := a.preroll.Shutdown(); err != nil {
+			log.Printf("Failed to close audio capture: %v", err)
+		}
+	}
+	log.Println("Application stopped")
+}
+
+// startPartials streams the recording to a recognizer and prints its text as it
+// changes. The returned function stops it; the recognizer finishes its last update
+// in the background so the final transcription does not wait for it.
+func (a *App) startPartials() func() {
+	rec := a.transcriber.NewRecognizer()
+	sub := a.recorder.Frames().Subscribe("partials", partialQueueSize, audio.Block)
+	batches := make(chan []audio.Frame)
+
+	go func() {
+		defer rec.Close()
+		for frames := range batches {
+			if err := rec.Feed(context.Background(), frames); err != nil {
+				log.Printf("Partial transcription failed: %v", err)
+				continue
+			}
+			a.showPartial(rec.Partial())
+		}
+	}()
+
+	go func() {
+		defer close(batches)
+		// Collect frames while the recognizer is busy and hand them over together
+		var pending []audio.Frame
+		for frame := range sub.Frames() {
+			if frame.End {
+				continue
+			}
+			pending = append(pending, frame)
+			if !a.state.ShouldUpdatePartialTranscription() {
+				continue
+			}
+			select {
+			case batches <- pending:
+				pending = nil
+				a.state.UpdatePartialTranscriptionTime()
+			default:
+			}
+		}
+	}()
+
+	return sub.Unsubscribe
+}
+
+// showPartial prints a partial transcript that changed and keeps it in the journal
+func (a *App) showPartial(text string) {
+	if text == "" || !a.state.IsRecording() || text == a.state.GetPartialTranscription() {
+		return
+	}
+	a.state.SetPartialTranscription(text)
+	fmt.Printf("\r\033[K%s", text)
+
+	a.mu.Lock()
+	w := a.journal
+	a.mu.Unlock()
+	if w != nil {
+		w.WritePartial(text)
+	}
+}
+
+// watchLevels shows the input level in the tray and warns about a muted or clipping microphone
+func (a *App) watchLevels(levels <-chan audio.Level) {
+	var lastUpdate time.Time
+	for level := range levels {
+		// A paused session is expected to be quiet and keeps its paused title
+		if a.state.State() != config.StateRecording {
+			continue
+		}
+		if !a.cfg.Headless && time.Since(lastUpdate) >= levelUpdateInterval {
+			a.tray.ShowInputLevel(level)
+			lastUpdate = time.Now()
+		}
+
+		a.mu.Lock()
+		warnMuted := level.SilentFor >= mutedWarningAfter && !a.levelWarned
+		if warnMuted {
+			a.levelWarned = true
+		}
+		warnClipping := level.Clipping && !a.clipWarned
+		if warnClipping {
+			a.clipWarned = true
+		}
+		a.mu.Unlock()
+
+		if warnMuted {
+			log.Printf("No input above %.0f dBFS for %v", audio.SilenceLevelDB, mutedWarningAfter)
+			fmt.Fprintf(os.Stderr, "\nNo input detected for %v - is the microphone muted?\n", mutedWarningAfter)
+		}
+		if warnClipping {
+			log.Printf("Input is clipping (peak %.1f dBFS)", level.Peak)
+			fmt.Fprintln(os.Stderr, "\nInput is clipping - lower the microphone gain.")
+		}
+	}
+}
+
+// watchState reports device failures and recoveries in the terminal
+func (a *App) watchState(events <-chan config.StateEvent) {
+	for ev := range events {
+		switch {
+		case ev.To == config.StateRecovering:
+			fmt.Fprintf(os.Stderr, "\nMicrophone lost (%v), reconnecting...\n", ev.Err)
+		case ev.From == config.StateRecovering && (ev.To == config.StateRecording || ev.To == config.StatePaused):
+			fmt.Fprintln(os.Stderr, "Microphone reconnected, recording continues.")
+		case ev.From == config.StateRecovering && ev.Err != nil:
+			fmt.Fprintf(os.Stderr, "Could not reconnect the microphone: %v\n", ev.Err)
+			if ev.To == config.StateStopping {
+				fmt.Fprintln(os.Stderr, "Transcribing what was recorded so far.")
+			}
+		}
+	}
+}
+
+// startJournal journals the frames of the recording that is about to start. The
+// returned function waits for the journal to catch up and closes it; the journal
+// file is kept for recovery if keep is set and deleted otherwise.
+func (a *App) startJournal(started time.Time) func(keep bool) {
+	if a.journalDir == "" {
+		return func(bool) {}
+	}
+	w, err := journal.Create(a.journalDir, journal.Meta{
+		Started:    started,
+		SampleRate: config.SampleRate,
+		Channels:   config.Channels,
+		Input:      a.cfg.Input,
+		Language:   a.cfg.Language,
+	})
+	if err != nil {
+		log.Printf("Recording without a journal: %v", err)
+		return func(bool) {}
+	}
+
+	sub := a.recorder.Frames().Subscribe("journal", journalQueueSize, audio.Block)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Follow(sub)
+	}()
+	a.mu.Lock()
+	a.journal = w
+	a.mu.Unlock()
+
+	return func(keep bool) {
+		// Queued frames are still delivered after unsubscribing
+		sub.Unsubscribe()
+		<-done
+		a.mu.Lock()
+		a.journal = nil
+		a.mu.Unlock()
+
+		if keep {
+			if err := w.Close(); err != nil {
+				log.Printf("Failed to close journal: %v", err)
+			}
+			log.Printf("Kept journal %s for recovery", w.Path())
+			return
+		}
+		if err := w.Remove(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// findUnfinished returns the journals of earlier sessions that hold audio.
+// Journals without audio are deleted.
+func (a *App) findUnfinished() []string {
+	paths, err := journal.Find(a.journalDir)
+	if err != nil {
+		log.Printf("Failed to look for unfinished dictations: %v", err)
+		return nil
+	}
+	var unfinished []string
+	for _, path := range paths {
+		session, err := journal.Open(path)
+		if errors.Is(err, journal.ErrLocked) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Discarding unreadable journal: %v", err)
+			journal.Remove(path)
+			continue
+		}
+		if session.Frames == 0 {
+			journal.Remove(path)
+			continue
+		}
+		log.Printf("Found unfinished dictation %s: %v of audio, recording ended: %v, truncated: %v",
+			path, session.Duration(), session.Ended, session.Truncated)
+		unfinished = append(unfinished, path)
+	}
+	return unfinished
+}
+
+// unfinishedSummary describes the dictations waiting for recovery, or returns "" if there are none
+func (a *App) unfinishedSummary() string {
+	a.mu.Lock()
+	count := len(a.unfinished)
+	a.mu.Unlock()
+	switch count {
+	case 0:
+		return ""
+	case 1:
+		return "Found an unfinished dictation from an earlier run."
+	}
+	return fmt.Sprintf("Found %d unfinished dictations from earlier runs.", count)
+}
+
+// RecoverDictations transcribes the unfinished dictations in the background and
+// delivers the text like a normal session. It only runs while no session is active
+// and holds the session in Processing until it is done, so no recording can start meanwhile.
+func (a *App) RecoverDictations() {
+	a.mu.Lock()
+	if a.recovering || len(a.unfinished) == 0 {
+		a.mu.Unlock()
+		return
+	}
+	// A failed session is over as well
+	a.state.TransitionFrom(config.StateError, config.StateIdle)
+	if err := a.state.TransitionFrom(config.StateIdle, config.StateProcessing); err != nil {
+		a.mu.Unlock()
+		log.Printf("Not recovering dictations: %v", err)
+		fmt.Println("Finish the current dictation before recovering unfinished ones.")
+		return
+	}
+	a.recovering = true
+	paths := a.unfinished
+	a.mu.Unlock()
+
+	go func() {
+		var texts, failed []string
+		var lastErr error
+		for _, path := range paths {
+			text, err := a.recoverDictation(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not recover dictation: %v\n", err)
+				failed = append(failed, path)
+				lastErr = err
+				continue
+			}
+			texts = append(texts, text)
+		}
+
+		if len(texts) > 0 {
+			text := strings.Join(texts, "\n\n")
+			fmt.Printf("Recovered: %s\n", text)
+			fmt.Println("(copied to clipboard)")
+			a.tray.SetupForTranscriptionComplete(text)
+			a.state.Transition(config.StateIdle)
+		} else {
+			a.state.Fail(lastErr)
+		}
+
+		a.mu.Lock()
+		a.unfinished = failed
+		a.recovering = false
+		a.mu.Unlock()
+		a.tray.OfferRecovery(a.unfinishedSummary())
+	}()
+}
+
+// recoverDictation transcribes a journaled session, archives it and deletes the journal.
+// The journal is kept if transcription fails so that it can be tried again.
+func (a *App) recoverDictation(path string) (string, error) {
+	session, err := journal.Open(path)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Recovering dictation from %v (%v of audio)", session.Meta.Started, session.Duration())
+
+	start := time.Now()
+	result, err := a.transcriber.TranscribePCM(session.Audio)
+	if err != nil {
+		return "", err
+	}
+	if a.archive != nil {
+		language := session.Meta.Language
+		if result.Language != "" {
+			language = result.Language
+		}
+		err := a.archive.Save(&archive.Session{
+			Started:              session.Meta.Started,
+			Ended:                session.Meta.Started.Add(session.Duration()),
+			AudioSeconds:         session.Duration().Seconds(),
+			TranscriptionSeconds: time.Since(start).Seconds(),
+			Language:             language,
+			Backend:              result.Backend,
+			Input:                session.Meta.Input,
+			DSP:                  a.cfg.DSP,
+			Transcript:           result.Text,
+			Segments:             archiveSegments(result.Segments),
+		}, session.Audio)
+		if err != nil {
+			log.Printf("Failed to archive recovered dictation: %v", err)
+		}
+	}
+	if err := journal.Remove(path); err != nil {
+		log.Println(err)
+	}
+	return result.Text, nil
+}
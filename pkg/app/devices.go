@@ -0,0 +1,30 @@
+package app
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+)
+
+// PrintInputDevices writes the available input devices as a table
+func PrintInputDevices(w io.Writer) error {
+	devices, err := audio.ListInputDevices()
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		fmt.Fprintln(w, "No audio input devices found")
+		return nil
+	}
+
+	fmt.Fprintf(w, "%-5s %-40s %-20s %8s %11s\n", "INDEX", "NAME", "HOST API", "CHANNELS", "SAMPLE RATE")
+	for _, dev := range devices {
+		name := dev.Name
+		if dev.IsDefault {
+			name += " (default)"
+		}
+		fmt.Fprintf(w, "%-5d %-40s %-20s %8d %11.0f\n", dev.Index, name, dev.HostAPI, dev.MaxChannels, dev.DefaultSampleRate)
+	}
+	return nil
+}
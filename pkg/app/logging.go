@@ -0,0 +1,18 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// SetupLogging redirects the standard logger to the given file so the terminal only shows transcripts
+func SetupLogging(path string) (*os.File, error) {
+	logFile, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file %s: %v", path, err)
+	}
+	log.SetOutput(logFile)
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	return logFile, nil
+}
@@ -0,0 +1,314 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+)
+
+// File names inside a session directory; the audio file is audio.flac or audio.wav
+const (
+	audioFileBase      = "audio"
+	transcriptFileName = "transcript.txt"
+	metadataFileName   = "session.json"
+)
+
+// Formats lists the audio formats the archive can store
+var Formats = []string{"flac", "wav"}
+
+// Policy limits how much the archive keeps; zero values mean no limit
+type Policy struct {
+	MaxAge   time.Duration // Remove sessions older than this
+	MaxCount int           // Keep at most this many sessions
+	MaxSize  int64         // Keep the archive below this many bytes
+}
+
+// Session describes one archived recording
+type Session struct {
+	ID                   string    `json:"id"`
+	Started              time.Time `json:"started"`
+	Ended                time.Time `json:"ended"`
+	AudioSeconds         float64   `json:"audio_seconds"`
+	TranscriptionSeconds float64   `json:"transcription_seconds"`
+	Language             string    `json:"language,omitempty"`
+	Backend              string    `json:"backend,omitempty"`
+	Input                string    `json:"input,omitempty"`
+	DSP                  string    `json:"dsp,omitempty"`
+	Transcript           string    `json:"transcript"`
+	Segments             []Segment `json:"segments,omitempty"`
+	Error                string    `json:"error,omitempty"`
+	AudioFile            string    `json:"audio_file"`
+
+	dir  string
+	size int64
+}
+
+// Segment is the transcript of one utterance of a session
+type Segment struct {
+	Start float64 `json:"start"` // Seconds from the start of the recording
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+	Words []Word  `json:"words,omitempty"` // Only for backends that report words
+}
+
+// Word is one recognized word of a segment
+type Word struct {
+	Text       string  `json:"text"`
+	Start      float64 `json:"start"` // Seconds from the start of the recording
+	End        float64 `json:"end"`
+	Confidence float64 `json:"confidence,omitempty"` // From 0 to 1, if the backend reports it
+}
+
+// Dir returns the session's directory
+func (s *Session) Dir() string {
+	return s.dir
+}
+
+// AudioPath returns the path of the session's audio file
+func (s *Session) AudioPath() string {
+	return filepath.Join(s.dir, s.AudioFile)
+}
+
+// Archive stores recordings, transcripts and metadata in one directory per session
+type Archive struct {
+	dir    string
+	policy Policy
+	format string
+}
+
+// DefaultDir returns the archive location under the XDG data directory,
+// $XDG_DATA_HOME/autospeech/recordings or ~/.local/share/autospeech/recordings
+func DefaultDir() string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = "."
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "autospeech", "recordings")
+}
+
+// New opens the archive at dir, creating it if needed
+func New(dir string, policy Policy) (*Archive, error) {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %v", err)
+	}
+	return &Archive{dir: dir, policy: policy, format: "flac"}, nil
+}
+
+// SetFormat selects the audio format of new sessions, flac (the default) or wav
+func (a *Archive) SetFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			a.format = format
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown archive format %q (use %s)", format, strings.Join(Formats, " or "))
+}
+
+// Dir returns the archive directory
+func (a *Archive) Dir() string {
+	return a.dir
+}
+
+// Save archives a session with its audio given as 16-bit PCM in the recognizer format,
+// then applies the retention policy
+func (a *Archive) Save(session *Session, audioData []byte) error {
+	return a.save(session, func(path string) error {
+		return audio.SaveAudio(audioData, path)
+	})
+}
+
+// SaveReader archives a session with its audio streamed from r as 16-bit PCM in the
+// recognizer format, then applies the retention policy
+func (a *Archive) SaveReader(session *Session, r io.Reader) error {
+	return a.save(session, func(path string) error {
+		return audio.SaveAudioFrom(r, path)
+	})
+}
+
+// SaveFile archives a session whose audio is already in a WAV or FLAC file.
+// The file is copied, or converted if it is not in the archive format.
+func (a *Archive) SaveFile(session *Session, audioFile string) error {
+	return a.save(session, func(path string) error {
+		if audio.IsFlacPath(audioFile) == audio.IsFlacPath(path) {
+			return copyFile(audioFile, path)
+		}
+		return audio.TranscodeFile(audioFile, path)
+	})
+}
+
+// save creates the session directory, writes the audio with writeAudio and the metadata
+func (a *Archive) save(session *Session, writeAudio func(path string) error) error {
+	dir, id, err := a.createSessionDir(session.Started)
+	if err != nil {
+		return err
+	}
+	session.ID = id
+	session.dir = dir
+	session.AudioFile = audioFileBase + "." + a.format
+
+	if err := writeSession(dir, session, writeAudio); err != nil {
+		// List skips directories without metadata, so Prune would never remove it
+		os.RemoveAll(dir)
+		return err
+	}
+	log.Printf("Archived session %s in %s", id, dir)
+
+	if err := a.Prune(); err != nil {
+		log.Printf("Failed to apply archive retention: %v", err)
+	}
+	return nil
+}
+
+// writeSession writes the audio, transcript and metadata into the session directory
+func writeSession(dir string, session *Session, writeAudio func(path string) error) error {
+	if err := writeAudio(filepath.Join(dir, session.AudioFile)); err != nil {
+		return fmt.Errorf("failed to archive audio: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, transcriptFileName), []byte(session.Transcript+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to archive transcript: %v", err)
+	}
+	metadata, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, metadataFileName), append(metadata, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to archive session metadata: %v", err)
+	}
+	return nil
+}
+
+// createSessionDir makes a directory named after the start time, adding a suffix if it is taken
+func (a *Archive) createSessionDir(started time.Time) (string, string, error) {
+	base := started.Format("20060102-150405")
+	for i := 0; i < 100; i++ {
+		id := base
+		if i > 0 {
+			id = fmt.Sprintf("%s-%d", base, i)
+		}
+		dir := filepath.Join(a.dir, id)
+		err := os.Mkdir(dir, 0700)
+		if err == nil {
+			return dir, id, nil
+		}
+		if !os.IsExist(err) {
+			return "", "", fmt.Errorf("failed to create session directory: %v", err)
+		}
+	}
+	return "", "", fmt.Errorf("failed to create session directory for %s", base)
+}
+
+// List returns the archived sessions, oldest first
+func (a *Archive) List() ([]*Session, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %v", err)
+	}
+
+	var sessions []*Session
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(a.dir, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, metadataFileName))
+		if err != nil {
+			// Not a session, or one that was interrupted while saving
+			continue
+		}
+		session := &Session{}
+		if err := json.Unmarshal(data, session); err != nil {
+			log.Printf("Skipping archived session %s: %v", entry.Name(), err)
+			continue
+		}
+		session.ID = entry.Name()
+		session.dir = dir
+		session.size = dirSize(dir)
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].Started.Equal(sessions[j].Started) {
+			return sessions[i].Started.Before(sessions[j].Started)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+// Prune removes the oldest sessions until the archive satisfies its policy.
+// The newest session is always kept.
+func (a *Archive) Prune() error {
+	sessions, err := a.List()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, s := range sessions {
+		total += s.size
+	}
+	now := time.Now()
+	for len(sessions) > 1 {
+		oldest := sessions[0]
+		tooMany := a.policy.MaxCount > 0 && len(sessions) > a.policy.MaxCount
+		tooOld := a.policy.MaxAge > 0 && now.Sub(oldest.Started) > a.policy.MaxAge
+		tooBig := a.policy.MaxSize > 0 && total > a.policy.MaxSize
+		if !tooMany && !tooOld && !tooBig {
+			break
+		}
+		if err := os.RemoveAll(oldest.dir); err != nil {
+			return fmt.Errorf("failed to remove archived session %s: %v", oldest.ID, err)
+		}
+		log.Printf("Removed archived session %s", oldest.ID)
+		total -= oldest.size
+		sessions = sessions[1:]
+	}
+	return nil
+}
+
+// dirSize returns the total size of the files in dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// copyFile copies src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
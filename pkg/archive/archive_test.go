@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionAges are the ages of the test sessions, oldest first
+var sessionAges = []time.Duration{96 * time.Hour, 72 * time.Hour, 48 * time.Hour, 24 * time.Hour, time.Hour}
+
+// fillArchive writes one session of the same size per entry of sessionAges and
+// returns their directories, oldest first
+func fillArchive(t *testing.T, a *Archive, now time.Time) []string {
+	t.Helper()
+	var dirs []string
+	for i, age := range sessionAges {
+		started := now.Add(-age)
+		dir, id, err := a.createSessionDir(started)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session := &Session{ID: id, Started: started, Ended: started.Add(time.Minute), Transcript: "test", AudioFile: "audio.wav"}
+		err = writeSession(dir, session, func(path string) error {
+			return os.WriteFile(path, make([]byte, 10000), 0600)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The mtimes run the other way, as after copying an archive: ages come from
+		// the session metadata
+		mtime := now.Add(-sessionAges[len(sessionAges)-1-i])
+		if err := os.Chtimes(dir, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name   string
+		policy Policy
+		size   float64 // MaxSize in sessions, as sizes depend on the metadata
+		kept   int     // Newest sessions left
+	}{
+		{name: "no limits", kept: 5},
+		{name: "count", policy: Policy{MaxCount: 3}, kept: 3},
+		{name: "count above the sessions", policy: Policy{MaxCount: 10}, kept: 5},
+		{name: "age", policy: Policy{MaxAge: 36 * time.Hour}, kept: 2},
+		{name: "age at a session", policy: Policy{MaxAge: 73 * time.Hour}, kept: 4},
+		{name: "size", size: 3.5, kept: 3},
+		{name: "size of exactly two sessions", size: 2, kept: 2},
+		{name: "strictest rule wins", policy: Policy{MaxCount: 4, MaxAge: 30 * time.Hour}, size: 10, kept: 2},
+		{name: "newest kept when too old", policy: Policy{MaxAge: time.Minute}, kept: 1},
+		{name: "newest kept when too big", size: 0.5, kept: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(t.TempDir(), tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dirs := fillArchive(t, a, now)
+			// A directory that was never finished is not a session and stays
+			incomplete := filepath.Join(a.Dir(), "incomplete")
+			if err := os.Mkdir(incomplete, 0700); err != nil {
+				t.Fatal(err)
+			}
+			if tt.size > 0 {
+				sessions, err := a.List()
+				if err != nil {
+					t.Fatal(err)
+				}
+				a.policy.MaxSize = int64(tt.size * float64(sessions[0].size))
+			}
+
+			if err := a.Prune(); err != nil {
+				t.Fatalf("Prune: %v", err)
+			}
+			sessions, err := a.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, s := range sessions {
+				got = append(got, s.Dir())
+			}
+			// The oldest go first, so what is left is the newest
+			want := dirs[len(dirs)-tt.kept:]
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("kept %d sessions %v, want the newest %d %v", len(got), got, tt.kept, want)
+			}
+			for _, dir := range dirs[:len(dirs)-tt.kept] {
+				if _, err := os.Stat(dir); !os.IsNotExist(err) {
+					t.Errorf("pruned session %s still on disk (err %v)", filepath.Base(dir), err)
+				}
+			}
+			if _, err := os.Stat(incomplete); err != nil {
+				t.Errorf("directory without metadata removed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package audio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AudioWriter streams 16-bit PCM to an audio file; WavWriter and FlacWriter implement it
+type AudioWriter interface {
+	Write(p []byte) (int, error)
+	WriteSamples(samples []int16) error
+	DataSize() int64
+	Close() error
+}
+
+// SampleReader decodes an audio file into interleaved 16-bit samples; WavReader and FlacReader implement it
+type SampleReader interface {
+	Format() Format
+	BitsPerSample() int
+	ReadSamples(buf []int16) (int, error)
+}
+
+// IsFlacPath reports whether path has a .flac extension
+func IsFlacPath(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".flac")
+}
+
+// CreateAudioFile creates a FLAC file if path ends in .flac and a WAV file otherwise
+func CreateAudioFile(path string, format Format) (AudioWriter, error) {
+	if IsFlacPath(path) {
+		return CreateFlac(path, format)
+	}
+	return CreateWav(path, format)
+}
+
+// SaveAudio writes recognizer-format PCM to a FLAC or WAV file depending on the extension of path
+func SaveAudio(audioData []byte, path string) error {
+	if IsFlacPath(path) {
+		return SaveAsFlac(audioData, path)
+	}
+	return SaveAsWav(audioData, path)
+}
+
+// SaveAudioFrom streams recognizer-format PCM from r to a FLAC or WAV file depending on
+// the extension of path, without holding all of it in memory
+func SaveAudioFrom(r io.Reader, path string) error {
+	writer, err := CreateAudioFile(path, RecognizerFormat)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// NewAudioReader detects whether r holds WAV or FLAC and returns a reader positioned at the first sample
+func NewAudioReader(r io.Reader) (SampleReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audio file: too short")
+	}
+	if string(magic) == flacMarker || string(magic[:3]) == "ID3" {
+		return NewFlacReader(br)
+	}
+	return NewWavReader(br)
+}
+
+// LoadAudio reads a WAV or FLAC file and returns its audio as 16-bit PCM bytes in the recognizer format
+func LoadAudio(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := NewAudioReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	data, err := decodeToRecognizerFormat(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return data, nil
+}
+
+// AudioDuration reads the header of a WAV or FLAC file and returns the length of its audio
+func AudioDuration(path string) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := NewAudioReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	frames := int64(-1)
+	switch r := reader.(type) {
+	case *WavReader:
+		if r.remaining >= 0 {
+			frames = r.remaining / int64(r.blockAlign)
+		}
+	case *FlacReader:
+		if r.totalSamples > 0 {
+			frames = int64(r.totalSamples)
+		}
+	}
+	if frames < 0 {
+		return 0, fmt.Errorf("%s: length not recorded in header", path)
+	}
+	return time.Duration(frames) * time.Second / time.Duration(reader.Format().SampleRate), nil
+}
+
+// TranscodeFile copies the audio of a WAV or FLAC file to dst, choosing the output
+// format from the extension of dst. Samples wider than 16 bits are reduced to 16.
+func TranscodeFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer in.Close()
+
+	reader, err := NewAudioReader(in)
+	if err != nil {
+		return fmt.Errorf("%s: %v", src, err)
+	}
+	writer, err := CreateAudioFile(dst, reader.Format())
+	if err != nil {
+		return err
+	}
+	buf := make([]int16, 4096*reader.Format().Channels)
+	for {
+		n, err := reader.ReadSamples(buf)
+		if n > 0 {
+			if werr := writer.WriteSamples(buf[:n]); werr != nil {
+				writer.Close()
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writer.Close()
+			return fmt.Errorf("%s: %v", src, err)
+		}
+	}
+	return writer.Close()
+}
@@ -0,0 +1,168 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// SpectralSubtraction reduces stationary background noise such as fans and hiss.
+// It estimates the noise spectrum from the quietest frames of the recording and
+// subtracts it from every frame, keeping the original phase.
+type SpectralSubtraction struct {
+	FrameSize       int     // FFT size in samples, a power of two
+	OverSubtraction float64 // Multiple of the noise spectrum to subtract
+	SpectralFloor   float64 // Fraction of the original magnitude always kept, limits musical noise
+	NoiseFraction   float64 // Fraction of quietest frames used for the noise estimate
+}
+
+// NewSpectralSubtraction creates a noise reducer with settings suited to 16 kHz speech
+func NewSpectralSubtraction() *SpectralSubtraction {
+	return &SpectralSubtraction{
+		FrameSize:       512,
+		OverSubtraction: 1.5,
+		SpectralFloor:   0.05,
+		NoiseFraction:   0.1,
+	}
+}
+
+// Name returns the stage name with its over-subtraction factor
+func (s *SpectralSubtraction) Name() string {
+	return fmt.Sprintf("denoise=%g", s.OverSubtraction)
+}
+
+// Process returns the denoised recording. Recordings shorter than a few frames are returned unchanged.
+func (s *SpectralSubtraction) Process(samples []float64, sampleRate int) []float64 {
+	size := s.FrameSize
+	if size < 16 || size&(size-1) != 0 {
+		return samples
+	}
+	hop := size / 2
+	if len(samples) < 4*size {
+		return samples
+	}
+
+	// Square-root Hann windows on analysis and synthesis overlap-add to unity at 50% overlap
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = math.Sqrt(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size)))
+	}
+
+	// Pad so that every sample is covered by two frames
+	padded := make([]float64, len(samples)+2*size)
+	copy(padded[size:], samples)
+	frames := (len(padded)-size)/hop + 1
+
+	noise := s.estimateNoise(padded, window, frames, hop)
+	out := make([]float64, len(padded))
+	spectrum := make([]complex128, size)
+	for f := 0; f < frames; f++ {
+		start := f * hop
+		for i := range spectrum {
+			spectrum[i] = complex(padded[start+i]*window[i], 0)
+		}
+		fft(spectrum, false)
+
+		for k := 0; k <= size/2; k++ {
+			magnitude := cmplx.Abs(spectrum[k])
+			if magnitude == 0 {
+				continue
+			}
+			reduced := math.Max(magnitude-s.OverSubtraction*noise[k], s.SpectralFloor*magnitude)
+			spectrum[k] *= complex(reduced/magnitude, 0)
+			// Keep the spectrum conjugate-symmetric so the output stays real
+			if k > 0 && k < size/2 {
+				spectrum[size-k] = cmplx.Conj(spectrum[k])
+			}
+		}
+
+		fft(spectrum, true)
+		for i := range spectrum {
+			out[start+i] += real(spectrum[i]) * window[i]
+		}
+	}
+	return out[size : size+len(samples)]
+}
+
+// estimateNoise averages the magnitude spectra of the quietest frames
+func (s *SpectralSubtraction) estimateNoise(padded, window []float64, frames, hop int) []float64 {
+	size := len(window)
+	type frameEnergy struct {
+		index  int
+		energy float64
+	}
+	// Skip the padding frames at both ends; they are silent by construction
+	var energies []frameEnergy
+	for f := 2; f < frames-2; f++ {
+		sum := 0.0
+		for i, w := range window {
+			x := padded[f*hop+i] * w
+			sum += x * x
+		}
+		energies = append(energies, frameEnergy{f, sum})
+	}
+	sort.Slice(energies, func(i, j int) bool { return energies[i].energy < energies[j].energy })
+
+	count := max(1, int(float64(len(energies))*s.NoiseFraction))
+	noise := make([]float64, size/2+1)
+	spectrum := make([]complex128, size)
+	for _, fe := range energies[:count] {
+		start := fe.index * hop
+		for i := range spectrum {
+			spectrum[i] = complex(padded[start+i]*window[i], 0)
+		}
+		fft(spectrum, false)
+		for k := range noise {
+			noise[k] += cmplx.Abs(spectrum[k])
+		}
+	}
+	for k := range noise {
+		noise[k] /= float64(count)
+	}
+	return noise
+}
+
+// fft computes an in-place radix-2 FFT; len(x) must be a power of two.
+// The inverse transform is scaled by 1/len(x).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+
+	// Bit-reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for length := 2; length <= n; length <<= 1 {
+		angle := sign * 2 * math.Pi / float64(length)
+		step := complex(math.Cos(angle), math.Sin(angle))
+		for start := 0; start < n; start += length {
+			w := complex(1, 0)
+			for k := 0; k < length/2; k++ {
+				a := x[start+k]
+				b := x[start+k+length/2] * w
+				x[start+k] = a + b
+				x[start+k+length/2] = a - b
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package audio
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// InputDevice describes an audio device that can capture audio
+type InputDevice struct {
+	Index             int // Position in the portaudio device list, usable as a selector
+	Name              string
+	HostAPI           string
+	MaxChannels       int
+	DefaultSampleRate float64
+	IsDefault         bool
+}
+
+// ListInputDevices returns all devices with at least one input channel
+func ListInputDevices() ([]InputDevice, error) {
+	if err := portaudio.Initialize(); err != nil {
+		return nil, fmt.Errorf("failed to initialize audio: %v", err)
+	}
+	defer portaudio.Terminate()
+
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audio devices: %v", err)
+	}
+	defaultDevice, _ := portaudio.DefaultInputDevice()
+
+	var inputs []InputDevice
+	for i, dev := range devices {
+		if dev.MaxInputChannels < 1 {
+			continue
+		}
+		hostAPI := ""
+		if dev.HostApi != nil {
+			hostAPI = dev.HostApi.Name
+		}
+		inputs = append(inputs, InputDevice{
+			Index:             i,
+			Name:              dev.Name,
+			HostAPI:           hostAPI,
+			MaxChannels:       dev.MaxInputChannels,
+			DefaultSampleRate: dev.DefaultSampleRate,
+			IsDefault:         dev == defaultDevice,
+		})
+	}
+	return inputs, nil
+}
+
+// findInputDevice resolves a device selector to a portaudio device.
+// The selector is either an index from ListInputDevices or a (partial, case-insensitive) name.
+// An empty selector, or one that no longer matches a device, yields the default input device.
+// portaudio must be initialized.
+func findInputDevice(selector string) (*portaudio.DeviceInfo, error) {
+	selector = strings.TrimSpace(selector)
+	if selector != "" && selector != "default" {
+		dev, err := matchInputDevice(selector)
+		if err == nil {
+			return dev, nil
+		}
+		log.Printf("Input device %q unavailable (%v), falling back to the default device", selector, err)
+	}
+
+	dev, err := portaudio.DefaultInputDevice()
+	if err != nil {
+		return nil, fmt.Errorf("no default input device: %v", err)
+	}
+	return dev, nil
+}
+
+// matchInputDevice finds an input device by index, exact name or name substring
+func matchInputDevice(selector string) (*portaudio.DeviceInfo, error) {
+	devices, err := portaudio.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	if index, err := strconv.Atoi(selector); err == nil {
+		if index < 0 || index >= len(devices) || devices[index].MaxInputChannels < 1 {
+			return nil, fmt.Errorf("no input device with index %d", index)
+		}
+		return devices[index], nil
+	}
+
+	lower := strings.ToLower(selector)
+	var partial *portaudio.DeviceInfo
+	for _, dev := range devices {
+		if dev.MaxInputChannels < 1 {
+			continue
+		}
+		name := strings.ToLower(dev.Name)
+		if name == lower {
+			return dev, nil
+		}
+		if partial == nil && strings.Contains(name, lower) {
+			partial = dev
+		}
+	}
+	if partial != nil {
+		return partial, nil
+	}
+	return nil, fmt.Errorf("no input device named %q", selector)
+}
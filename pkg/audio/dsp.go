@@ -0,0 +1,148 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DSPStage is one step of the preprocessing chain. Stages work on a whole mono
+// recording of float samples in [-1, 1] and can be used on their own.
+type DSPStage interface {
+	// Name identifies the stage in logs and in the -dsp option
+	Name() string
+	// Process filters samples recorded at sampleRate and returns the result,
+	// which may share memory with samples
+	Process(samples []float64, sampleRate int) []float64
+}
+
+// DSPChain runs its stages in order
+type DSPChain []DSPStage
+
+// ParseDSPChain builds a chain from a comma separated list of stages, each
+// optionally followed by =<value>:
+//
+//	dc                  remove DC offset
+//	highpass[=<hz>]     high-pass filter, default 80 Hz
+//	gate[=<dBFS>]       noise gate, default -45 dBFS
+//	denoise[=<factor>]  spectral subtraction, default over-subtraction 1.5
+//	normalize[=<dBFS>]  peak normalization, default -1 dBFS
+//	rms[=<dBFS>]        RMS normalization, default -20 dBFS
+//
+// An empty spec returns an empty chain.
+func ParseDSPChain(spec string) (DSPChain, error) {
+	var chain DSPChain
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, valueStr, hasValue := strings.Cut(item, "=")
+		var value float64
+		if hasValue {
+			v, err := strconv.ParseFloat(valueStr, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for DSP stage %q: %q", name, valueStr)
+			}
+			value = v
+		}
+
+		switch name {
+		case "dc":
+			if hasValue {
+				return nil, fmt.Errorf("DSP stage dc takes no value")
+			}
+			chain = append(chain, DCRemoval{})
+		case "highpass":
+			hp := NewHighPass(80)
+			if hasValue {
+				if value <= 0 {
+					return nil, fmt.Errorf("high-pass cutoff must be positive, got %v", value)
+				}
+				hp.Cutoff = value
+			}
+			chain = append(chain, hp)
+		case "gate":
+			gate := NewNoiseGate(-45)
+			if hasValue {
+				gate.ThresholdDB = value
+			}
+			chain = append(chain, gate)
+		case "denoise":
+			ss := NewSpectralSubtraction()
+			if hasValue {
+				if value <= 0 {
+					return nil, fmt.Errorf("denoise factor must be positive, got %v", value)
+				}
+				ss.OverSubtraction = value
+			}
+			chain = append(chain, ss)
+		case "normalize", "rms":
+			n := NewPeakNormalizer(-1)
+			if name == "rms" {
+				n = NewRMSNormalizer(-20)
+			}
+			if hasValue {
+				if value > 0 {
+					return nil, fmt.Errorf("normalization target must be at most 0 dBFS, got %v", value)
+				}
+				n.TargetDB = value
+			}
+			chain = append(chain, n)
+		default:
+			return nil, fmt.Errorf("unknown DSP stage %q (use dc, highpass, gate, denoise, normalize or rms)", name)
+		}
+	}
+	return chain, nil
+}
+
+// String lists the stage names
+func (c DSPChain) String() string {
+	names := make([]string, len(c))
+	for i, stage := range c {
+		names[i] = stage.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Process runs all stages over samples
+func (c DSPChain) Process(samples []float64, sampleRate int) []float64 {
+	for _, stage := range c {
+		samples = stage.Process(samples, sampleRate)
+	}
+	return samples
+}
+
+// ProcessPCM runs the chain over 16-bit little-endian mono PCM and returns new PCM bytes
+func (c DSPChain) ProcessPCM(data []byte, sampleRate int) []byte {
+	if len(c) == 0 {
+		return data
+	}
+	samples := c.Process(PCM16ToFloat(data), sampleRate)
+	return FloatToPCM16(samples)
+}
+
+// PCM16ToFloat converts 16-bit little-endian PCM bytes to samples in [-1, 1]
+func PCM16ToFloat(data []byte) []float64 {
+	samples := make([]float64, len(data)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(data[i*2:]))) / 32768
+	}
+	return samples
+}
+
+// FloatToPCM16 converts samples in [-1, 1] to 16-bit little-endian PCM bytes, saturating out-of-range values
+func FloatToPCM16(samples []float64) []byte {
+	data := make([]byte, 0, len(samples)*2)
+	for _, v := range samples {
+		if math.IsNaN(v) {
+			v = 0
+		}
+		// Scale by 32768 to undo PCM16ToFloat exactly
+		s := clampInt16(v * 32768)
+		data = append(data, byte(s), byte(s>>8))
+	}
+	return data
+}
@@ -0,0 +1,204 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+)
+
+// DCRemoval subtracts the mean of the recording, removing a constant offset
+// that cheap microphones and sound cards add to the signal
+type DCRemoval struct{}
+
+// Name returns "dc"
+func (DCRemoval) Name() string { return "dc" }
+
+// Process removes the DC offset in place
+func (DCRemoval) Process(samples []float64, sampleRate int) []float64 {
+	if len(samples) == 0 {
+		return samples
+	}
+	mean := 0.0
+	for _, v := range samples {
+		mean += v
+	}
+	mean /= float64(len(samples))
+	for i := range samples {
+		samples[i] -= mean
+	}
+	return samples
+}
+
+// HighPass is a second-order Butterworth high-pass filter for removing mains hum and rumble
+type HighPass struct {
+	Cutoff float64 // Cutoff frequency in Hz
+}
+
+// NewHighPass creates a high-pass filter with the given cutoff
+func NewHighPass(cutoff float64) *HighPass {
+	return &HighPass{Cutoff: cutoff}
+}
+
+// Name returns the stage name with its cutoff
+func (h *HighPass) Name() string { return fmt.Sprintf("highpass=%g", h.Cutoff) }
+
+// Process filters samples in place. Cutoffs at or above the Nyquist frequency leave the signal unchanged.
+func (h *HighPass) Process(samples []float64, sampleRate int) []float64 {
+	if h.Cutoff <= 0 || h.Cutoff >= float64(sampleRate)/2 {
+		return samples
+	}
+
+	// Biquad coefficients from the RBJ audio EQ cookbook with Q = 1/sqrt(2)
+	w0 := 2 * math.Pi * h.Cutoff / float64(sampleRate)
+	cosW0 := math.Cos(w0)
+	alpha := math.Sin(w0) / math.Sqrt2
+	a0 := 1 + alpha
+	b0 := (1 + cosW0) / 2 / a0
+	b1 := -(1 + cosW0) / a0
+	b2 := b0
+	a1 := -2 * cosW0 / a0
+	a2 := (1 - alpha) / a0
+
+	var x1, x2, y1, y2 float64
+	for i, x := range samples {
+		y := b0*x + b1*x1 + b2*x2 - a1*y1 - a2*y2
+		x2, x1 = x1, x
+		y2, y1 = y1, y
+		samples[i] = y
+	}
+	return samples
+}
+
+// NoiseGate attenuates the signal while its level stays below a threshold,
+// silencing background noise between words
+type NoiseGate struct {
+	ThresholdDB float64 // RMS level in dBFS below which the gate closes
+	ReductionDB float64 // Attenuation applied while closed, e.g. -40
+	Window      float64 // Level measurement window in seconds
+	Hold        float64 // Time in seconds the gate stays open after the level drops
+	Attack      float64 // Time constant in seconds for opening
+	Release     float64 // Time constant in seconds for closing
+}
+
+// NewNoiseGate creates a gate with the given threshold and settings suited to speech
+func NewNoiseGate(thresholdDB float64) *NoiseGate {
+	return &NoiseGate{
+		ThresholdDB: thresholdDB,
+		ReductionDB: -40,
+		Window:      0.010,
+		Hold:        0.150,
+		Attack:      0.001,
+		Release:     0.030,
+	}
+}
+
+// Name returns the stage name with its threshold
+func (g *NoiseGate) Name() string { return fmt.Sprintf("gate=%g", g.ThresholdDB) }
+
+// Process gates samples in place
+func (g *NoiseGate) Process(samples []float64, sampleRate int) []float64 {
+	window := max(1, int(g.Window*float64(sampleRate)))
+	holdWindows := int(g.Hold * float64(sampleRate) / float64(window))
+	closed := math.Pow(10, g.ReductionDB/20)
+	attack := smoothingCoefficient(g.Attack, sampleRate)
+	release := smoothingCoefficient(g.Release, sampleRate)
+
+	gain := closed
+	hold := 0
+	for start := 0; start < len(samples); start += window {
+		block := samples[start:min(start+window, len(samples))]
+		target := closed
+		if rmsDB(block) >= g.ThresholdDB {
+			hold = holdWindows
+			target = 1
+		} else if hold > 0 {
+			hold--
+			target = 1
+		}
+
+		for i := range block {
+			coef := release
+			if target > gain {
+				coef = attack
+			}
+			gain += (target - gain) * coef
+			block[i] *= gain
+		}
+	}
+	return samples
+}
+
+// Normalizer scales the recording so its peak or RMS level reaches a target
+type Normalizer struct {
+	TargetDB  float64 // Target level in dBFS
+	RMS       bool    // Normalize the RMS level instead of the peak
+	MaxGainDB float64 // Largest boost applied, so near-silent recordings are not blown up
+}
+
+// NewPeakNormalizer creates a normalizer that brings the peak to targetDB
+func NewPeakNormalizer(targetDB float64) *Normalizer {
+	return &Normalizer{TargetDB: targetDB, MaxGainDB: 30}
+}
+
+// NewRMSNormalizer creates a normalizer that brings the RMS level to targetDB without clipping
+func NewRMSNormalizer(targetDB float64) *Normalizer {
+	return &Normalizer{TargetDB: targetDB, RMS: true, MaxGainDB: 30}
+}
+
+// Name returns the stage name with its target
+func (n *Normalizer) Name() string {
+	if n.RMS {
+		return fmt.Sprintf("rms=%g", n.TargetDB)
+	}
+	return fmt.Sprintf("normalize=%g", n.TargetDB)
+}
+
+// Process scales samples in place
+func (n *Normalizer) Process(samples []float64, sampleRate int) []float64 {
+	peak := 0.0
+	for _, v := range samples {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak == 0 {
+		return samples
+	}
+
+	level := 20 * math.Log10(peak)
+	if n.RMS {
+		level = rmsDB(samples)
+	}
+	gainDB := math.Min(n.TargetDB-level, n.MaxGainDB)
+	gain := math.Pow(10, gainDB/20)
+	// Never push the peak into clipping
+	if limit := 0.99 / peak; gain > limit {
+		gain = limit
+	}
+
+	for i := range samples {
+		samples[i] *= gain
+	}
+	return samples
+}
+
+// rmsDB returns the RMS level of samples in dBFS
+func rmsDB(samples []float64) float64 {
+	if len(samples) == 0 {
+		return MinLevelDB
+	}
+	sum := 0.0
+	for _, v := range samples {
+		sum += v * v
+	}
+	rms := math.Sqrt(sum / float64(len(samples)))
+	if rms == 0 {
+		return MinLevelDB
+	}
+	return math.Max(MinLevelDB, 20*math.Log10(rms))
+}
+
+// smoothingCoefficient returns the per-sample coefficient of a one-pole smoother with the given time constant
+func smoothingCoefficient(seconds float64, sampleRate int) float64 {
+	if seconds <= 0 {
+		return 1
+	}
+	return 1 - math.Exp(-1/(seconds*float64(sampleRate)))
+}
@@ -0,0 +1,309 @@
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+const dspRate = 16000
+
+// tone returns secs of a sine wave at freq Hz with the given peak amplitude
+func tone(freq, amp, secs float64) []float64 {
+	out := make([]float64, int(secs*dspRate))
+	for i := range out {
+		out[i] = amp * math.Sin(2*math.Pi*freq*float64(i)/dspRate)
+	}
+	return out
+}
+
+// whiteNoise returns secs of uniform white noise with the given peak amplitude
+func whiteNoise(amp, secs float64, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	out := make([]float64, int(secs*dspRate))
+	for i := range out {
+		out[i] = amp * (2*rng.Float64() - 1)
+	}
+	return out
+}
+
+// mix adds b to a sample by sample
+func mix(a, b []float64) []float64 {
+	out := make([]float64, len(a))
+	for i := range a {
+		out[i] = a[i] + b[i]
+	}
+	return out
+}
+
+// between returns the samples from start to end seconds
+func between(samples []float64, start, end float64) []float64 {
+	return samples[int(start*dspRate):int(end*dspRate)]
+}
+
+// gainDB returns the level of out relative to in, in dB
+func gainDB(in, out []float64) float64 {
+	return rmsDB(out) - rmsDB(in)
+}
+
+func peakOf(samples []float64) float64 {
+	peak := 0.0
+	for _, v := range samples {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	return peak
+}
+
+func TestDCRemoval(t *testing.T) {
+	clean := tone(440, 0.3, 1)
+	samples := make([]float64, len(clean))
+	for i, v := range clean {
+		samples[i] = v + 0.2
+	}
+	out := DCRemoval{}.Process(samples, dspRate)
+
+	mean := 0.0
+	for _, v := range out {
+		mean += v
+	}
+	mean /= float64(len(out))
+	if math.Abs(mean) > 1e-9 {
+		t.Errorf("mean after DC removal = %g, want 0", mean)
+	}
+	if g := gainDB(clean, out); math.Abs(g) > 0.01 {
+		t.Errorf("DC removal changed the signal level by %.3f dB", g)
+	}
+	if out := (DCRemoval{}).Process(nil, dspRate); len(out) != 0 {
+		t.Errorf("DC removal of nothing returned %d samples", len(out))
+	}
+}
+
+func TestHighPass(t *testing.T) {
+	tests := []struct {
+		freq float64
+		want float64 // Gain in dB of a second-order Butterworth high-pass at 80 Hz
+		tol  float64
+	}{
+		{20, -24.1, 0.5},
+		{50, -8.8, 0.3},
+		{80, -3.0, 0.2},
+		{1000, 0, 0.05},
+		{4000, 0, 0.05},
+	}
+	for _, tt := range tests {
+		in := tone(tt.freq, 0.5, 2)
+		out := NewHighPass(80).Process(append([]float64(nil), in...), dspRate)
+		// Measure after the filter has settled
+		got := gainDB(between(in, 1, 2), between(out, 1, 2))
+		if math.Abs(got-tt.want) > tt.tol {
+			t.Errorf("%g Hz: gain %.2f dB, want %.1f dB", tt.freq, got, tt.want)
+		}
+	}
+
+	// A cutoff at or above Nyquist leaves the signal alone
+	in := tone(1000, 0.5, 0.1)
+	out := (&HighPass{Cutoff: dspRate / 2}).Process(append([]float64(nil), in...), dspRate)
+	for i := range in {
+		if in[i] != out[i] {
+			t.Fatalf("cutoff at Nyquist changed sample %d", i)
+		}
+	}
+}
+
+func TestNoiseGate(t *testing.T) {
+	const reduction = -40                // NewNoiseGate's attenuation while closed
+	speech := tone(300, 0.1, 1)          // About -23 dBFS RMS
+	background := whiteNoise(0.02, 1, 1) // About -39 dBFS RMS
+	in := append(append([]float64(nil), speech...), background...)
+	out := NewNoiseGate(-30).Process(append([]float64(nil), in...), dspRate)
+
+	// Speech-level input passes once the gate has opened
+	if g := gainDB(between(in, 0.05, 1), between(out, 0.05, 1)); math.Abs(g) > 0.1 {
+		t.Errorf("speech attenuated by %.2f dB", -g)
+	}
+	// The gate holds open for 150ms after the speech ends
+	if g := gainDB(between(in, 1.01, 1.13), between(out, 1.01, 1.13)); g < -0.5 {
+		t.Errorf("gate closed during the hold time (%.1f dB)", g)
+	}
+	// Then it releases to the full reduction
+	if g := gainDB(between(in, 1.5, 2), between(out, 1.5, 2)); math.Abs(g-reduction) > 1 {
+		t.Errorf("background after release at %.1f dB, want %d dB", g, reduction)
+	}
+	// The release is gradual rather than a click
+	after := between(out, 1.15, 1.3)
+	prev := 1.0
+	for i := 0; i+160 <= len(after); i += 160 {
+		g := math.Pow(10, gainDB(between(in, 1.15+float64(i)/dspRate, 1.16+float64(i)/dspRate), after[i:i+160])/20)
+		if g > prev*1.05 {
+			t.Fatalf("gain rose from %.3f to %.3f while releasing", prev, g)
+		}
+		prev = g
+	}
+
+	// Input that stays below the threshold never opens the gate
+	quiet := whiteNoise(0.02, 1, 2)
+	gated := NewNoiseGate(-30).Process(append([]float64(nil), quiet...), dspRate)
+	if g := gainDB(quiet, gated); math.Abs(g-reduction) > 1 {
+		t.Errorf("quiet input at %.1f dB, want %d dB", g, reduction)
+	}
+}
+
+func TestSpectralSubtraction(t *testing.T) {
+	// A tone switching on and off every half second, so the quietest frames hold only noise
+	clean := tone(1000, 0.3, 4)
+	for i := range clean {
+		if (i/(dspRate/2))%2 == 1 {
+			clean[i] = 0
+		}
+	}
+	noisy := mix(clean, whiteNoise(0.05, 4, 3))
+	out := NewSpectralSubtraction().Process(append([]float64(nil), noisy...), dspRate)
+	if len(out) != len(noisy) {
+		t.Fatalf("got %d samples, want %d", len(out), len(noisy))
+	}
+
+	snr := func(signal []float64) float64 {
+		var s, n float64
+		for i := range clean {
+			s += clean[i] * clean[i]
+			d := signal[i] - clean[i]
+			n += d * d
+		}
+		return 10 * math.Log10(s/n)
+	}
+	before, after := snr(noisy), snr(out)
+	if after-before < 6 {
+		t.Errorf("SNR went from %.1f dB to %.1f dB, want at least 6 dB better", before, after)
+	}
+	// The noise between the tones is strongly reduced
+	if g := gainDB(between(noisy, 0.6, 0.9), between(out, 0.6, 0.9)); g > -10 {
+		t.Errorf("noise between tones reduced by only %.1f dB", -g)
+	}
+
+	// Recordings too short to estimate the noise are returned unchanged
+	short := whiteNoise(0.1, 0.05, 4)
+	if got := NewSpectralSubtraction().Process(short, dspRate); &got[0] != &short[0] {
+		t.Error("short recording was processed")
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	tests := []struct {
+		name      string
+		stage     *Normalizer
+		in        []float64
+		wantPeak  float64 // dBFS, or NaN to skip
+		wantRMS   float64 // dBFS, or NaN to skip
+		tolerance float64
+	}{
+		{"peak up", NewPeakNormalizer(-1), tone(440, 0.1, 1), -1, math.NaN(), 0.01},
+		{"peak down", NewPeakNormalizer(-6), tone(440, 0.9, 1), -6, math.NaN(), 0.01},
+		{"rms up", NewRMSNormalizer(-20), tone(440, 0.05, 1), math.NaN(), -20, 0.01},
+		{"rms down", NewRMSNormalizer(-30), whiteNoise(0.5, 1, 5), math.NaN(), -30, 0.01},
+		// A tone at -3 dBFS RMS would peak above full scale, so the gain stops short of clipping
+		{"rms limited by peak", NewRMSNormalizer(-3), tone(440, 0.1, 1), 20 * math.Log10(0.99), math.NaN(), 0.01},
+		// Near silence is boosted by at most 30 dB
+		{"max gain", NewPeakNormalizer(-1), tone(440, 0.0001, 1), -80 + 30, math.NaN(), 0.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := tt.stage.Process(append([]float64(nil), tt.in...), dspRate)
+			peak := peakOf(out)
+			if peak > 0.99+1e-12 {
+				t.Errorf("peak %.4f clips", peak)
+			}
+			if !math.IsNaN(tt.wantPeak) {
+				if got := 20 * math.Log10(peak); math.Abs(got-tt.wantPeak) > tt.tolerance {
+					t.Errorf("peak at %.3f dBFS, want %.3f", got, tt.wantPeak)
+				}
+			}
+			if !math.IsNaN(tt.wantRMS) {
+				if got := rmsDB(out); math.Abs(got-tt.wantRMS) > tt.tolerance {
+					t.Errorf("RMS at %.3f dBFS, want %.3f", got, tt.wantRMS)
+				}
+			}
+		})
+	}
+
+	// Silence is left alone
+	silent := make([]float64, 100)
+	for _, v := range NewRMSNormalizer(-20).Process(silent, dspRate) {
+		if v != 0 {
+			t.Fatal("silence was amplified")
+		}
+	}
+}
+
+func TestParseDSPChain(t *testing.T) {
+	valid := []struct {
+		spec string
+		want string
+	}{
+		{"", ""},
+		{" , ", ""},
+		{"dc", "dc"},
+		{"dc, highpass=100,gate,denoise=2,normalize=-3,rms", "dc,highpass=100,gate=-45,denoise=2,normalize=-3,rms=-20"},
+		{"highpass,gate=-50,denoise,normalize,rms=-18", "highpass=80,gate=-50,denoise=1.5,normalize=-1,rms=-18"},
+	}
+	for _, tt := range valid {
+		chain, err := ParseDSPChain(tt.spec)
+		if err != nil {
+			t.Errorf("ParseDSPChain(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := chain.String(); got != tt.want {
+			t.Errorf("ParseDSPChain(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+
+	invalid := []struct {
+		spec string
+		want string
+	}{
+		{"echo", `unknown DSP stage "echo"`},
+		{"dc,reverb=2", `unknown DSP stage "reverb"`},
+		{"DC", `unknown DSP stage "DC"`},
+		{"dc=1", "dc takes no value"},
+		{"highpass=abc", `invalid value for DSP stage "highpass": "abc"`},
+		{"highpass=0", "cutoff must be positive"},
+		{"denoise=-1", "denoise factor must be positive"},
+		{"normalize=3", "at most 0 dBFS"},
+		{"rms=1", "at most 0 dBFS"},
+	}
+	for _, tt := range invalid {
+		_, err := ParseDSPChain(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseDSPChain(%q) error = %v, want it to contain %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestDSPChainProcessPCM(t *testing.T) {
+	// Conversion to float and back is exact for every 16-bit sample
+	all := make([]int16, 0, 1<<16)
+	for v := math.MinInt16; v <= math.MaxInt16; v++ {
+		all = append(all, int16(v))
+	}
+	data := AppendPCM16(nil, all)
+	if got := FloatToPCM16(PCM16ToFloat(data)); string(got) != string(data) {
+		t.Error("PCM16ToFloat and FloatToPCM16 do not round trip")
+	}
+	// An empty chain returns the input as it is
+	if got := DSPChain(nil).ProcessPCM(data, dspRate); &got[0] != &data[0] {
+		t.Error("empty chain copied the audio")
+	}
+
+	chain, err := ParseDSPChain("dc,normalize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := tone(440, 0.1, 0.5)
+	for i := range in {
+		in[i] += 0.05
+	}
+	out := PCM16ToFloat(chain.ProcessPCM(FloatToPCM16(in), dspRate))
+	if got := 20 * math.Log10(peakOf(out)); math.Abs(got+1) > 0.01 {
+		t.Errorf("peak after dc,normalize at %.3f dBFS, want -1", got)
+	}
+}
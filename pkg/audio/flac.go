@@ -0,0 +1,839 @@
+package audio
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Stream layout written by FlacWriter: the fLaC marker and a single STREAMINFO
+// block, which is rewritten with the totals on Close, followed by the frames
+const (
+	flacMarker         = "fLaC"
+	flacStreamInfoSize = 34
+	flacHeaderSize     = 4 + 4 + flacStreamInfoSize
+	flacMaxChannels    = 8
+	flacMaxSampleRate  = 1<<20 - 1
+)
+
+// Encoder settings
+const (
+	flacBlockSize         = 4096 // Samples per channel in each frame
+	flacMaxFixedOrder     = 4
+	flacMaxLPCOrder       = 8
+	flacLPCPrecision      = 12 // Bits per quantized LPC coefficient
+	flacMaxRiceParam      = 14 // Largest parameter without the escape code
+	flacMaxPartitionOrder = 8
+)
+
+// Subframe types
+const (
+	flacSubframeConstant = iota
+	flacSubframeVerbatim
+	flacSubframeFixed
+	flacSubframeLPC
+)
+
+// Channel assignments for stereo decorrelation; 0-7 mean independent channels
+const (
+	flacLeftSide  = 8
+	flacSideRight = 9
+	flacMidSide   = 10
+)
+
+// FlacWriter streams 16-bit PCM to a FLAC file, which is lossless and usually
+// about half the size of the same audio as WAV. Frames use fixed or LPC
+// prediction with Rice-coded residuals, and stereo frames use mid/side coding
+// when it is smaller.
+type FlacWriter struct {
+	w        io.WriteSeeker
+	closer   io.Closer
+	format   Format
+	dataSize int64
+	closed   bool
+
+	pending  []int16 // Interleaved samples waiting for a full block
+	carry    []byte  // First byte of a sample split across Write calls
+	frames   uint64
+	samples  uint64 // Samples per channel encoded so far
+	minFrame int
+	maxFrame int
+	md5      hash.Hash
+	enc      flacEncoder
+}
+
+// NewFlacWriter writes a provisional FLAC header to w and returns a writer for the sample data
+func NewFlacWriter(w io.WriteSeeker, format Format) (*FlacWriter, error) {
+	if format.SampleRate <= 0 || format.SampleRate > flacMaxSampleRate ||
+		format.Channels <= 0 || format.Channels > flacMaxChannels {
+		return nil, fmt.Errorf("invalid FLAC format %v", format)
+	}
+	fw := &FlacWriter{
+		w:       w,
+		format:  format,
+		pending: make([]int16, 0, flacBlockSize*format.Channels),
+		md5:     md5.New(),
+	}
+	if _, err := w.Write(fw.header()); err != nil {
+		return nil, fmt.Errorf("failed to write FLAC header: %v", err)
+	}
+	return fw, nil
+}
+
+// CreateFlac creates the file at path, including missing directories, and returns a writer for it
+func CreateFlac(path string, format Format) (*FlacWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create FLAC file: %v", err)
+	}
+	fw, err := NewFlacWriter(file, format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fw.closer = file
+	return fw, nil
+}
+
+// Write appends raw little-endian 16-bit PCM bytes
+func (w *FlacWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed FLAC writer")
+	}
+	n := len(p)
+	samples := make([]int16, 0, (len(w.carry)+len(p))/2)
+	if len(w.carry) == 1 && len(p) > 0 {
+		samples = append(samples, int16(uint16(w.carry[0])|uint16(p[0])<<8))
+		w.carry = w.carry[:0]
+		p = p[1:]
+	}
+	for ; len(p) >= 2; p = p[2:] {
+		samples = append(samples, int16(binary.LittleEndian.Uint16(p)))
+	}
+	w.carry = append(w.carry, p...)
+	w.dataSize += int64(n)
+	return n, w.writeSamples(samples)
+}
+
+// WriteSamples appends interleaved 16-bit samples
+func (w *FlacWriter) WriteSamples(samples []int16) error {
+	if w.closed {
+		return fmt.Errorf("write to closed FLAC writer")
+	}
+	w.dataSize += int64(len(samples) * 2)
+	return w.writeSamples(samples)
+}
+
+// DataSize returns the number of sample bytes written so far, before compression
+func (w *FlacWriter) DataSize() int64 {
+	return w.dataSize
+}
+
+// Close encodes the remaining samples, patches the header with the totals and closes the file
+func (w *FlacWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.finish()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// writeSamples queues samples and encodes every complete block
+func (w *FlacWriter) writeSamples(samples []int16) error {
+	block := flacBlockSize * w.format.Channels
+	for len(samples) > 0 {
+		n := min(len(samples), block-len(w.pending))
+		w.pending = append(w.pending, samples[:n]...)
+		samples = samples[n:]
+		if len(w.pending) == block {
+			if err := w.writeFrame(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeFrame encodes the pending samples as one frame
+func (w *FlacWriter) writeFrame() error {
+	channels := w.format.Channels
+	frame := w.enc.encodeFrame(w.pending, channels, w.frames, w.format.SampleRate)
+	if _, err := w.w.Write(frame); err != nil {
+		return fmt.Errorf("failed to write FLAC frame: %v", err)
+	}
+	if w.frames == 0 || len(frame) < w.minFrame {
+		w.minFrame = len(frame)
+	}
+	w.maxFrame = max(w.maxFrame, len(frame))
+	w.md5.Write(AppendPCM16(make([]byte, 0, len(w.pending)*2), w.pending))
+	w.frames++
+	w.samples += uint64(len(w.pending) / channels)
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// finish encodes the last, shorter block and writes the final header
+func (w *FlacWriter) finish() error {
+	if len(w.carry) > 0 {
+		log.Printf("Dropping incomplete sample at the end of the FLAC data")
+	}
+	// A partial frame of interleaved samples cannot be encoded
+	w.pending = w.pending[:len(w.pending)-len(w.pending)%w.format.Channels]
+	if len(w.pending) > 0 {
+		if err := w.writeFrame(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to FLAC header: %v", err)
+	}
+	if _, err := w.w.Write(w.header()); err != nil {
+		return fmt.Errorf("failed to update FLAC header: %v", err)
+	}
+	if _, err := w.w.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to seek to end of FLAC file: %v", err)
+	}
+	return nil
+}
+
+// header builds the fLaC marker and STREAMINFO block for what has been written so far.
+// The checksum is only filled in once the writer is closed.
+func (w *FlacWriter) header() []byte {
+	header := make([]byte, flacHeaderSize)
+	be := binary.BigEndian
+
+	copy(header[0:4], flacMarker)
+	header[4] = 0x80 // Last metadata block, type STREAMINFO
+	header[7] = flacStreamInfoSize
+
+	info := header[8:]
+	be.PutUint16(info[0:2], flacBlockSize)
+	be.PutUint16(info[2:4], flacBlockSize)
+	putUint24(info[4:7], uint32(w.minFrame))
+	putUint24(info[7:10], uint32(w.maxFrame))
+	be.PutUint64(info[10:18], uint64(w.format.SampleRate)<<44|
+		uint64(w.format.Channels-1)<<41|
+		uint64(16-1)<<36|
+		w.samples&(1<<36-1))
+	if w.closed {
+		copy(info[18:34], w.md5.Sum(nil))
+	}
+	return header
+}
+
+// putUint24 stores v big-endian in three bytes
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+// SaveAsFlac converts raw audio data in the recognizer format to a FLAC file
+func SaveAsFlac(audioData []byte, outputFile string) error {
+	log.Println("Creating FLAC file...")
+
+	writer, err := CreateFlac(outputFile, RecognizerFormat)
+	if err != nil {
+		log.Printf("Error creating FLAC file: %v", err)
+		return err
+	}
+
+	if _, err := writer.Write(audioData); err != nil {
+		log.Printf("Error writing FLAC data: %v", err)
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("Error finishing FLAC file: %v", err)
+		return err
+	}
+
+	log.Println("FLAC file created successfully")
+	return nil
+}
+
+// flacEncoder holds the scratch buffers used to encode frames
+type flacEncoder struct {
+	bw        flacBitWriter
+	channels  [4][]int32 // Left, right, side and mid
+	subframes [4]flacSubframe
+	trial     []int32
+	params    []int
+	costs     [][flacMaxRiceParam + 1]int
+	window    []float64
+	windowed  []float64
+}
+
+// flacSubframe is the chosen encoding of one channel of a frame
+type flacSubframe struct {
+	kind      int
+	order     int
+	bps       uint
+	samples   []int32
+	residual  []int32
+	coeffs    [flacMaxLPCOrder]int32
+	shift     int
+	partOrder int
+	params    []int
+	bits      int // Encoded size
+}
+
+// encodeFrame encodes interleaved samples as one frame with the given number
+func (e *flacEncoder) encodeFrame(interleaved []int16, channels int, number uint64, sampleRate int) []byte {
+	n := len(interleaved) / channels
+	bw := &e.bw
+	bw.reset()
+	bw.writeBits(0xFFF8, 16) // Sync code, fixed block size
+	blockCode, blockBits := flacBlockSizeCode(n)
+	bw.writeBits(uint64(blockCode), 4)
+	bw.writeBits(uint64(flacSampleRateCode(sampleRate)), 4)
+
+	var chosen []*flacSubframe
+	assignment := channels - 1
+	if channels == 2 {
+		assignment, chosen = e.stereoSubframes(interleaved, n)
+	} else {
+		chosen = e.independentSubframes(interleaved, channels, n)
+	}
+	bw.writeBits(uint64(assignment), 4)
+	bw.writeBits(4, 3) // 16 bits per sample
+	bw.writeBits(0, 1)
+	bw.writeUTF8(number)
+	if blockBits > 0 {
+		bw.writeBits(uint64(n-1), blockBits)
+	}
+	bw.writeBits(uint64(flacCRC8(bw.buf)), 8)
+
+	for _, sf := range chosen {
+		e.writeSubframe(sf)
+	}
+	bw.align()
+	bw.writeBits(uint64(flacCRC16(bw.buf)), 16)
+	return bw.buf
+}
+
+// independentSubframes encodes each channel on its own
+func (e *flacEncoder) independentSubframes(interleaved []int16, channels, n int) []*flacSubframe {
+	chosen := make([]*flacSubframe, channels)
+	for c := 0; c < channels; c++ {
+		var samples []int32
+		sf := &flacSubframe{}
+		if c < len(e.subframes) {
+			samples, sf = e.plane(c, n), &e.subframes[c]
+		} else {
+			samples = make([]int32, n)
+		}
+		for i := range samples {
+			samples[i] = int32(interleaved[i*channels+c])
+		}
+		e.analyze(sf, samples, 16)
+		chosen[c] = sf
+	}
+	return chosen
+}
+
+// stereoSubframes picks the cheapest of left/right, left/side, side/right and mid/side
+func (e *flacEncoder) stereoSubframes(interleaved []int16, n int) (int, []*flacSubframe) {
+	left, right, side, mid := e.plane(0, n), e.plane(1, n), e.plane(2, n), e.plane(3, n)
+	for i := 0; i < n; i++ {
+		l, r := int32(interleaved[2*i]), int32(interleaved[2*i+1])
+		left[i], right[i] = l, r
+		side[i] = l - r
+		mid[i] = (l + r) >> 1
+	}
+	e.analyze(&e.subframes[0], left, 16)
+	e.analyze(&e.subframes[1], right, 16)
+	e.analyze(&e.subframes[2], side, 17)
+	e.analyze(&e.subframes[3], mid, 16)
+
+	l, r, s, m := &e.subframes[0], &e.subframes[1], &e.subframes[2], &e.subframes[3]
+	assignment, chosen, best := 1, []*flacSubframe{l, r}, l.bits+r.bits
+	if bits := l.bits + s.bits; bits < best {
+		assignment, chosen, best = flacLeftSide, []*flacSubframe{l, s}, bits
+	}
+	if bits := s.bits + r.bits; bits < best {
+		assignment, chosen, best = flacSideRight, []*flacSubframe{s, r}, bits
+	}
+	if bits := m.bits + s.bits; bits < best {
+		assignment, chosen = flacMidSide, []*flacSubframe{m, s}
+	}
+	return assignment, chosen
+}
+
+// plane returns scratch buffer c sized for n samples
+func (e *flacEncoder) plane(c, n int) []int32 {
+	if cap(e.channels[c]) < n {
+		e.channels[c] = make([]int32, n)
+	}
+	return e.channels[c][:n]
+}
+
+// analyze chooses the smallest encoding of one channel: constant, verbatim,
+// fixed prediction of order 0-4 or LPC
+func (e *flacEncoder) analyze(sf *flacSubframe, samples []int32, bps uint) {
+	n := len(samples)
+	sf.samples = samples
+	sf.bps = bps
+
+	constant := true
+	for _, s := range samples[1:] {
+		if s != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		sf.kind = flacSubframeConstant
+		sf.bits = 8 + int(bps)
+		return
+	}
+	sf.kind = flacSubframeVerbatim
+	sf.bits = 8 + n*int(bps)
+
+	if cap(sf.residual) < n {
+		sf.residual = make([]int32, n)
+	}
+	if cap(e.trial) < n {
+		e.trial = make([]int32, n)
+	}
+	sf.residual = sf.residual[:n]
+	trial := e.trial[:n]
+
+	for order := 0; order <= flacMaxFixedOrder && order < n; order++ {
+		flacFixedResidual(samples, order, trial)
+		bits, partOrder, ok := e.riceParameters(trial, order)
+		if !ok {
+			continue
+		}
+		if total := 8 + order*int(bps) + bits; total < sf.bits {
+			sf.kind, sf.order, sf.bits, sf.partOrder = flacSubframeFixed, order, total, partOrder
+			sf.params = append(sf.params[:0], e.params...)
+			sf.residual, e.trial = trial, sf.residual
+			trial = e.trial[:n]
+		}
+	}
+
+	if n > 2*flacMaxLPCOrder {
+		var coeffs [flacMaxLPCOrder]int32
+		order, shift, ok := e.lpcCoefficients(samples, coeffs[:])
+		if ok && flacLPCResidual(samples, coeffs[:order], shift, trial) {
+			bits, partOrder, ok := e.riceParameters(trial, order)
+			total := 8 + order*int(bps) + 4 + 5 + order*flacLPCPrecision + bits
+			if ok && total < sf.bits {
+				sf.kind, sf.order, sf.bits, sf.partOrder = flacSubframeLPC, order, total, partOrder
+				sf.coeffs, sf.shift = coeffs, shift
+				sf.params = append(sf.params[:0], e.params...)
+				sf.residual, e.trial = trial, sf.residual
+			}
+		}
+	}
+}
+
+// flacFixedResidual computes the residual of a fixed polynomial predictor
+func flacFixedResidual(x []int32, order int, residual []int32) {
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 0:
+			residual[i] = x[i]
+		case 1:
+			residual[i] = x[i] - x[i-1]
+		case 2:
+			residual[i] = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			residual[i] = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			residual[i] = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+	}
+}
+
+// lpcCoefficients estimates quantized linear prediction coefficients with the
+// Levinson-Durbin recursion on a Tukey-windowed autocorrelation
+func (e *flacEncoder) lpcCoefficients(samples []int32, coeffs []int32) (order, shift int, ok bool) {
+	n := len(samples)
+	if len(e.window) != n {
+		e.window = tukeyWindow(n, 0.5)
+		e.windowed = make([]float64, n)
+	}
+	for i, s := range samples {
+		e.windowed[i] = float64(s) * e.window[i]
+	}
+
+	maxOrder := len(coeffs)
+	var autoc [flacMaxLPCOrder + 1]float64
+	for lag := 0; lag <= maxOrder; lag++ {
+		sum := 0.0
+		for i := lag; i < n; i++ {
+			sum += e.windowed[i] * e.windowed[i-lag]
+		}
+		autoc[lag] = sum
+	}
+	if autoc[0] == 0 {
+		return 0, 0, false
+	}
+
+	// Levinson-Durbin: lpc[j] predicts x[i] from x[i-j-1]
+	var lpc, prev [flacMaxLPCOrder]float64
+	errPower := autoc[0]
+	for i := 0; i < maxOrder; i++ {
+		acc := autoc[i+1]
+		for j := 0; j < i; j++ {
+			acc -= lpc[j] * autoc[i-j]
+		}
+		k := acc / errPower
+		prev = lpc
+		for j := 0; j < i; j++ {
+			lpc[j] = prev[j] - k*prev[i-1-j]
+		}
+		lpc[i] = k
+		order = i + 1
+		errPower *= 1 - k*k
+		if errPower <= 0 {
+			break
+		}
+	}
+
+	// Quantize so the largest coefficient fills the precision
+	cmax := 0.0
+	for _, c := range lpc[:order] {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax == 0 || math.IsNaN(cmax) || math.IsInf(cmax, 0) {
+		return 0, 0, false
+	}
+	_, exp := math.Frexp(cmax)
+	shift = min(flacLPCPrecision-1-exp, 15)
+	if shift < 0 {
+		return 0, 0, false
+	}
+	limit := float64(int32(1)<<(flacLPCPrecision-1)) - 1
+	carry := 0.0
+	for i, c := range lpc[:order] {
+		v := c*float64(int32(1)<<shift) + carry
+		q := math.Max(-limit-1, math.Min(limit, math.Round(v)))
+		carry = v - q
+		coeffs[i] = int32(q)
+	}
+	return order, shift, true
+}
+
+// flacLPCResidual computes the residual of a quantized linear predictor.
+// It reports false if a residual does not fit the 32-bit range FLAC decoders use.
+func flacLPCResidual(x []int32, coeffs []int32, shift int, residual []int32) bool {
+	order := len(coeffs)
+	for i := order; i < len(x); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += int64(c) * int64(x[i-j-1])
+		}
+		r := int64(x[i]) - sum>>shift
+		if r > math.MaxInt32/2 || r < math.MinInt32/2 {
+			return false
+		}
+		residual[i] = int32(r)
+	}
+	return true
+}
+
+// tukeyWindow returns a window that is flat in the middle and Hann-tapered over
+// fraction p of its length
+func tukeyWindow(n int, p float64) []float64 {
+	window := make([]float64, n)
+	taper := int(p / 2 * float64(n))
+	for i := range window {
+		window[i] = 1
+		if taper > 0 && i < taper {
+			window[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		} else if taper > 0 && i >= n-taper {
+			window[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(n-1-i)/float64(taper))
+		}
+	}
+	return window
+}
+
+// riceParameters finds the partition order and per-partition Rice parameters that
+// code residual[order:] in the fewest bits. The parameters are left in e.params.
+func (e *flacEncoder) riceParameters(residual []int32, order int) (bits, partOrder int, ok bool) {
+	n := len(residual)
+	maxOrder := 0
+	for maxOrder < flacMaxPartitionOrder && n%(2<<maxOrder) == 0 && n>>(maxOrder+1) > order {
+		maxOrder++
+	}
+	if n>>maxOrder < order {
+		return 0, 0, false
+	}
+
+	// Exact cost of every parameter for the finest partitions
+	parts := 1 << maxOrder
+	if cap(e.costs) < parts {
+		e.costs = make([][flacMaxRiceParam + 1]int, parts)
+	}
+	costs := e.costs[:parts]
+	size := n >> maxOrder
+	for p := range costs {
+		start, end := p*size, (p+1)*size
+		if p == 0 {
+			start = order
+		}
+		costs[p] = [flacMaxRiceParam + 1]int{}
+		for _, r := range residual[start:end] {
+			u := uint32(r<<1) ^ uint32(r>>31)
+			for k := range costs[p] {
+				costs[p][k] += int(u >> k)
+			}
+		}
+		for k := range costs[p] {
+			costs[p][k] += (end - start) * (k + 1)
+		}
+	}
+
+	// Merge neighbouring partitions and keep the cheapest order
+	best := -1
+	for po := maxOrder; po >= 0; po-- {
+		total := 6 // Coding method and partition order
+		for p := range costs {
+			total += 4 + costs[p][bestRiceParam(&costs[p])]
+		}
+		if best < 0 || total <= best {
+			best, partOrder = total, po
+			e.params = e.params[:0]
+			for p := range costs {
+				e.params = append(e.params, bestRiceParam(&costs[p]))
+			}
+		}
+		for p := 0; p < len(costs)/2; p++ {
+			for k := range costs[p] {
+				costs[p][k] = costs[2*p][k] + costs[2*p+1][k]
+			}
+		}
+		costs = costs[:len(costs)/2]
+	}
+	return best, partOrder, true
+}
+
+// bestRiceParam returns the parameter with the lowest cost
+func bestRiceParam(costs *[flacMaxRiceParam + 1]int) int {
+	best := 0
+	for k := range costs {
+		if costs[k] < costs[best] {
+			best = k
+		}
+	}
+	return best
+}
+
+// writeSubframe writes a subframe chosen by analyze
+func (e *flacEncoder) writeSubframe(sf *flacSubframe) {
+	bw := &e.bw
+	bps := sf.bps
+	switch sf.kind {
+	case flacSubframeConstant:
+		bw.writeBits(0, 8)
+		bw.writeSigned(int64(sf.samples[0]), bps)
+		return
+	case flacSubframeVerbatim:
+		bw.writeBits(1<<1, 8)
+		for _, s := range sf.samples {
+			bw.writeSigned(int64(s), bps)
+		}
+		return
+	case flacSubframeFixed:
+		bw.writeBits(uint64(8|sf.order)<<1, 8)
+	case flacSubframeLPC:
+		bw.writeBits(uint64(32|(sf.order-1))<<1, 8)
+	}
+
+	for _, s := range sf.samples[:sf.order] {
+		bw.writeSigned(int64(s), bps)
+	}
+	if sf.kind == flacSubframeLPC {
+		bw.writeBits(flacLPCPrecision-1, 4)
+		bw.writeSigned(int64(sf.shift), 5)
+		for _, c := range sf.coeffs[:sf.order] {
+			bw.writeSigned(int64(c), flacLPCPrecision)
+		}
+	}
+
+	bw.writeBits(0, 2) // Rice coding with 4-bit parameters
+	bw.writeBits(uint64(sf.partOrder), 4)
+	n := len(sf.samples)
+	size := n >> sf.partOrder
+	for p, k := range sf.params {
+		start, end := p*size, (p+1)*size
+		if p == 0 {
+			start = sf.order
+		}
+		bw.writeBits(uint64(k), 4)
+		for _, r := range sf.residual[start:end] {
+			u := uint32(r<<1) ^ uint32(r>>31)
+			bw.writeUnary(u >> k)
+			bw.writeBits(uint64(u)&(1<<k-1), uint(k))
+		}
+	}
+}
+
+// flacBlockSizeCode returns the header code for a block size and the number of
+// bits of the size stored after the frame number, if any
+func flacBlockSizeCode(n int) (code int, extraBits uint) {
+	switch n {
+	case 192:
+		return 1, 0
+	case 576, 1152, 2304, 4608:
+		return 2 + bitLength(n/576) - 1, 0
+	case 256, 512, 1024, 2048, 4096, 8192, 16384, 32768:
+		return 8 + bitLength(n/256) - 1, 0
+	}
+	if n <= 256 {
+		return 6, 8
+	}
+	return 7, 16
+}
+
+// bitLength returns the number of bits needed to represent v
+func bitLength(v int) int {
+	n := 0
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+// flacSampleRates maps header codes to sample rates
+var flacSampleRates = map[int]int{
+	1: 88200, 2: 176400, 3: 192000, 4: 8000, 5: 16000, 6: 22050,
+	7: 24000, 8: 32000, 9: 44100, 10: 48000, 11: 96000,
+}
+
+// flacSampleRateCode returns the header code for a sample rate, or 0 to use STREAMINFO
+func flacSampleRateCode(rate int) int {
+	for code, r := range flacSampleRates {
+		if r == rate {
+			return code
+		}
+	}
+	return 0
+}
+
+// flacBitWriter packs bits most significant first
+type flacBitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint // Bits pending in acc
+}
+
+// reset empties the writer, keeping its buffer
+func (w *flacBitWriter) reset() {
+	w.buf = w.buf[:0]
+	w.acc, w.n = 0, 0
+}
+
+// writeBits appends the low bits of v; bits must be at most 32
+func (w *flacBitWriter) writeBits(v uint64, bits uint) {
+	if bits == 0 {
+		return
+	}
+	w.acc = w.acc<<bits | v&(1<<bits-1)
+	w.n += bits
+	for w.n >= 8 {
+		w.n -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.n))
+	}
+	w.acc &= 1<<w.n - 1
+}
+
+// writeSigned appends v in two's complement
+func (w *flacBitWriter) writeSigned(v int64, bits uint) {
+	w.writeBits(uint64(v), bits)
+}
+
+// writeUnary appends q zeros followed by a one
+func (w *flacBitWriter) writeUnary(q uint32) {
+	for ; q >= 32; q -= 32 {
+		w.writeBits(0, 32)
+	}
+	w.writeBits(1, uint(q)+1)
+}
+
+// writeUTF8 appends a frame number in FLAC's extended UTF-8 coding
+func (w *flacBitWriter) writeUTF8(v uint64) {
+	if v < 0x80 {
+		w.writeBits(v, 8)
+		return
+	}
+	extra := 1
+	for extra < 6 && v >= 1<<(5*extra+6) {
+		extra++
+	}
+	prefix := uint64(0xFF00>>(extra+1)) & 0xFF
+	w.writeBits(prefix|v>>(6*extra), 8)
+	for i := extra - 1; i >= 0; i-- {
+		w.writeBits(0x80|(v>>(6*i))&0x3F, 8)
+	}
+}
+
+// align pads with zeros to a byte boundary
+func (w *flacBitWriter) align() {
+	if w.n > 0 {
+		w.writeBits(0, 8-w.n)
+	}
+}
+
+// flacCRC8 computes the frame header checksum (polynomial 0x07)
+func flacCRC8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc = flacCRC8Table[crc^b]
+	}
+	return crc
+}
+
+// flacCRC16 computes the frame checksum (polynomial 0x8005)
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ flacCRC16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+var (
+	flacCRC8Table  [256]byte
+	flacCRC16Table [256]uint16
+)
+
+func init() {
+	for i := range flacCRC8Table {
+		crc8 := byte(i)
+		crc16 := uint16(i) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc8&0x80 != 0 {
+				crc8 = crc8<<1 ^ 0x07
+			} else {
+				crc8 <<= 1
+			}
+			if crc16&0x8000 != 0 {
+				crc16 = crc16<<1 ^ 0x8005
+			} else {
+				crc16 <<= 1
+			}
+		}
+		flacCRC8Table[i] = crc8
+		flacCRC16Table[i] = crc16
+	}
+}
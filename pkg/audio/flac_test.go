@@ -0,0 +1,504 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSignal returns n frames of interleaved samples
+type testSignal func(n, channels int) []int16
+
+func silence(n, channels int) []int16 {
+	return make([]int16, n*channels)
+}
+
+// extremes alternates between the largest and smallest samples
+func extremes(n, channels int) []int16 {
+	out := make([]int16, n*channels)
+	for i := range out {
+		if (i/channels)%2 == 0 {
+			out[i] = math.MaxInt16
+		} else {
+			out[i] = math.MinInt16
+		}
+	}
+	return out
+}
+
+// noise is full-scale white noise, independent in each channel
+func noise(n, channels int) []int16 {
+	rng := rand.New(rand.NewSource(int64(n)))
+	out := make([]int16, n*channels)
+	for i := range out {
+		out[i] = int16(rng.Intn(1 << 16))
+	}
+	return out
+}
+
+// speechLike is a tone with a little noise, the same in each channel
+func speechLike(n, channels int) []int16 {
+	rng := rand.New(rand.NewSource(1))
+	out := make([]int16, n*channels)
+	for i := 0; i < n; i++ {
+		v := 8000*math.Sin(2*math.Pi*220*float64(i)/16000) + float64(rng.Intn(200)-100)
+		for c := 0; c < channels; c++ {
+			out[i*channels+c] = int16(v)
+		}
+	}
+	return out
+}
+
+// midSideSignal shares a tone between the channels with opposite noise in each, so
+// the mid channel is smooth and mid/side is the cheapest coding
+func midSideSignal(n, channels int) []int16 {
+	rng := rand.New(rand.NewSource(2))
+	out := make([]int16, n*2)
+	for i := 0; i < n; i++ {
+		tone := 12000 * math.Sin(2*math.Pi*440*float64(i)/16000)
+		d := float64(rng.Intn(4000) - 2000)
+		out[2*i] = int16(tone + d)
+		out[2*i+1] = int16(tone - d)
+	}
+	return out
+}
+
+// flacRoundTrip encodes samples with write and returns the decoded samples
+func flacRoundTrip(t *testing.T, format Format, samples []int16, write func(*FlacWriter, []int16) error) []int16 {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.flac")
+	w, err := CreateFlac(path, format)
+	if err != nil {
+		t.Fatalf("CreateFlac: %v", err)
+	}
+	if err := write(w, samples); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got, want := w.DataSize(), int64(2*len(samples)); got != want {
+		t.Errorf("DataSize = %d, want %d", got, want)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := NewFlacReader(file)
+	if err != nil {
+		t.Fatalf("NewFlacReader: %v", err)
+	}
+	if r.Format() != format {
+		t.Errorf("format = %v, want %v", r.Format(), format)
+	}
+	if r.BitsPerSample() != 16 {
+		t.Errorf("bits per sample = %d, want 16", r.BitsPerSample())
+	}
+	// An odd buffer size makes reads end inside frames
+	decoded := []int16{}
+	buf := make([]int16, 1001*format.Channels)
+	for {
+		n, err := r.ReadSamples(buf)
+		decoded = append(decoded, buf[:n]...)
+		if err == io.EOF {
+			return decoded
+		}
+		if err != nil {
+			t.Fatalf("ReadSamples: %v", err)
+		}
+	}
+}
+
+func writeSamples(w *FlacWriter, samples []int16) error {
+	return w.WriteSamples(samples)
+}
+
+// writeOddChunks writes the samples as bytes in chunks of 1, 3, 5, ... bytes, so most
+// samples are split across Write calls
+func writeOddChunks(w *FlacWriter, samples []int16) error {
+	data := AppendPCM16(nil, samples)
+	for size := 1; len(data) > 0; size = (size + 2) % 4099 {
+		n := min(size, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func TestFlacRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		frames   int
+		signal   testSignal
+	}{
+		{"mono silence", 1, 3 * flacBlockSize, silence},
+		{"mono extremes", 1, flacBlockSize, extremes},
+		{"mono noise", 1, 2*flacBlockSize + 1, noise},
+		{"mono speech", 1, 5*flacBlockSize + 123, speechLike},
+		{"mono short block", 1, 100, speechLike},
+		{"mono single sample", 1, 1, noise},
+		{"mono empty", 1, 0, silence},
+		{"stereo silence", 2, flacBlockSize + 7, silence},
+		{"stereo extremes", 2, 2*flacBlockSize - 1, extremes},
+		{"stereo noise", 2, flacBlockSize + 1, noise},
+		{"stereo identical channels", 2, 3*flacBlockSize + 5, speechLike},
+		{"stereo mid/side", 2, 2*flacBlockSize + 333, midSideSignal},
+		{"stereo short block", 2, 17, midSideSignal},
+		{"three channels", 3, flacBlockSize + 3, noise},
+	}
+	writers := []struct {
+		name  string
+		write func(*FlacWriter, []int16) error
+	}{
+		{"samples", writeSamples},
+		{"odd byte chunks", writeOddChunks},
+	}
+	for _, tt := range tests {
+		for _, wr := range writers {
+			t.Run(tt.name+"/"+wr.name, func(t *testing.T) {
+				format := Format{SampleRate: 16000, Channels: tt.channels}
+				samples := tt.signal(tt.frames, tt.channels)
+				got := flacRoundTrip(t, format, samples, wr.write)
+				if !slices.Equal(got, samples) {
+					t.Errorf("decoded audio differs from the input")
+				}
+			})
+		}
+	}
+}
+
+func TestFlacStereoDecorrelation(t *testing.T) {
+	tests := []struct {
+		name   string
+		signal testSignal
+		want   int
+	}{
+		{"mid/side", midSideSignal, flacMidSide},
+		{"independent", noise, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e flacEncoder
+			frame := e.encodeFrame(tt.signal(flacBlockSize, 2), 2, 0, 16000)
+			if got := int(frame[3] >> 4); got != tt.want {
+				t.Errorf("channel assignment = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveAudioRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	signals := map[string][]int16{
+		"silence":  silence(flacBlockSize+1, 1),
+		"extremes": extremes(999, 1),
+		"speech":   speechLike(3*flacBlockSize+17, 1),
+		"noise":    noise(5, 1),
+	}
+	for name, samples := range signals {
+		data := AppendPCM16(nil, samples)
+		for _, load := range []struct {
+			ext  string
+			load func(string) ([]byte, error)
+		}{
+			{".wav", LoadWav},
+			{".flac", LoadFlac},
+		} {
+			t.Run(name+load.ext, func(t *testing.T) {
+				path := filepath.Join(dir, name+load.ext)
+				if err := SaveAudio(data, path); err != nil {
+					t.Fatalf("SaveAudio: %v", err)
+				}
+				got, err := load.load(path)
+				if err != nil {
+					t.Fatalf("load: %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("read back %d bytes that differ from the %d saved", len(got), len(data))
+				}
+				if got, err := LoadAudio(path); err != nil || !bytes.Equal(got, data) {
+					t.Errorf("LoadAudio differs from the saved audio (err %v)", err)
+				}
+			})
+		}
+	}
+}
+
+func TestTranscodeFile(t *testing.T) {
+	dir := t.TempDir()
+	format := Format{SampleRate: 44100, Channels: 2}
+	samples := midSideSignal(2*flacBlockSize+5, 2)
+
+	wavPath := filepath.Join(dir, "in.wav")
+	w, err := CreateWav(wavPath, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteSamples(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	flacPath := filepath.Join(dir, "out.flac")
+	if err := TranscodeFile(wavPath, flacPath); err != nil {
+		t.Fatalf("TranscodeFile to FLAC: %v", err)
+	}
+	backPath := filepath.Join(dir, "back.wav")
+	if err := TranscodeFile(flacPath, backPath); err != nil {
+		t.Fatalf("TranscodeFile to WAV: %v", err)
+	}
+
+	for _, path := range []string{flacPath, backPath} {
+		got, gotFormat := readSamplesFile(t, path)
+		if gotFormat != format {
+			t.Errorf("%s: format = %v, want %v", filepath.Base(path), gotFormat, format)
+		}
+		if !slices.Equal(got, samples) {
+			t.Errorf("%s: audio differs from the source", filepath.Base(path))
+		}
+	}
+	want := time.Duration(len(samples)/format.Channels) * time.Second / time.Duration(format.SampleRate)
+	if d, err := AudioDuration(flacPath); err != nil || d != want {
+		t.Errorf("AudioDuration = %v, %v, want %v", d, err, want)
+	}
+}
+
+// readSamplesFile decodes a WAV or FLAC file without converting its format
+func readSamplesFile(t *testing.T, path string) ([]int16, Format) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r, err := NewAudioReader(file)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	out := []int16{}
+	buf := make([]int16, 4096*r.Format().Channels)
+	for {
+		n, err := r.ReadSamples(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out, r.Format()
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+}
+
+// referencePCM returns the audio of testdata/reference.flac, 44.1 kHz stereo
+func referencePCM(t *testing.T) []int16 {
+	t.Helper()
+	data, err := os.ReadFile("testdata/reference.pcm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(uint16(data[2*i]) | uint16(data[2*i+1])<<8)
+	}
+	return samples
+}
+
+// decodeFlacBytes decodes a whole FLAC stream without converting its format
+func decodeFlacBytes(data []byte) ([]int16, Format, error) {
+	r, err := NewFlacReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, Format{}, err
+	}
+	out := []int16{}
+	buf := make([]int16, 1000)
+	for {
+		n, err := r.ReadSamples(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out, r.Format(), nil
+		}
+		if err != nil {
+			return out, r.Format(), err
+		}
+	}
+}
+
+func TestFlacReferenceStream(t *testing.T) {
+	// Written by testdata/mkreference.py, an encoder that shares no code with this
+	// package, with metadata blocks, subframe types and header codes FlacWriter
+	// does not produce
+	data, err := os.ReadFile("testdata/reference.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := referencePCM(t)
+	got, format, err := decodeFlacBytes(data)
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if format != (Format{SampleRate: 44100, Channels: 2}) {
+		t.Errorf("format = %v, want 44.1 kHz stereo", format)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("decoded %d samples that differ from the %d in reference.pcm", len(got), len(want))
+	}
+
+	// Damage anywhere in the audio is caught by the frame checksums or the MD5
+	frames := bytes.Index(data, []byte{0xFF, 0xF8})
+	for pos := frames; pos < len(data); pos += 97 {
+		corrupt := bytes.Clone(data)
+		corrupt[pos] ^= 0x04
+		if _, _, err := decodeFlacBytes(corrupt); err == nil {
+			t.Errorf("flipped bit at byte %d not detected", pos)
+		}
+	}
+	// The STREAMINFO MD5 starts 8 bytes into the stream, after the marker and block header
+	corrupt := bytes.Clone(data)
+	corrupt[8+18] ^= 0x01
+	if _, _, err := decodeFlacBytes(corrupt); err == nil || !strings.Contains(err.Error(), "MD5") {
+		t.Errorf("wrong MD5: error = %v", err)
+	}
+}
+
+func TestFlacEncoderGolden(t *testing.T) {
+	samples := referencePCM(t)
+	path := filepath.Join(t.TempDir(), "golden.flac")
+	w, err := CreateFlac(path, Format{SampleRate: 44100, Channels: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteSamples(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Marker and last-block STREAMINFO header, block sizes 4096, frame sizes 3800 to
+	// 7286 bytes, 44100 Hz, 2 channels, 16 bits, 10000 samples, and the MD5 of
+	// reference.pcm as computed by hashlib
+	const golden = "664c6143" + "80000022" + "10001000" + "000ed8" + "001c76" +
+		"0ac442f000002710" + "d178b29ecfdde4d8433bd1cf4a59160a"
+	if got := hex.EncodeToString(data[:flacHeaderSize]); got != golden {
+		t.Errorf("header = %s\nwant       %s", got, golden)
+	}
+
+	// Walk the frames, checking each header and frame checksum bit by bit
+	pos, frame := flacHeaderSize, 0
+	for pos < len(data) {
+		end, ok := nextFlacFrame(data, pos, frame)
+		if !ok {
+			t.Fatalf("frame %d at byte %d: no valid frame header and CRC-16 found", frame, pos)
+		}
+		pos, frame = end, frame+1
+	}
+	if frame != 3 {
+		t.Errorf("found %d frames, want 3", frame)
+	}
+}
+
+// nextFlacFrame checks that a frame numbered number starts at pos and returns where
+// it ends: the end is the first place after a valid header where the CRC-16 matches
+// and the next frame or the stream begins
+func nextFlacFrame(data []byte, pos, number int) (int, bool) {
+	headerLen, ok := flacFrameHeader(data[pos:], number)
+	if !ok {
+		return 0, false
+	}
+	crc := crc16Bitwise(0, data[pos:pos+headerLen])
+	for end := pos + headerLen + 2; end <= len(data); end++ {
+		if end > pos+headerLen+2 {
+			crc = crc16Bitwise(crc, data[end-3:end-2])
+		}
+		if crc != uint16(data[end-2])<<8|uint16(data[end-1]) {
+			continue
+		}
+		if end == len(data) {
+			return end, true
+		}
+		if _, ok := flacFrameHeader(data[end:], number+1); ok {
+			return end, true
+		}
+	}
+	return 0, false
+}
+
+// flacFrameHeader parses a frame header as RFC 9639 lays it out and checks its
+// number and CRC-8, returning its length including the CRC
+func flacFrameHeader(data []byte, number int) (int, bool) {
+	if len(data) < 6 || data[0] != 0xFF || data[1] != 0xF8 {
+		return 0, false
+	}
+	n := 4
+	// UTF-8 style frame number; frames here stay below 128
+	if int(data[n]) != number {
+		return 0, false
+	}
+	n++
+	switch data[2] >> 4 {
+	case 6:
+		n++
+	case 7:
+		n += 2
+	}
+	switch data[2] & 0xF {
+	case 12:
+		n++
+	case 13, 14:
+		n += 2
+	}
+	if len(data) <= n || crc8Bitwise(data[:n]) != data[n] {
+		return 0, false
+	}
+	return n + 1, true
+}
+
+// crc8Bitwise computes the frame header CRC with polynomial x^8 + x^2 + x + 1
+func crc8Bitwise(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16Bitwise continues the frame CRC with polynomial x^16 + x^15 + x^2 + 1 over data
+func crc16Bitwise(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
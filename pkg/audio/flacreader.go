@@ -0,0 +1,719 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// Limits that keep malformed streams from causing huge allocations
+const (
+	maxFlacMetadataSkip = 16 << 20
+	maxFlacUnary        = 1 << 32
+)
+
+// FlacReader decodes a native FLAC stream into interleaved 16-bit samples.
+// It supports every bit depth and channel assignment of the format, verifies
+// the frame checksums and, at the end of the stream, the MD5 of the audio.
+type FlacReader struct {
+	format        Format
+	bitsPerSample int
+	totalSamples  uint64 // Samples per channel from STREAMINFO, 0 if unknown
+	sum           [16]byte
+
+	br       flacBitReader
+	md5      hash.Hash // Nil when the stream has no checksum
+	decoded  uint64
+	frames   uint64
+	channels [flacMaxChannels][]int64
+	out      []int16 // Samples of the current frame not yet returned
+	done     bool
+}
+
+// NewFlacReader parses the FLAC metadata from r and positions it at the first frame
+func NewFlacReader(r io.Reader) (*FlacReader, error) {
+	br := bufio.NewReader(r)
+
+	var marker [4]byte
+	if _, err := io.ReadFull(br, marker[:]); err != nil {
+		return nil, fmt.Errorf("invalid FLAC file: missing fLaC marker")
+	}
+	if string(marker[:3]) == "ID3" {
+		if err := skipID3(br, marker[:]); err != nil {
+			return nil, err
+		}
+	}
+	if string(marker[:]) != flacMarker {
+		return nil, fmt.Errorf("invalid FLAC file: missing fLaC marker")
+	}
+
+	f := &FlacReader{br: flacBitReader{r: br}}
+	haveInfo := false
+	for last := false; !last; {
+		var header [4]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return nil, fmt.Errorf("invalid FLAC file: truncated metadata")
+		}
+		last = header[0]&0x80 != 0
+		kind := header[0] & 0x7F
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		switch {
+		case kind == 0:
+			if haveInfo || size < flacStreamInfoSize {
+				return nil, fmt.Errorf("invalid FLAC file: bad STREAMINFO block")
+			}
+			info := make([]byte, size)
+			if _, err := io.ReadFull(br, info); err != nil {
+				return nil, fmt.Errorf("invalid FLAC file: truncated STREAMINFO block")
+			}
+			if err := f.parseStreamInfo(info); err != nil {
+				return nil, err
+			}
+			haveInfo = true
+		case kind == 127:
+			return nil, fmt.Errorf("invalid FLAC file: bad metadata block type")
+		case size > maxFlacMetadataSkip:
+			return nil, fmt.Errorf("invalid FLAC file: metadata block too large")
+		default:
+			// Skip seek tables, comments, pictures and padding
+			if _, err := io.CopyN(io.Discard, br, size); err != nil {
+				return nil, fmt.Errorf("invalid FLAC file: truncated metadata")
+			}
+		}
+	}
+	if !haveInfo {
+		return nil, fmt.Errorf("invalid FLAC file: missing STREAMINFO block")
+	}
+	return f, nil
+}
+
+// skipID3 skips an ID3v2 tag whose first four bytes are in marker and reads the next four
+func skipID3(br *bufio.Reader, marker []byte) error {
+	var rest [6]byte
+	if _, err := io.ReadFull(br, rest[:]); err != nil {
+		return fmt.Errorf("invalid FLAC file: truncated ID3 tag")
+	}
+	// marker holds "ID3" and the major version; rest holds the revision, flags and a syncsafe size
+	size := int64(rest[2])<<21 | int64(rest[3])<<14 | int64(rest[4])<<7 | int64(rest[5])
+	if rest[1]&0x10 != 0 {
+		size += 10 // Footer
+	}
+	if _, err := io.CopyN(io.Discard, br, size); err != nil {
+		return fmt.Errorf("invalid FLAC file: truncated ID3 tag")
+	}
+	if _, err := io.ReadFull(br, marker); err != nil {
+		return fmt.Errorf("invalid FLAC file: missing fLaC marker")
+	}
+	return nil
+}
+
+// parseStreamInfo reads the stream format from the STREAMINFO block
+func (f *FlacReader) parseStreamInfo(info []byte) error {
+	packed := binary.BigEndian.Uint64(info[10:18])
+	f.format = Format{
+		SampleRate: int(packed >> 44),
+		Channels:   int(packed>>41&0x7) + 1,
+	}
+	f.bitsPerSample = int(packed>>36&0x1F) + 1
+	f.totalSamples = packed & (1<<36 - 1)
+	copy(f.sum[:], info[18:34])
+
+	if f.format.SampleRate == 0 {
+		return fmt.Errorf("invalid FLAC file: sample rate 0")
+	}
+	if f.bitsPerSample < 4 {
+		return fmt.Errorf("invalid FLAC file: %d bits per sample", f.bitsPerSample)
+	}
+	if f.sum != [16]byte{} {
+		f.md5 = md5.New()
+	}
+	return nil
+}
+
+// Format reports the sample rate and channel count of the stream
+func (f *FlacReader) Format() Format {
+	return f.format
+}
+
+// BitsPerSample reports the sample width stored in the stream
+func (f *FlacReader) BitsPerSample() int {
+	return f.bitsPerSample
+}
+
+// ReadSamples fills buf with interleaved samples converted to 16 bits.
+// Only whole frames are returned; io.EOF marks the end of the stream.
+func (f *FlacReader) ReadSamples(buf []int16) (int, error) {
+	channels := f.format.Channels
+	want := len(buf) - len(buf)%channels
+	if want == 0 {
+		return 0, fmt.Errorf("buffer smaller than one frame")
+	}
+
+	n := 0
+	for n < want {
+		if len(f.out) == 0 {
+			if f.done {
+				break
+			}
+			if err := f.decodeFrame(); err == io.EOF {
+				f.done = true
+				if err := f.verify(); err != nil {
+					return n, err
+				}
+				break
+			} else if err != nil {
+				return n, err
+			}
+		}
+		copied := copy(buf[n:want], f.out)
+		f.out = f.out[copied:]
+		n += copied
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// verify compares the decoded audio with the checksum from STREAMINFO
+func (f *FlacReader) verify() error {
+	if f.totalSamples > 0 && f.decoded != f.totalSamples {
+		return fmt.Errorf("FLAC stream ended after %d of %d samples", f.decoded, f.totalSamples)
+	}
+	if f.md5 != nil && !bytes.Equal(f.md5.Sum(nil), f.sum[:]) {
+		return fmt.Errorf("FLAC audio does not match its MD5 checksum")
+	}
+	return nil
+}
+
+// decodeFrame decodes the next frame into f.out. It returns io.EOF at the end of the stream.
+func (f *FlacReader) decodeFrame() error {
+	if f.totalSamples > 0 && f.decoded >= f.totalSamples {
+		// Ignore anything after the last frame, such as an ID3v1 tag
+		return io.EOF
+	}
+	br := &f.br
+	br.resetCRC()
+
+	// Sync code, a reserved bit and the blocking strategy
+	sync, err := br.readBits(16)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return f.frameError(err)
+	}
+	if sync>>2 != 0x3FFE {
+		return f.frameError(fmt.Errorf("lost frame sync"))
+	}
+	header, err := br.readBits(16)
+	if err != nil {
+		return f.frameError(err)
+	}
+	blockCode := int(header >> 12)
+	rateCode := int(header >> 8 & 0xF)
+	assignment := int(header >> 4 & 0xF)
+	sizeCode := int(header >> 1 & 0x7)
+
+	if _, err := br.readUTF8(); err != nil {
+		return f.frameError(err)
+	}
+	n, err := f.blockSize(blockCode)
+	if err != nil {
+		return f.frameError(err)
+	}
+	if err := f.skipSampleRate(rateCode); err != nil {
+		return f.frameError(err)
+	}
+	bps := f.bitsPerSample
+	if sizeCode != 0 {
+		if bps = [8]int{0, 8, 12, 0, 16, 20, 24, 32}[sizeCode]; bps == 0 {
+			return f.frameError(fmt.Errorf("reserved sample size"))
+		}
+	}
+	crc := br.crc8
+	if expected, err := br.readBits(8); err != nil {
+		return f.frameError(err)
+	} else if byte(expected) != crc {
+		return f.frameError(fmt.Errorf("header CRC mismatch"))
+	}
+
+	channels := assignment + 1
+	if assignment >= flacLeftSide {
+		if assignment > flacMidSide {
+			return f.frameError(fmt.Errorf("reserved channel assignment"))
+		}
+		channels = 2
+	}
+	if channels != f.format.Channels {
+		return f.frameError(fmt.Errorf("%d channels in a %d channel stream", channels, f.format.Channels))
+	}
+
+	for c := 0; c < channels; c++ {
+		if cap(f.channels[c]) < n {
+			f.channels[c] = make([]int64, n)
+		}
+		f.channels[c] = f.channels[c][:n]
+		subBPS := bps
+		// The side channel needs one extra bit
+		if (assignment == flacLeftSide || assignment == flacMidSide) && c == 1 ||
+			assignment == flacSideRight && c == 0 {
+			subBPS++
+		}
+		if err := f.decodeSubframe(f.channels[c], subBPS); err != nil {
+			return f.frameError(err)
+		}
+	}
+
+	br.align()
+	crc16 := br.crc16
+	if expected, err := br.readBits(16); err != nil {
+		return f.frameError(err)
+	} else if uint16(expected) != crc16 {
+		return f.frameError(fmt.Errorf("CRC mismatch"))
+	}
+
+	f.decorrelate(assignment, n)
+	f.output(n, bps)
+	f.frames++
+	f.decoded += uint64(n)
+	return nil
+}
+
+// frameError adds the frame number to a decoding error
+func (f *FlacReader) frameError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("FLAC frame %d: %v", f.frames, err)
+}
+
+// blockSize decodes the block size code, reading the explicit size if present
+func (f *FlacReader) blockSize(code int) (int, error) {
+	switch {
+	case code == 0:
+		return 0, fmt.Errorf("reserved block size")
+	case code == 1:
+		return 192, nil
+	case code <= 5:
+		return 576 << (code - 2), nil
+	case code == 6:
+		v, err := f.br.readBits(8)
+		return int(v) + 1, err
+	case code == 7:
+		v, err := f.br.readBits(16)
+		return int(v) + 1, err
+	}
+	return 256 << (code - 8), nil
+}
+
+// skipSampleRate reads the explicit sample rate if present; the rate from STREAMINFO is used
+func (f *FlacReader) skipSampleRate(code int) error {
+	var err error
+	switch code {
+	case 12:
+		_, err = f.br.readBits(8)
+	case 13, 14:
+		_, err = f.br.readBits(16)
+	case 15:
+		err = fmt.Errorf("invalid sample rate")
+	}
+	return err
+}
+
+// decodeSubframe decodes one channel of n samples with bps bits each
+func (f *FlacReader) decodeSubframe(out []int64, bps int) error {
+	br := &f.br
+	header, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	if header&0x80 != 0 {
+		return fmt.Errorf("bad subframe padding")
+	}
+	kind := int(header >> 1 & 0x3F)
+	wasted := 0
+	if header&1 != 0 {
+		k, err := br.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = int(k) + 1
+		if wasted >= bps {
+			return fmt.Errorf("too many wasted bits")
+		}
+		bps -= wasted
+	}
+
+	n := len(out)
+	switch {
+	case kind == 0:
+		v, err := br.readSigned(uint(bps))
+		if err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = v
+		}
+	case kind == 1:
+		for i := range out {
+			if out[i], err = br.readSigned(uint(bps)); err != nil {
+				return err
+			}
+		}
+	case kind >= 8 && kind <= 12:
+		order := kind - 8
+		if order > n {
+			return fmt.Errorf("predictor order %d exceeds block size %d", order, n)
+		}
+		if err := f.readWarmup(out[:order], bps); err != nil {
+			return err
+		}
+		if err := f.readResidual(out, order); err != nil {
+			return err
+		}
+		flacRestoreFixed(out, order)
+	case kind >= 32:
+		order := kind - 31
+		if order > n {
+			return fmt.Errorf("predictor order %d exceeds block size %d", order, n)
+		}
+		if err := f.readWarmup(out[:order], bps); err != nil {
+			return err
+		}
+		precision, err := br.readBits(4)
+		if err != nil {
+			return err
+		}
+		if precision == 15 {
+			return fmt.Errorf("invalid LPC precision")
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return fmt.Errorf("negative LPC shift")
+		}
+		var coeffs [32]int64
+		for i := 0; i < order; i++ {
+			if coeffs[i], err = br.readSigned(uint(precision) + 1); err != nil {
+				return err
+			}
+		}
+		if err := f.readResidual(out, order); err != nil {
+			return err
+		}
+		flacRestoreLPC(out, coeffs[:order], uint(shift))
+	default:
+		return fmt.Errorf("reserved subframe type %d", kind)
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// readWarmup reads the unpredicted samples at the start of a subframe
+func (f *FlacReader) readWarmup(out []int64, bps int) error {
+	for i := range out {
+		v, err := f.br.readSigned(uint(bps))
+		if err != nil {
+			return err
+		}
+		out[i] = v
+	}
+	return nil
+}
+
+// readResidual reads the Rice-coded residual into out[order:]
+func (f *FlacReader) readResidual(out []int64, order int) error {
+	br := &f.br
+	method, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return fmt.Errorf("reserved residual coding method")
+	}
+	paramBits, escape := uint(4), uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	partOrder, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+
+	n := len(out)
+	size := n >> partOrder
+	if size<<partOrder != n || size < order {
+		return fmt.Errorf("invalid residual partition order %d", partOrder)
+	}
+	i := order
+	for p := 0; p < 1<<partOrder; p++ {
+		end := (p + 1) * size
+		k, err := br.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+		if k == escape {
+			raw, err := br.readBits(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if out[i], err = br.readSigned(uint(raw)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := br.readBits(uint(k))
+			if err != nil {
+				return err
+			}
+			u := q<<k | low
+			out[i] = int64(u>>1) ^ -int64(u&1)
+		}
+	}
+	return nil
+}
+
+// flacRestoreFixed adds the fixed polynomial prediction to the residual in place
+func flacRestoreFixed(x []int64, order int) {
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 1:
+			x[i] += x[i-1]
+		case 2:
+			x[i] += 2*x[i-1] - x[i-2]
+		case 3:
+			x[i] += 3*x[i-1] - 3*x[i-2] + x[i-3]
+		case 4:
+			x[i] += 4*x[i-1] - 6*x[i-2] + 4*x[i-3] - x[i-4]
+		}
+	}
+}
+
+// flacRestoreLPC adds the linear prediction to the residual in place
+func flacRestoreLPC(x []int64, coeffs []int64, shift uint) {
+	for i := len(coeffs); i < len(x); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += c * x[i-j-1]
+		}
+		x[i] += sum >> shift
+	}
+}
+
+// decorrelate turns side-coded stereo back into left and right
+func (f *FlacReader) decorrelate(assignment, n int) {
+	if assignment < flacLeftSide {
+		return
+	}
+	a, b := f.channels[0][:n], f.channels[1][:n]
+	switch assignment {
+	case flacLeftSide:
+		for i := range a {
+			b[i] = a[i] - b[i]
+		}
+	case flacSideRight:
+		for i := range a {
+			a[i] += b[i]
+		}
+	case flacMidSide:
+		for i := range a {
+			mid := a[i]<<1 | b[i]&1
+			a[i], b[i] = (mid+b[i])>>1, (mid-b[i])>>1
+		}
+	}
+}
+
+// output interleaves the frame into f.out as 16-bit samples and feeds the checksum
+func (f *FlacReader) output(n, bps int) {
+	channels := f.format.Channels
+	if cap(f.out) < n*channels {
+		f.out = make([]int16, n*channels)
+	}
+	out := f.out[:n*channels]
+	for c := 0; c < channels; c++ {
+		for i, v := range f.channels[c][:n] {
+			if bps > 16 {
+				v >>= bps - 16
+			} else {
+				v <<= 16 - bps
+			}
+			out[i*channels+c] = int16(v)
+		}
+	}
+	f.out = out
+
+	if f.md5 != nil {
+		// The checksum covers the samples at their stored width, little-endian
+		width := (bps + 7) / 8
+		raw := make([]byte, 0, n*channels*width)
+		for i := 0; i < n; i++ {
+			for c := 0; c < channels; c++ {
+				v := f.channels[c][i]
+				for b := 0; b < width; b++ {
+					raw = append(raw, byte(v>>(8*b)))
+				}
+			}
+		}
+		f.md5.Write(raw)
+	}
+}
+
+// DecodeFlac reads a FLAC stream and returns its audio as 16-bit PCM bytes in the recognizer format
+func DecodeFlac(r io.Reader) ([]byte, error) {
+	reader, err := NewFlacReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeToRecognizerFormat(reader)
+}
+
+// LoadFlac reads a FLAC file and returns its audio as 16-bit PCM bytes in the recognizer format
+func LoadFlac(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FLAC file: %v", err)
+	}
+	defer file.Close()
+
+	data, err := DecodeFlac(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return data, nil
+}
+
+// flacBitReader reads bits most significant first and keeps the running frame checksums
+type flacBitReader struct {
+	r     io.ByteReader
+	acc   uint64
+	n     uint // Bits available in acc
+	crc8  byte
+	crc16 uint16
+}
+
+// resetCRC starts the checksums of a new frame
+func (b *flacBitReader) resetCRC() {
+	b.crc8, b.crc16 = 0, 0
+}
+
+// fill reads one byte into the accumulator
+func (b *flacBitReader) fill() error {
+	c, err := b.r.ReadByte()
+	if err != nil {
+		if err == io.EOF && b.n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	b.crc8 = flacCRC8Table[b.crc8^c]
+	b.crc16 = b.crc16<<8 ^ flacCRC16Table[byte(b.crc16>>8)^c]
+	b.acc = b.acc<<8 | uint64(c)
+	b.n += 8
+	return nil
+}
+
+// readBits reads an unsigned value of up to 56 bits
+func (b *flacBitReader) readBits(count uint) (uint64, error) {
+	if count == 0 {
+		return 0, nil
+	}
+	for b.n < count {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	b.n -= count
+	v := b.acc >> b.n & (1<<count - 1)
+	b.acc &= 1<<b.n - 1
+	return v, nil
+}
+
+// readSigned reads a two's complement value of up to 56 bits
+func (b *flacBitReader) readSigned(count uint) (int64, error) {
+	v, err := b.readBits(count)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	return int64(v<<(64-count)) >> (64 - count), nil
+}
+
+// readUnary counts zero bits up to the next one bit
+func (b *flacBitReader) readUnary() (uint64, error) {
+	var q uint64
+	for {
+		if b.n == 0 {
+			if err := b.fill(); err != nil {
+				return 0, err
+			}
+		}
+		if b.acc == 0 {
+			q += uint64(b.n)
+			b.n = 0
+			if q > maxFlacUnary {
+				return 0, fmt.Errorf("residual out of range")
+			}
+			continue
+		}
+		zeros := uint(bits.LeadingZeros64(b.acc)) - (64 - b.n)
+		q += uint64(zeros)
+		b.n -= zeros + 1
+		b.acc &= 1<<b.n - 1
+		return q, nil
+	}
+}
+
+// readUTF8 reads a frame or sample number in FLAC's extended UTF-8 coding
+func (b *flacBitReader) readUTF8() (uint64, error) {
+	first, err := b.readBits(8)
+	if err != nil {
+		return 0, err
+	}
+	extra := bits.LeadingZeros8(^uint8(first))
+	switch {
+	case extra == 0:
+		return first, nil
+	case extra == 1 || extra > 7:
+		return 0, fmt.Errorf("invalid frame number")
+	}
+	extra--
+	v := first & (0x7F >> (extra + 1))
+	for i := 0; i < extra; i++ {
+		c, err := b.readBits(8)
+		if err != nil {
+			return 0, err
+		}
+		if c&0xC0 != 0x80 {
+			return 0, fmt.Errorf("invalid frame number")
+		}
+		v = v<<6 | c&0x3F
+	}
+	return v, nil
+}
+
+// align discards the bits up to the next byte boundary
+func (b *flacBitReader) align() {
+	b.n -= b.n % 8
+	b.acc &= 1<<b.n - 1
+}
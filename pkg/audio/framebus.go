@@ -0,0 +1,180 @@
+package audio
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Frame is a block of recorded audio in the recognizer format
+type Frame struct {
+	Seq     uint64        // Starts at 0 for each recording and increases by one per frame
+	Time    time.Time     // Capture time of the first sample
+	Offset  time.Duration // Position of the first sample in the recording
+	Samples []int16       // Shared by all subscribers; must not be modified
+	End     bool          // Marks the end of the recording; carries no samples
+}
+
+// AppendPCM appends the frame's samples to buf as 16-bit little-endian PCM
+func (f Frame) AppendPCM(buf []byte) []byte {
+	return AppendPCM16(buf, f.Samples)
+}
+
+// BackpressurePolicy decides what happens when a subscriber's queue is full
+type BackpressurePolicy int
+
+const (
+	// DropNewest discards the frame being published
+	DropNewest BackpressurePolicy = iota
+	// DropOldest discards the oldest queued frame to make room
+	DropOldest
+	// Block waits for room, holding up the recorder; for consumers that must not lose audio
+	Block
+)
+
+// String returns the policy name
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	}
+	return fmt.Sprintf("BackpressurePolicy(%d)", int(p))
+}
+
+// FrameBus delivers recorded frames to any number of subscribers, each with its
+// own bounded queue, so one slow consumer does not hold up the others
+type FrameBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewFrameBus creates a bus without subscribers
+func NewFrameBus() *FrameBus {
+	return &FrameBus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription is a subscriber's queue on a FrameBus
+type Subscription struct {
+	Name string
+
+	bus     *FrameBus
+	policy  BackpressurePolicy
+	ch      chan Frame
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// Subscribe adds a subscriber with a queue of queueSize frames
+func (b *FrameBus) Subscribe(name string, queueSize int, policy BackpressurePolicy) *Subscription {
+	s := &Subscription{
+		Name:   name,
+		bus:    b,
+		policy: policy,
+		ch:     make(chan Frame, max(1, queueSize)),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish queues a frame for every subscriber according to its policy
+func (b *FrameBus) Publish(frame Frame) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		s.deliver(frame)
+	}
+}
+
+// Dropped returns the number of frames each subscriber has lost, by subscriber name
+func (b *FrameBus) Dropped() map[string]uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	dropped := make(map[string]uint64, len(b.subs))
+	for s := range b.subs {
+		dropped[s.Name] += s.Dropped()
+	}
+	return dropped
+}
+
+// hasSubscribers reports whether publishing would reach anyone
+func (b *FrameBus) hasSubscribers() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs) > 0
+}
+
+// Frames returns the channel the subscriber receives frames on.
+// It is closed by Unsubscribe.
+func (s *Subscription) Frames() <-chan Frame {
+	return s.ch
+}
+
+// Dropped returns how many frames were discarded because the queue was full
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe removes the subscriber from the bus and closes its channel
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		// Release a publisher blocked on this queue before waiting for the lock
+		close(s.done)
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.ch)
+	})
+}
+
+// deliver queues one frame; the caller holds the bus read lock
+func (s *Subscription) deliver(frame Frame) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	policy := s.policy
+	if frame.End && policy == DropNewest {
+		// Never lose the end marker; drop audio instead
+		policy = DropOldest
+	}
+
+	switch policy {
+	case Block:
+		select {
+		case s.ch <- frame:
+		case <-s.done:
+		}
+		return
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- frame:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+				// The consumer made room in the meantime
+			}
+		}
+	default:
+		select {
+		case s.ch <- frame:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
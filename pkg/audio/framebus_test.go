@@ -0,0 +1,150 @@
+package audio
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// drain returns the sequence numbers left in a closed subscription, with "end" for the end marker
+func drain(s *Subscription) []string {
+	var got []string
+	for frame := range s.Frames() {
+		if frame.End {
+			got = append(got, "end")
+		} else {
+			got = append(got, fmt.Sprint(frame.Seq))
+		}
+	}
+	return got
+}
+
+func TestFrameBusDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy  BackpressurePolicy
+		want    string
+		dropped uint64
+	}{
+		// The queue keeps the first frames; the end marker still gets in by dropping audio
+		{DropNewest, "[1 2 3 end]", 7},
+		{DropOldest, "[7 8 9 end]", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			bus := NewFrameBus()
+			// The slow subscriber does not read until the recording is over
+			slow := bus.Subscribe("slow", 4, tt.policy)
+			fast := bus.Subscribe("fast", 16, DropNewest)
+			for seq := uint64(0); seq < 10; seq++ {
+				bus.Publish(Frame{Seq: seq})
+			}
+			bus.Publish(Frame{Seq: 10, End: true})
+
+			if slow.Dropped() != tt.dropped {
+				t.Errorf("slow subscriber dropped %d frames, want %d", slow.Dropped(), tt.dropped)
+			}
+			dropped := bus.Dropped()
+			if dropped["slow"] != tt.dropped || dropped["fast"] != 0 {
+				t.Errorf("bus reports %v dropped", dropped)
+			}
+			slow.Unsubscribe()
+			fast.Unsubscribe()
+			if got := fmt.Sprint(drain(slow)); got != tt.want {
+				t.Errorf("slow subscriber got %s, want %s", got, tt.want)
+			}
+			// The slow subscriber costs the others nothing
+			if got := fmt.Sprint(drain(fast)); got != "[0 1 2 3 4 5 6 7 8 9 end]" {
+				t.Errorf("fast subscriber got %s, want every frame", got)
+			}
+		})
+	}
+}
+
+func TestFrameBusBlock(t *testing.T) {
+	bus := NewFrameBus()
+	slow := bus.Subscribe("slow", 2, Block)
+	published := make(chan uint64, 10)
+	go func() {
+		for seq := uint64(0); seq < 6; seq++ {
+			bus.Publish(Frame{Seq: seq})
+			published <- seq
+		}
+		bus.Publish(Frame{Seq: 6, End: true})
+		close(published)
+	}()
+
+	// The publisher waits once the queue is full
+	for want := uint64(0); want < 2; want++ {
+		if seq := <-published; seq != want {
+			t.Fatalf("published %d, want %d", seq, want)
+		}
+	}
+	select {
+	case seq := <-published:
+		t.Fatalf("frame %d published into a full queue", seq)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A slow reader gets everything in order, and nothing is dropped
+	var got []string
+	for frame := range slow.Frames() {
+		time.Sleep(time.Millisecond)
+		if frame.End {
+			got = append(got, "end")
+			break
+		}
+		got = append(got, fmt.Sprint(frame.Seq))
+	}
+	for range published {
+	}
+	if fmt.Sprint(got) != "[0 1 2 3 4 5 end]" || slow.Dropped() != 0 {
+		t.Errorf("got %v with %d dropped, want every frame and none dropped", got, slow.Dropped())
+	}
+	slow.Unsubscribe()
+}
+
+func TestFrameBusBlockUnsubscribe(t *testing.T) {
+	bus := NewFrameBus()
+	stuck := bus.Subscribe("stuck", 1, Block)
+	other := bus.Subscribe("other", 16, DropNewest)
+	done := make(chan struct{})
+	go func() {
+		for seq := uint64(0); seq < 5; seq++ {
+			bus.Publish(Frame{Seq: seq})
+		}
+		close(done)
+	}()
+
+	// The publisher is stuck on the full queue until its subscriber leaves
+	select {
+	case <-done:
+		t.Fatal("publisher did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unsubscribed := make(chan struct{})
+	go func() {
+		stuck.Unsubscribe()
+		close(unsubscribed)
+	}()
+	for _, ch := range []chan struct{}{unsubscribed, done} {
+		select {
+		case <-ch:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Unsubscribe deadlocked with the publisher blocked on its queue")
+		}
+	}
+
+	// The queue is closed with what it held, and later frames go to the others only
+	if got := fmt.Sprint(drain(stuck)); got != "[0]" {
+		t.Errorf("unsubscribed queue held %s, want [0]", got)
+	}
+	bus.Publish(Frame{Seq: 5})
+	other.Unsubscribe()
+	if got := fmt.Sprint(drain(other)); got != "[0 1 2 3 4 5]" {
+		t.Errorf("other subscriber got %s, want every frame", got)
+	}
+	stuck.Unsubscribe()
+	if _, ok := bus.Dropped()["stuck"]; ok {
+		t.Error("unsubscribed queue still on the bus")
+	}
+}
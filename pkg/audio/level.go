@@ -0,0 +1,135 @@
+package audio
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Thresholds used to flag problems with the input level
+const (
+	// ClipLevel is the sample magnitude treated as clipping
+	ClipLevel = math.MaxInt16 - 1
+	// SilenceLevelDB is the RMS level below which a block counts as near-silence
+	SilenceLevelDB = -60.0
+	// MinLevelDB is reported for digital silence
+	MinLevelDB = -96.0
+)
+
+// Level describes the loudness of one block of captured audio
+type Level struct {
+	RMS      float64       // RMS level in dBFS
+	Peak     float64       // Peak level in dBFS
+	Clipping bool          // At least one sample reached full scale
+	Silent   bool          // RMS is below SilenceLevelDB
+	Duration time.Duration // Length of the block
+	// SilentFor is how long the input has been near-silent, including this block.
+	// Only set by LevelMeter, which keeps track across blocks.
+	SilentFor time.Duration
+}
+
+// MeasureLevel computes the level of a block of interleaved samples in the given format
+func MeasureLevel(samples []int16, format Format) Level {
+	level := Level{RMS: MinLevelDB, Peak: MinLevelDB, Silent: true}
+	if len(samples) == 0 {
+		return level
+	}
+	if format.SampleRate > 0 && format.Channels > 0 {
+		frames := len(samples) / format.Channels
+		level.Duration = time.Duration(frames) * time.Second / time.Duration(format.SampleRate)
+	}
+
+	sum := 0.0
+	peak := 0
+	for _, s := range samples {
+		x := float64(s)
+		sum += x * x
+		magnitude := int(s)
+		if magnitude < 0 {
+			magnitude = -magnitude
+		}
+		if magnitude > peak {
+			peak = magnitude
+		}
+	}
+	level.RMS = amplitudeToDB(math.Sqrt(sum / float64(len(samples))))
+	level.Peak = amplitudeToDB(float64(peak))
+	level.Clipping = peak >= ClipLevel
+	level.Silent = level.RMS < SilenceLevelDB
+	return level
+}
+
+// Meter renders the RMS level as a bar of the given width, covering -60 to 0 dBFS
+func (l Level) Meter(width int) string {
+	filled := int(math.Round((l.RMS - SilenceLevelDB) / -SilenceLevelDB * float64(width)))
+	filled = max(0, min(width, filled))
+	return strings.Repeat("▮", filled) + strings.Repeat("▯", width-filled)
+}
+
+// amplitudeToDB converts a 16-bit sample magnitude to dBFS
+func amplitudeToDB(amplitude float64) float64 {
+	if amplitude < 1 {
+		return MinLevelDB
+	}
+	return math.Max(MinLevelDB, 20*math.Log10(amplitude/32768))
+}
+
+// LevelMeter measures captured audio and publishes the levels to its subscribers.
+// Slow subscribers miss levels instead of holding up the recording.
+type LevelMeter struct {
+	mu          sync.Mutex
+	subscribers map[chan Level]struct{}
+	silentFor   time.Duration
+}
+
+// NewLevelMeter creates a meter without subscribers
+func NewLevelMeter() *LevelMeter {
+	return &LevelMeter{subscribers: make(map[chan Level]struct{})}
+}
+
+// Subscribe returns a channel receiving the level of each captured block, and a
+// function that unsubscribes and closes the channel
+func (m *LevelMeter) Subscribe() (<-chan Level, func()) {
+	ch := make(chan Level, 16)
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			delete(m.subscribers, ch)
+			m.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Reset forgets the silence measured so far, at the start of a recording
+func (m *LevelMeter) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.silentFor = 0
+}
+
+// Process measures a block of samples and publishes its level
+func (m *LevelMeter) Process(samples []int16, format Format) Level {
+	level := MeasureLevel(samples, format)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if level.Silent {
+		m.silentFor += level.Duration
+	} else {
+		m.silentFor = 0
+	}
+	level.SilentFor = m.silentFor
+	for ch := range m.subscribers {
+		select {
+		case ch <- level:
+		default:
+		}
+	}
+	return level
+}
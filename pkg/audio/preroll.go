@@ -0,0 +1,257 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// PrerollSource keeps another source open between recordings and remembers its most
+// recent audio, so speech that begins just before a recording starts is not cut off.
+// Each Open starts a session whose first samples are the remembered pre-roll window;
+// Close ends the session but leaves the capture running until Shutdown.
+type PrerollSource struct {
+	source AudioSource
+	window time.Duration
+
+	mu       sync.Mutex
+	running  bool
+	stopping bool
+	done     chan struct{} // Closed when the capture goroutine has exited
+	err      error         // Error that ended the capture
+	ring     []int16       // Pre-roll samples, oldest at ringPos once full
+	ringPos  int
+	ringFull bool
+	session  chan []int16 // Receives live audio while a session is open
+	dropped  CaptureStats // Buffers the session reader fell behind on
+
+	current chan []int16 // Session being read, owned by the reader
+	pending []int16
+}
+
+// NewPrerollSource wraps source and keeps the last window of its audio between sessions
+func NewPrerollSource(source AudioSource, window time.Duration) *PrerollSource {
+	return &PrerollSource{source: source, window: window}
+}
+
+// Start opens the wrapped source and begins filling the pre-roll window.
+// It is called by Open when needed, but calling it early lets the window fill
+// before the first recording.
+func (p *PrerollSource) Start() error {
+	return p.start(p.source.Open)
+}
+
+// start opens the wrapped source with open and starts the capture goroutine
+func (p *PrerollSource) start(open func() error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running {
+		return nil
+	}
+	if done := p.done; done != nil {
+		// Let the previous capture finish closing the source before reopening it
+		p.mu.Unlock()
+		<-done
+		p.mu.Lock()
+		if p.running {
+			return nil
+		}
+	}
+
+	if err := open(); err != nil {
+		return err
+	}
+	format := p.source.Format()
+	frames := int(int64(format.SampleRate) * int64(p.window) / int64(time.Second))
+	p.ring = make([]int16, frames*format.Channels)
+	p.ringPos = 0
+	p.ringFull = false
+	p.running = true
+	p.stopping = false
+	p.err = nil
+	p.done = make(chan struct{})
+	log.Printf("Pre-roll capture started, keeping %v of audio", p.window)
+
+	go p.capture(format, p.done)
+	return nil
+}
+
+// Open starts a session, beginning with the audio captured before the call
+func (p *PrerollSource) Open() error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		// The capture ended between Start and now
+		if p.err != nil {
+			return fmt.Errorf("audio capture stopped: %v", p.err)
+		}
+		return fmt.Errorf("audio capture stopped")
+	}
+	p.session = make(chan []int16, 64)
+	p.dropped = CaptureStats{}
+	p.current = p.session
+	p.pending = p.takePreroll()
+	return nil
+}
+
+// Read returns the next samples of the current session
+func (p *PrerollSource) Read(buf []int16) (int, error) {
+	if len(p.pending) == 0 {
+		samples, ok := <-p.current
+		if !ok {
+			p.mu.Lock()
+			err := p.err
+			p.mu.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		p.pending = samples
+	}
+	n := copy(buf, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// Close ends the current session; capture continues into the pre-roll window
+func (p *PrerollSource) Close() error {
+	p.mu.Lock()
+	p.session = nil
+	p.mu.Unlock()
+	p.current = nil
+	p.pending = nil
+	return nil
+}
+
+// Reopen restarts the capture on the wrapped source and begins a new session.
+// The pre-roll window starts out empty.
+func (p *PrerollSource) Reopen() error {
+	p.Close()
+	p.Shutdown()
+	// Let the wrapped source fall back to another device if it can
+	open := p.source.Open
+	if r, ok := p.source.(RecoverableSource); ok {
+		open = r.Reopen
+	}
+	if err := p.start(open); err != nil {
+		return err
+	}
+	return p.Open()
+}
+
+// Format reports the format of the wrapped source
+func (p *PrerollSource) Format() Format {
+	return p.source.Format()
+}
+
+// Stats reports the audio lost by the wrapped source and by the current session
+func (p *PrerollSource) Stats() CaptureStats {
+	var stats CaptureStats
+	if s, ok := p.source.(StatsSource); ok {
+		stats = s.Stats()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats.DroppedBuffers += p.dropped.DroppedBuffers
+	stats.DroppedSamples += p.dropped.DroppedSamples
+	return stats
+}
+
+// Shutdown stops the capture and closes the wrapped source
+func (p *PrerollSource) Shutdown() error {
+	p.mu.Lock()
+	done := p.done
+	if !p.running {
+		p.mu.Unlock()
+		if done != nil {
+			<-done
+		}
+		return nil
+	}
+	p.stopping = true
+	p.mu.Unlock()
+
+	err := p.source.Close()
+	<-done
+	return err
+}
+
+// capture reads the wrapped source until it ends, feeding the session or the pre-roll window
+func (p *PrerollSource) capture(format Format, done chan struct{}) {
+	defer close(done)
+
+	buf := make([]int16, 1024*format.Channels)
+	var err error
+	for {
+		var n int
+		n, err = p.source.Read(buf)
+		if n > 0 {
+			p.deliver(buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	p.running = false
+	if err != io.EOF {
+		p.err = err
+		log.Printf("Pre-roll capture stopped: %v", err)
+	}
+	if p.session != nil {
+		close(p.session)
+		p.session = nil
+	}
+	closeSource := !p.stopping
+	p.mu.Unlock()
+
+	// The source failed or ran dry on its own, so release it here
+	if closeSource {
+		p.source.Close()
+	}
+}
+
+// deliver passes samples to the open session or stores them in the pre-roll window
+func (p *PrerollSource) deliver(samples []int16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.session != nil {
+		select {
+		case p.session <- append([]int16(nil), samples...):
+		default:
+			// Reader is too slow, drop the buffer like the microphone source does
+			p.dropped.DroppedBuffers++
+			p.dropped.DroppedSamples += uint64(len(samples))
+		}
+		return
+	}
+	for len(samples) > 0 && len(p.ring) > 0 {
+		n := copy(p.ring[p.ringPos:], samples)
+		samples = samples[n:]
+		p.ringPos += n
+		if p.ringPos == len(p.ring) {
+			p.ringPos = 0
+			p.ringFull = true
+		}
+	}
+}
+
+// takePreroll returns the pre-roll window in recording order and empties it
+func (p *PrerollSource) takePreroll() []int16 {
+	var out []int16
+	if p.ringFull {
+		out = append(out, p.ring[p.ringPos:]...)
+	}
+	out = append(out, p.ring[:p.ringPos]...)
+	p.ringPos = 0
+	p.ringFull = false
+	return out
+}
@@ -1,105 +1,417 @@
 package audio
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/gordonklaus/portaudio"
-
 	"github.com/tarasowski/autospeech/pkg/config"
 )
 
-// Recorder manages audio recording from a microphone
+// Device recovery settings
+const (
+	zeroInputTimeout    = 3 * time.Second // Digital silence that counts as a lost device
+	maxRecoveryAttempts = 5
+	recoveryRetryDelay  = time.Second
+)
+
+// Recorder manages audio recording from an audio source.
+// StartRecording reads the source on the calling goroutine and hands each block
+// to the consumers (level meter, VAD, recording file or buffer, frame bus, data
+// callback), so slow consumers never run on the audio thread; a live source drops
+// and counts buffers if they fall behind.
 type Recorder struct {
-	state      *config.AppState
-	cfg        *config.AppConfig
-	callback   func([]byte)
-	stopChan   chan struct{}
+	state     *config.AppState
+	cfg       *config.AppConfig
+	source    AudioSource
+	callback  func([]byte)
+	mu        sync.Mutex
+	cancel    context.CancelFunc // Stops the running recording
+	recWriter AudioWriter
+	vad       *VAD
+	meter     *LevelMeter
+	pcm       []byte // Reused for converting samples to bytes
+	bus       *FrameBus
+	seq       uint64 // Sequence number of the next frame
+	position  int64  // Samples published in the current recording
 }
 
-// NewRecorder creates a new audio recorder
+// NewRecorder creates a new audio recorder reading from the default microphone
 func NewRecorder(state *config.AppState, cfg *config.AppConfig) *Recorder {
 	return &Recorder{
-		state:    state,
-		cfg:      cfg,
-		stopChan: make(chan struct{}),
+		state:  state,
+		cfg:    cfg,
+		source: NewPortAudioSource(config.SampleRate, config.Channels, config.FramesPerBuffer),
+		meter:  NewLevelMeter(),
+		bus:    NewFrameBus(),
 	}
 }
 
-// StartRecording begins audio recording with the given data callback
-func (r *Recorder) StartRecording(dataCallback func([]byte)) error {
+// Frames returns the bus that recorded audio is published on
+func (r *Recorder) Frames() *FrameBus {
+	return r.bus
+}
+
+// Levels returns the meter that publishes the input level while recording
+func (r *Recorder) Levels() *LevelMeter {
+	return r.meter
+}
+
+// SetSource replaces the audio source used by subsequent recordings
+func (r *Recorder) SetSource(source AudioSource) {
+	r.source = source
+}
+
+// StartRecording records until ctx is cancelled, StopRecording is called or the
+// source runs out of audio, passing each block to dataCallback. A live source that
+// fails is reopened; if that does not work the recording ends with the audio so far.
+// It moves the session from Idle (or Error) through Starting and Recording to
+// Stopping, or to Error on failure; the caller finishes the session by moving on
+// to Processing or Idle. The slice passed to the callback is only valid during the call.
+func (r *Recorder) StartRecording(ctx context.Context, dataCallback func([]byte)) error {
+	if err := r.state.Transition(config.StateStarting); err != nil {
+		return fmt.Errorf("cannot start recording: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r.mu.Lock()
+	r.cancel = cancel
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.cancel = nil
+		r.mu.Unlock()
+	}()
+
 	r.callback = dataCallback
 	r.state.ResetAudioBuffer()
-	r.state.SetRecording(true)
-	
+	r.meter.Reset()
+	r.seq = 0
+	r.position = 0
+
 	log.Println("Starting audio recording...")
-	
-	// Create an audio input stream
-	inputStream, err := portaudio.OpenDefaultStream(
-		config.Channels, 
-		0, 
-		float64(config.SampleRate), 
-		config.FramesPerBuffer,
-		r.audioInputCallback,
-	)
-	
-	if err != nil {
-		log.Printf("Failed to open audio stream: %v", err)
-		r.state.SetRecording(false)
+
+	if err := r.source.Open(); err != nil {
+		log.Printf("Failed to open audio source: %v", err)
+		r.state.Fail(err)
 		return err
 	}
-	defer inputStream.Close()
+	defer r.source.Close()
+	defer r.logCaptureStats()
+	defer r.publishEnd()
 
-	if err := inputStream.Start(); err != nil {
-		log.Printf("Failed to start audio stream: %v", err)
-		r.state.SetRecording(false)
+	format := r.source.Format()
+	converter, err := converterFor(format)
+	if err != nil {
+		r.state.Fail(err)
 		return err
 	}
 
+	// Stream long recordings straight to disk when configured
+	r.state.SetRecordingFile("")
+	if r.cfg.RecordTo != "" {
+		path := recordingPath(r.cfg.RecordTo, time.Now())
+		writer, err := CreateAudioFile(path, RecognizerFormat)
+		if err != nil {
+			log.Printf("Failed to create recording file: %v", err)
+			r.state.Fail(err)
+			return err
+		}
+		log.Printf("Streaming recording to %s", path)
+		r.recWriter = writer
+		r.state.SetRecordingFile(path)
+		defer r.closeRecWriter()
+	}
+
+	// Watch for the end of speech to stop hands-free
+	r.vad = nil
+	if r.cfg.AutoStopSilence > 0 {
+		vadCfg := DefaultVADConfig()
+		vadCfg.MinSilence = r.cfg.AutoStopSilence
+		r.vad = NewVAD(RecognizerFormat.SampleRate, vadCfg)
+		log.Printf("Auto-stop enabled after %v of silence", r.cfg.AutoStopSilence)
+	}
+
+	if err := r.state.Transition(config.StateRecording); err != nil {
+		return err
+	}
 	log.Println("Audio recording started successfully")
-	
+
 	// Keep recording until stopped
-	for r.state.IsRecording() {
-		select {
-		case <-r.stopChan:
-			log.Println("Recording stopped by request")
-			r.state.SetRecording(false)
-		case <-time.After(100 * time.Millisecond):
-			// Continue recording
+	framesPerBuffer := config.FramesPerBuffer
+	if r.cfg.FramesPerBuffer > 0 {
+		framesPerBuffer = r.cfg.FramesPerBuffer
+	}
+	buf := make([]int16, framesPerBuffer*format.Channels)
+	var lost error        // Set when the recording ends because the device is gone
+	var zeroFrames int64  // Consecutive frames of digital silence
+	zeroRecovery := false // A recovery for digital silence was tried; wait for real audio
+	for ctx.Err() == nil {
+		n, err := r.source.Read(buf)
+		if n > 0 {
+			// A device that vanished often keeps delivering exact zeros
+			if allZero(buf[:n]) {
+				zeroFrames += int64(n / format.Channels)
+			} else {
+				zeroFrames = 0
+				zeroRecovery = false
+			}
+			// Meter the captured samples so clipping is seen before resampling
+			r.meter.Process(buf[:n], format)
+			samples := buf[:n]
+			if converter != nil {
+				samples = converter.Process(samples)
+			}
+			// Keep reading while paused so the stream stays alive, but drop the audio
+			if r.state.State() != config.StatePaused {
+				r.audioInputCallback(samples)
+			}
+		}
+		if err == io.EOF {
+			log.Println("Audio source reached end of input")
+			break
+		}
+		if _, live := r.source.(RecoverableSource); err == nil && live && !zeroRecovery &&
+			time.Duration(zeroFrames)*time.Second/time.Duration(format.SampleRate) >= zeroInputTimeout {
+			err = fmt.Errorf("no signal from the audio device for %v", zeroInputTimeout)
+			zeroRecovery = true
+		}
+		if err == nil {
+			continue
 		}
+
+		log.Printf("Failed to read audio: %v", err)
+		if rerr := r.recoverSource(ctx, err); rerr != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if r.state.State() == config.StateRecovering {
+				// The device is gone for good; finish with the audio captured so far
+				log.Printf("Giving up on the audio device: %v", rerr)
+				lost = rerr
+				break
+			}
+			r.state.Fail(rerr)
+			return rerr
+		}
+		zeroFrames = 0
+
+		// A fallback device may deliver a different format
+		if newFormat := r.source.Format(); newFormat != format {
+			if converter != nil {
+				r.audioInputCallback(converter.Flush())
+			}
+			if converter, err = converterFor(newFormat); err != nil {
+				r.state.Fail(err)
+				return err
+			}
+			format = newFormat
+			buf = make([]int16, framesPerBuffer*format.Channels)
+		}
+	}
+	if ctx.Err() != nil {
+		log.Println("Recording stopped by request")
 	}
 
+	if err := r.state.TransitionWithCause(config.StateStopping, lost); err != nil {
+		return err
+	}
 	log.Println("Stopping audio recording...")
-	inputStream.Stop()
+	if converter != nil {
+		if tail := converter.Flush(); len(tail) > 0 {
+			r.audioInputCallback(tail)
+		}
+	}
+	return nil
+}
+
+// Pause stops adding audio to the recording while keeping the source open
+func (r *Recorder) Pause() error {
+	if err := r.state.TransitionFrom(config.StateRecording, config.StatePaused); err != nil {
+		return fmt.Errorf("cannot pause: %v", err)
+	}
+	log.Println("Recording paused")
+	return nil
+}
+
+// Resume continues a paused recording
+func (r *Recorder) Resume() error {
+	if err := r.state.TransitionFrom(config.StatePaused, config.StateRecording); err != nil {
+		return fmt.Errorf("cannot resume: %v", err)
+	}
+	// Silence during the pause should not count towards the muted warning
+	r.meter.Reset()
+	log.Println("Recording resumed")
 	return nil
 }
 
-// StopRecording stops the current recording session
+// recoverSource reopens a live source after it failed, retrying until it works,
+// the attempts run out or ctx is cancelled. The recording so far is kept.
+func (r *Recorder) recoverSource(ctx context.Context, cause error) error {
+	source, ok := r.source.(RecoverableSource)
+	if !ok {
+		return cause
+	}
+
+	resumeState := r.state.State()
+	if err := r.state.TransitionWithCause(config.StateRecovering, cause); err != nil {
+		return cause
+	}
+	for attempt := 1; attempt <= maxRecoveryAttempts; attempt++ {
+		log.Printf("Reopening audio device (attempt %d of %d)", attempt, maxRecoveryAttempts)
+		err := source.Reopen()
+		if err == nil {
+			log.Printf("Audio device reopened, continuing the recording")
+			return r.state.Transition(resumeState)
+		}
+		log.Printf("Failed to reopen audio device: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(recoveryRetryDelay):
+		}
+	}
+	return fmt.Errorf("audio device lost: %v", cause)
+}
+
+// StopRecording ends the current recording; it does nothing when none is running
 func (r *Recorder) StopRecording() {
-	if r.state.IsRecording() {
-		r.stopChan <- struct{}{}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
 	}
 }
 
 // audioInputCallback processes incoming audio data
 func (r *Recorder) audioInputCallback(in []int16) {
-	// Convert audio samples to bytes and store in buffer
-	buf := make([]byte, len(in)*2)
-	for i, sample := range in {
-		buf[i*2] = byte(sample)
-		buf[i*2+1] = byte(sample >> 8)
-	}
-	
-	// Store in global buffer
-	r.state.WriteToAudioBuffer(buf)
-	
+	// Convert audio samples to bytes, reusing the buffer between blocks
+	r.pcm = AppendPCM16(r.pcm[:0], in)
+	buf := r.pcm
+
+	if r.vad != nil {
+		r.detectVoiceActivity(in)
+	}
+
+	// Store in the recording file or the global buffer
+	if r.recWriter != nil {
+		if _, err := r.recWriter.Write(buf); err != nil {
+			log.Printf("Failed to write recording file: %v", err)
+		}
+	} else if err := r.state.WriteToAudioBuffer(buf); err == config.ErrAudioBufferFull {
+		log.Printf("Maximum recording length of %v reached, stopping", r.cfg.MaxRecording)
+		r.StopRecording()
+	} else if err != nil {
+		log.Printf("Failed to store audio: %v", err)
+		r.StopRecording()
+	}
+
+	r.publishFrame(in)
+
 	// Call the data callback if provided
 	if r.callback != nil {
 		r.callback(buf)
 	}
 }
 
+// detectVoiceActivity feeds the VAD and stops the recording after trailing silence
+func (r *Recorder) detectVoiceActivity(in []int16) {
+	for _, ev := range r.vad.Process(in) {
+		log.Printf("VAD: %v at %v", ev.Type, ev.Offset)
+		if ev.Type == SpeechEnd {
+			log.Printf("Stopping after %v of silence", r.cfg.AutoStopSilence)
+			r.StopRecording()
+		}
+	}
+}
+
+// publishFrame sends a copy of the samples to the frame bus subscribers
+func (r *Recorder) publishFrame(in []int16) {
+	if len(in) == 0 || !r.bus.hasSubscribers() {
+		return
+	}
+	rate := time.Duration(RecognizerFormat.SampleRate)
+	duration := time.Duration(len(in)) * time.Second / rate
+	r.bus.Publish(Frame{
+		Seq:     r.seq,
+		Time:    time.Now().Add(-duration),
+		Offset:  time.Duration(r.position) * time.Second / rate,
+		Samples: append([]int16(nil), in...),
+	})
+	r.seq++
+	r.position += int64(len(in))
+}
+
+// publishEnd tells the frame bus subscribers that the recording is over
+func (r *Recorder) publishEnd() {
+	rate := time.Duration(RecognizerFormat.SampleRate)
+	r.bus.Publish(Frame{
+		Seq:    r.seq,
+		Time:   time.Now(),
+		Offset: time.Duration(r.position) * time.Second / rate,
+		End:    true,
+	})
+	for name, dropped := range r.bus.Dropped() {
+		if dropped > 0 {
+			log.Printf("Frame subscriber %q has dropped %d frames", name, dropped)
+		}
+	}
+}
+
+// logCaptureStats reports audio the source lost during the session
+func (r *Recorder) logCaptureStats() {
+	if s, ok := r.source.(StatsSource); ok {
+		stats := s.Stats()
+		log.Printf("Capture: %v", stats)
+		if stats.DroppedBuffers > 0 || stats.Overflows > 0 {
+			log.Printf("Warning: audio was lost during recording, the system may be overloaded")
+		}
+	}
+}
+
+// closeRecWriter finishes the recording file
+func (r *Recorder) closeRecWriter() {
+	if err := r.recWriter.Close(); err != nil {
+		log.Printf("Failed to finish recording file: %v", err)
+	}
+	r.recWriter = nil
+}
+
+// converterFor returns a converter from format to the recognizer format, or nil if none is needed
+func converterFor(format Format) (*Converter, error) {
+	if format == RecognizerFormat {
+		return nil, nil
+	}
+	log.Printf("Converting captured audio from %v to %v", format, RecognizerFormat)
+	return NewConverter(format, RecognizerFormat)
+}
+
+// allZero reports whether every sample is exactly zero
+func allZero(samples []int16) bool {
+	for _, s := range samples {
+		if s != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// recordingPath resolves the -record-to setting; directories get a timestamped file name
+func recordingPath(target string, now time.Time) string {
+	if info, err := os.Stat(target); (err == nil && info.IsDir()) || strings.HasSuffix(target, string(os.PathSeparator)) {
+		return filepath.Join(target, "recording-"+now.Format("20060102-150405")+".wav")
+	}
+	return target
+}
+
 // CreateTempDir creates a temporary directory for audio processing
 func CreateTempDir(prefix string) (string, error) {
 	tmpDir, err := os.MkdirTemp("", prefix)
@@ -108,4 +420,4 @@ func CreateTempDir(prefix string) (string, error) {
 		return "", err
 	}
 	return tmpDir, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,172 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+// recording is what a test recording produced
+type recording struct {
+	buffer    []byte  // Audio stored in the state's buffer
+	callbacks int     // Blocks passed to the data callback
+	callback  []byte  // Audio passed to the data callback
+	frames    []Frame // Frames published on the bus, the end marker included
+}
+
+// record runs the recorder on source until the source ends
+func record(t *testing.T, source AudioSource, cfg *config.AppConfig) recording {
+	t.Helper()
+	state := config.NewAppState(cfg)
+	r := NewRecorder(state, cfg)
+	r.SetSource(source)
+	sub := r.Frames().Subscribe("test", 1024, Block)
+	defer sub.Unsubscribe()
+
+	var rec recording
+	done := make(chan error, 1)
+	go func() {
+		done <- r.StartRecording(context.Background(), func(pcm []byte) {
+			rec.callbacks++
+			rec.callback = append(rec.callback, pcm...)
+		})
+	}()
+	for frame := range sub.Frames() {
+		rec.frames = append(rec.frames, frame)
+		if frame.End {
+			break
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("StartRecording: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("recording did not stop at the end of the source")
+	}
+	if got := state.State(); got != config.StateStopping {
+		t.Errorf("state after the source ended = %v, want %v", got, config.StateStopping)
+	}
+	rec.buffer = state.GetAudioBuffer()
+	return rec
+}
+
+// checkFrames verifies the frame sequence and that it carries the recorded audio
+func checkFrames(t *testing.T, rec recording) {
+	t.Helper()
+	if len(rec.frames) == 0 || !rec.frames[len(rec.frames)-1].End {
+		t.Fatal("no end frame published")
+	}
+	var pcm []byte
+	var offset time.Duration
+	for i, frame := range rec.frames {
+		if frame.Seq != uint64(i) {
+			t.Fatalf("frame %d has sequence number %d", i, frame.Seq)
+		}
+		if frame.Offset != offset {
+			t.Fatalf("frame %d at offset %v, want %v", i, frame.Offset, offset)
+		}
+		offset += time.Duration(len(frame.Samples)) * time.Second / time.Duration(RecognizerFormat.SampleRate)
+		pcm = frame.AppendPCM(pcm)
+	}
+	if !bytes.Equal(pcm, rec.buffer) {
+		t.Errorf("frames carry %d bytes, the buffer holds %d", len(pcm), len(rec.buffer))
+	}
+	if !bytes.Equal(rec.callback, rec.buffer) {
+		t.Errorf("callback got %d bytes, the buffer holds %d", len(rec.callback), len(rec.buffer))
+	}
+}
+
+func TestRecorderGeneratorSource(t *testing.T) {
+	gen := NewGeneratorSource(RecognizerFormat)
+	gen.Duration = 1500 * time.Millisecond
+	cfg := &config.AppConfig{FramesPerBuffer: 1000}
+	rec := record(t, gen, cfg)
+
+	if got, want := len(rec.buffer), 24000*2; got != want {
+		t.Errorf("recorded %d bytes, want %d", got, want)
+	}
+	// 24000 samples in blocks of 1000, then the end marker
+	if rec.callbacks != 24 || len(rec.frames) != 25 {
+		t.Errorf("got %d callbacks and %d frames, want 24 and 25", rec.callbacks, len(rec.frames))
+	}
+	checkFrames(t, rec)
+
+	// The buffer holds the generator's samples as 16-bit little-endian PCM
+	want := make([]int16, 24000)
+	gen.Open()
+	for n := 0; n < len(want); {
+		read, err := gen.Read(want[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += read
+	}
+	if !bytes.Equal(rec.buffer, AppendPCM16(nil, want)) {
+		t.Error("recorded audio differs from the generated samples")
+	}
+}
+
+func TestRecorderWavSource(t *testing.T) {
+	// A 44.1 kHz stereo file is downmixed and resampled to the recognizer format
+	format := Format{SampleRate: 44100, Channels: 2}
+	path := filepath.Join(t.TempDir(), "in.wav")
+	w, err := CreateWav(path, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteSamples(midSideSignal(44100, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := record(t, NewWavFileSource(path), &config.AppConfig{})
+	if got, want := len(rec.buffer), 16000*2; got != want {
+		t.Errorf("recorded %d bytes, want one second (%d)", got, want)
+	}
+	checkFrames(t, rec)
+	loaded, err := LoadAudio(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.buffer, loaded) {
+		t.Error("recorded audio differs from the file converted by LoadAudio")
+	}
+}
+
+func TestRecorderReaderSource(t *testing.T) {
+	pcm := AppendPCM16(nil, speechLike(12345, 1))
+	rec := record(t, NewPCMSource(bytes.NewReader(pcm), RecognizerFormat), &config.AppConfig{FramesPerBuffer: 4096})
+	if !bytes.Equal(rec.buffer, pcm) {
+		t.Errorf("recorded %d bytes that differ from the %d read", len(rec.buffer), len(pcm))
+	}
+	// Three full blocks and a short one
+	if rec.callbacks != 4 {
+		t.Errorf("got %d callbacks, want 4", rec.callbacks)
+	}
+	checkFrames(t, rec)
+}
+
+func TestRecorderRecordTo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rec.flac")
+	gen := NewGeneratorSource(RecognizerFormat)
+	gen.Duration = time.Second
+	rec := record(t, gen, &config.AppConfig{RecordTo: path})
+	if len(rec.buffer) != 0 {
+		t.Errorf("%d bytes went to the buffer instead of the file", len(rec.buffer))
+	}
+	loaded, err := LoadAudio(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, rec.callback) {
+		t.Errorf("recording file holds %d bytes, want the %d recorded", len(loaded), len(rec.callback))
+	}
+}
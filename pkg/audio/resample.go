@@ -0,0 +1,222 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// resamplerZeroCrossings sets the filter length in zero crossings of the sinc kernel
+	resamplerZeroCrossings = 8
+	// resamplerRolloff keeps the cutoff slightly below Nyquist to leave room for the transition band
+	resamplerRolloff = 0.95
+)
+
+// Resampler converts mono 16-bit audio between sample rates with a polyphase windowed-sinc filter.
+// It keeps state between calls so a stream can be converted buffer by buffer.
+type Resampler struct {
+	inRate, outRate int
+	up, down        int64       // Output position n maps to input position n*down/up
+	halfWidth       int         // Taps on each side of the interpolation point
+	phases          [][]float64 // Filter taps for each fractional position
+	history         []float64   // Input samples not yet fully consumed
+	base            int64       // Stream index of history[0]
+	nextOut         int64       // Index of the next output sample
+}
+
+// NewResampler creates a resampler from inRate to outRate
+func NewResampler(inRate, outRate int) *Resampler {
+	g := gcd(inRate, outRate)
+	r := &Resampler{
+		inRate:  inRate,
+		outRate: outRate,
+		up:      int64(outRate / g),
+		down:    int64(inRate / g),
+	}
+	if inRate == outRate {
+		return r
+	}
+
+	// Cutoff relative to the input Nyquist frequency; lower it when decimating to avoid aliasing
+	cutoff := resamplerRolloff
+	if outRate < inRate {
+		cutoff *= float64(outRate) / float64(inRate)
+	}
+	r.halfWidth = int(math.Ceil(resamplerZeroCrossings / cutoff))
+
+	r.phases = make([][]float64, r.up)
+	for p := range r.phases {
+		frac := float64(p) / float64(r.up)
+		taps := make([]float64, 2*r.halfWidth)
+		sum := 0.0
+		for i := range taps {
+			// Tap i weights input sample ipos-halfWidth+1+i, which is x samples before the output point
+			x := frac + float64(r.halfWidth-1-i)
+			taps[i] = cutoff * sinc(cutoff*x) * blackman(x, float64(r.halfWidth))
+			sum += taps[i]
+		}
+		// Normalize each phase to unity gain at DC
+		for i := range taps {
+			taps[i] /= sum
+		}
+		r.phases[p] = taps
+	}
+
+	// Start with silence before the first sample so the filter is centered from the beginning
+	r.history = make([]float64, r.halfWidth)
+	r.base = -int64(r.halfWidth)
+	return r
+}
+
+// Process converts the next block of input and returns the output samples it completes
+func (r *Resampler) Process(in []int16) []int16 {
+	if r.inRate == r.outRate {
+		out := make([]int16, len(in))
+		copy(out, in)
+		return out
+	}
+
+	for _, s := range in {
+		r.history = append(r.history, float64(s))
+	}
+	return r.drain()
+}
+
+// Flush returns the remaining output by padding the input with silence
+func (r *Resampler) Flush() []int16 {
+	if r.inRate == r.outRate {
+		return nil
+	}
+
+	// Outputs whose position falls before the end of the input
+	end := r.base + int64(len(r.history))
+	total := (end*r.up + r.down - 1) / r.down
+
+	r.history = append(r.history, make([]float64, r.halfWidth)...)
+	out := r.drain()
+	if excess := int(r.nextOut - total); excess > 0 && excess <= len(out) {
+		out = out[:len(out)-excess]
+		r.nextOut = total
+	}
+	return out
+}
+
+// drain computes every output sample whose filter window is fully available
+func (r *Resampler) drain() []int16 {
+	var out []int16
+	available := r.base + int64(len(r.history))
+	for {
+		pos := r.nextOut * r.down
+		ipos := pos / r.up
+		if ipos+int64(r.halfWidth) >= available {
+			break
+		}
+		taps := r.phases[pos%r.up]
+		start := int(ipos - int64(r.halfWidth) + 1 - r.base)
+		acc := 0.0
+		for i, tap := range taps {
+			acc += tap * r.history[start+i]
+		}
+		out = append(out, clampInt16(acc))
+		r.nextOut++
+	}
+
+	// Discard input no longer needed by future outputs
+	keepFrom := r.nextOut*r.down/r.up - int64(r.halfWidth) + 1
+	if drop := int(keepFrom - r.base); drop > 0 {
+		if drop > len(r.history) {
+			drop = len(r.history)
+		}
+		r.history = append(r.history[:0], r.history[drop:]...)
+		r.base += int64(drop)
+	}
+	return out
+}
+
+// DownmixToMono averages interleaved channels into a single channel
+func DownmixToMono(in []int16, channels int) []int16 {
+	if channels <= 1 {
+		out := make([]int16, len(in))
+		copy(out, in)
+		return out
+	}
+
+	frames := len(in) / channels
+	out := make([]int16, frames)
+	for i := 0; i < frames; i++ {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			sum += int(in[i*channels+c])
+		}
+		out[i] = int16(sum / channels)
+	}
+	return out
+}
+
+// Converter turns captured audio into the recognizer format by downmixing and resampling
+type Converter struct {
+	from, to  Format
+	resampler *Resampler
+}
+
+// NewConverter creates a converter between two formats; the target must be mono
+func NewConverter(from, to Format) (*Converter, error) {
+	if from.SampleRate <= 0 || from.Channels <= 0 {
+		return nil, fmt.Errorf("invalid source format %v", from)
+	}
+	if to.Channels != 1 {
+		return nil, fmt.Errorf("can only convert to mono audio, not %v", to)
+	}
+	return &Converter{
+		from:      from,
+		to:        to,
+		resampler: NewResampler(from.SampleRate, to.SampleRate),
+	}, nil
+}
+
+// Process converts a block of interleaved input samples
+func (c *Converter) Process(in []int16) []int16 {
+	return c.resampler.Process(DownmixToMono(in, c.from.Channels))
+}
+
+// Flush returns the samples still held by the resampler at the end of a stream
+func (c *Converter) Flush() []int16 {
+	return c.resampler.Flush()
+}
+
+// sinc is the normalized sinc function
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// blackman is a Blackman window of half-width n evaluated at x
+func blackman(x, n float64) float64 {
+	if math.Abs(x) >= n {
+		return 0
+	}
+	t := math.Pi * x / n
+	return 0.42 + 0.5*math.Cos(t) + 0.08*math.Cos(2*t)
+}
+
+// clampInt16 rounds and saturates a sample to the 16-bit range
+func clampInt16(v float64) int16 {
+	v = math.Round(v)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+// gcd returns the greatest common divisor of a and b
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
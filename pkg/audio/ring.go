@@ -0,0 +1,66 @@
+package audio
+
+import (
+	"sync/atomic"
+)
+
+// sampleRing is a lock-free single-producer single-consumer queue of samples.
+// The audio callback writes and one reader goroutine reads; neither side
+// allocates or takes a lock, so the real-time thread is never held up.
+type sampleRing struct {
+	buf   []int16
+	mask  uint64
+	head  atomic.Uint64 // Next position to read, advanced by the reader
+	tail  atomic.Uint64 // Next position to write, advanced by the writer
+	ready chan struct{} // Wakes the reader after a write
+}
+
+// newSampleRing creates a ring holding at least capacity samples
+func newSampleRing(capacity int) *sampleRing {
+	size := 1
+	for size < capacity {
+		size <<= 1
+	}
+	return &sampleRing{
+		buf:   make([]int16, size),
+		mask:  uint64(size - 1),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// write stores all of p, or nothing if it does not fit. Only the producer may call it.
+func (r *sampleRing) write(p []int16) bool {
+	tail := r.tail.Load()
+	free := uint64(len(r.buf)) - (tail - r.head.Load())
+	if uint64(len(p)) > free {
+		return false
+	}
+
+	start := tail & r.mask
+	n := copy(r.buf[start:], p)
+	copy(r.buf, p[n:])
+	r.tail.Store(tail + uint64(len(p)))
+
+	select {
+	case r.ready <- struct{}{}:
+	default:
+		// The reader already has a wake-up pending
+	}
+	return true
+}
+
+// read copies up to len(p) samples into p without blocking. Only the consumer may call it.
+func (r *sampleRing) read(p []int16) int {
+	head := r.head.Load()
+	available := r.tail.Load() - head
+	count := min(uint64(len(p)), available)
+	if count == 0 {
+		return 0
+	}
+
+	start := head & r.mask
+	n := copy(p[:count], r.buf[start:])
+	copy(p[n:count], r.buf)
+	r.head.Store(head + count)
+	return int(count)
+}
@@ -0,0 +1,183 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// SegmenterConfig tunes how a recording is split into utterances
+type SegmenterConfig struct {
+	MinSegment time.Duration // Pauses are not cut at until a segment is this long
+	MaxSegment time.Duration // Without a pause by this length, the segment is cut at its quietest frame
+	Padding    time.Duration // Audio from across the cut added to both ends of a segment
+	VAD        VADConfig     // Finds the pauses; VAD.MinSilence is the shortest pause that splits
+}
+
+// DefaultSegmenterConfig returns settings that keep recognizer calls short without cutting sentences
+func DefaultSegmenterConfig() SegmenterConfig {
+	vad := DefaultVADConfig()
+	vad.MinSilence = 300 * time.Millisecond
+	return SegmenterConfig{
+		MinSegment: 3 * time.Second,
+		MaxSegment: 30 * time.Second,
+		Padding:    250 * time.Millisecond,
+		VAD:        vad,
+	}
+}
+
+// Validate checks that the segment lengths are usable
+func (c SegmenterConfig) Validate() error {
+	if c.MinSegment < 0 || c.MaxSegment < 0 || c.Padding < 0 {
+		return fmt.Errorf("segment lengths must not be negative")
+	}
+	if c.MaxSegment > 0 && c.MinSegment >= c.MaxSegment {
+		return fmt.Errorf("minimum segment length %v must be below the maximum %v", c.MinSegment, c.MaxSegment)
+	}
+	return nil
+}
+
+// Segment is an utterance cut from a recording
+type Segment struct {
+	Start time.Duration // Position of the first sample in the recording, padding included
+	End   time.Duration // Position after the last sample
+	PCM   []byte        // 16-bit little-endian mono PCM, sharing the recording's backing array
+	Split bool          // Starts at a forced cut inside speech, so its padding may repeat words
+}
+
+// Duration returns the length of the segment
+func (s Segment) Duration() time.Duration {
+	return s.End - s.Start
+}
+
+// SegmentPCM splits 16-bit little-endian mono PCM into utterances at the pauses
+// found by the VAD. Segments that are silent throughout are left out. A MaxSegment
+// of 0 returns the whole recording as one segment.
+func SegmentPCM(data []byte, sampleRate int, cfg SegmenterConfig) []Segment {
+	n := len(data) / 2
+	if n == 0 {
+		return nil
+	}
+	toSamples := func(d time.Duration) int {
+		return int(int64(d) * int64(sampleRate) / int64(time.Second))
+	}
+	toDuration := func(pos int) time.Duration {
+		return time.Duration(pos) * time.Second / time.Duration(sampleRate)
+	}
+	maxLen := toSamples(cfg.MaxSegment)
+	if maxLen <= 0 || n <= toSamples(cfg.MinSegment) {
+		return []Segment{{End: toDuration(n), PCM: data[:2*n]}}
+	}
+	minLen := max(1, min(toSamples(cfg.MinSegment), maxLen/2))
+	if cfg.VAD.FrameDuration <= 0 {
+		cfg.VAD.FrameDuration = DefaultVADConfig().FrameDuration
+	}
+	padding := toSamples(cfg.Padding)
+
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	pauses := findPauses(samples, sampleRate, cfg.VAD)
+	frameSize := max(1, toSamples(cfg.VAD.FrameDuration))
+
+	// Cut at the first pause once a segment is long enough, unless that would leave
+	// a remainder too short to stand on its own
+	cuts := []int{0}
+	forced := map[int]bool{}
+	start := 0
+	for n-start > minLen {
+		cut := -1
+		for _, p := range pauses {
+			if p-start < minLen {
+				continue
+			}
+			if p-start > maxLen || n-p < minLen {
+				break
+			}
+			cut = p
+			break
+		}
+		if cut < 0 {
+			if n-start <= maxLen {
+				break
+			}
+			// Search the second half at least, so forced cuts make progress
+			cut = quietestFrame(samples, start+max(minLen, maxLen/2), start+maxLen, frameSize)
+			forced[cut] = true
+		}
+		cuts = append(cuts, cut)
+		start = cut
+	}
+	cuts = append(cuts, n)
+
+	// The VAD can lose track of speech over long stretches, so only segments
+	// below its minimum level are safe to skip
+	var segments []Segment
+	for i := 0; i+1 < len(cuts); i++ {
+		from, to := cuts[i], cuts[i+1]
+		if isSilent(samples[from:to], frameSize, cfg.VAD.MinLevelDB) {
+			continue
+		}
+		from = max(0, from-padding)
+		to = min(n, to+padding)
+		segments = append(segments, Segment{
+			Start: toDuration(from),
+			End:   toDuration(to),
+			PCM:   data[2*from : 2*to],
+			Split: forced[cuts[i]],
+		})
+	}
+	return segments
+}
+
+// span is a range of sample positions
+type span struct {
+	start, end int
+}
+
+// findPauses runs the VAD over the recording and returns the middle of every pause between speech
+func findPauses(samples []int16, sampleRate int, cfg VADConfig) []int {
+	vad := NewVAD(sampleRate, cfg)
+	toSamples := func(d time.Duration) int {
+		return int(int64(d) * int64(sampleRate) / int64(time.Second))
+	}
+
+	var speech []span
+	for _, ev := range vad.Process(samples) {
+		if ev.Type == SpeechStart {
+			speech = append(speech, span{start: toSamples(ev.Offset), end: len(samples)})
+		} else if len(speech) > 0 {
+			speech[len(speech)-1].end = toSamples(ev.Offset)
+		}
+	}
+
+	var pauses []int
+	for i := 0; i+1 < len(speech); i++ {
+		pauses = append(pauses, (speech[i].end+speech[i+1].start)/2)
+	}
+	return pauses
+}
+
+// isSilent reports whether every frame of samples is below levelDB
+func isSilent(samples []int16, frameSize int, levelDB float64) bool {
+	for pos := 0; pos < len(samples); pos += frameSize {
+		energy, _ := frameFeatures(samples[pos:min(pos+frameSize, len(samples))])
+		if energyToDB(energy) >= levelDB {
+			return false
+		}
+	}
+	return true
+}
+
+// quietestFrame returns the start of the lowest-energy frame in [from, to)
+func quietestFrame(samples []int16, from, to, frameSize int) int {
+	best, bestEnergy := to, -1.0
+	for pos := from; pos+frameSize <= to && pos+frameSize <= len(samples); pos += frameSize {
+		energy, _ := frameFeatures(samples[pos : pos+frameSize])
+		if bestEnergy < 0 || energy < bestEnergy {
+			best, bestEnergy = pos, energy
+		}
+	}
+	return best
+}
@@ -0,0 +1,130 @@
+package audio
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// words returns n words of speech: 0.4s of a 500 Hz tone each, 0.1s apart. The tone
+// repeats every VAD frame, so speech is detected and cut exactly at the word edges.
+func words(n int) []int16 {
+	var samples []int16
+	for w := 0; w < n; w++ {
+		if w > 0 {
+			samples = append(samples, make([]int16, dspRate/10)...)
+		}
+		for i := 0; i < dspRate*4/10; i++ {
+			samples = append(samples, int16(8000*math.Sin(2*math.Pi*500*float64(i)/dspRate)))
+		}
+	}
+	return samples
+}
+
+// quiet returns secs of silence
+func quiet(secs float64) []int16 {
+	return make([]int16, int(math.Round(secs*dspRate)))
+}
+
+// speechPCM joins words and silences into 16 kHz PCM
+func speechPCM(parts ...[]int16) []byte {
+	var pcm []byte
+	for _, part := range parts {
+		pcm = AppendPCM16(pcm, part)
+	}
+	return pcm
+}
+
+// secs converts seconds to a duration without rounding errors
+func secs(s float64) time.Duration {
+	return time.Duration(math.Round(s*1000)) * time.Millisecond
+}
+
+func TestSegmentPCM(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts [][]int16
+		max   time.Duration // MaxSegment if not the default
+		want  []Segment     // Start, End and Split only
+	}{
+		{
+			name:  "shorter than the minimum",
+			parts: [][]int16{words(4)},
+			want:  []Segment{{End: secs(1.9)}},
+		},
+		{
+			name:  "no maximum",
+			parts: [][]int16{words(9), quiet(1), words(80)},
+			max:   -1,
+			want:  []Segment{{End: secs(45.3)}},
+		},
+		{
+			// Pauses from 4.4s to 5.6s and from 10s to 11.2s
+			name:  "cut in the middle of pauses",
+			parts: [][]int16{words(9), quiet(1.2), words(9), quiet(1.2), words(9)},
+			want: []Segment{
+				{Start: 0, End: secs(5.25)},
+				{Start: secs(4.75), End: secs(10.85)},
+				{Start: secs(10.35), End: secs(15.6)},
+			},
+		},
+		{
+			name:  "short utterance joins the next",
+			parts: [][]int16{words(2), quiet(1), words(9)},
+			want:  []Segment{{End: secs(6.3)}},
+		},
+		{
+			name:  "short remainder joins the last",
+			parts: [][]int16{words(9), quiet(1), words(2)},
+			want:  []Segment{{End: secs(6.3)}},
+		},
+		{
+			name:  "pause below the minimum silence",
+			parts: [][]int16{words(9), quiet(0.2), words(9)},
+			want:  []Segment{{End: secs(9)}},
+		},
+		{
+			name:  "silence only",
+			parts: [][]int16{quiet(70)},
+		},
+		{
+			// No pause in a minute: each cut is at the first word gap in the second
+			// half of the 30 seconds, and the padding crosses it into the words around
+			name:  "forced cuts",
+			parts: [][]int16{words(120)},
+			want: []Segment{
+				{Start: 0, End: secs(15.65)},
+				{Start: secs(15.15), End: secs(30.65), Split: true},
+				{Start: secs(30.15), End: secs(59.9), Split: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultSegmenterConfig()
+			if tt.max != 0 {
+				cfg.MaxSegment = max(tt.max, 0)
+			}
+			data := speechPCM(tt.parts...)
+			segments := SegmentPCM(data, dspRate, cfg)
+
+			var got []Segment
+			for _, seg := range segments {
+				got = append(got, Segment{Start: seg.Start, End: seg.End, Split: seg.Split})
+				// The audio of a segment is its slice of the recording
+				from, to := 2*int(seg.Start*dspRate/time.Second), 2*int(seg.End*dspRate/time.Second)
+				if !bytes.Equal(seg.PCM, data[from:to]) {
+					t.Errorf("segment %v-%v holds %d bytes that are not the recording's %d to %d", seg.Start, seg.End, len(seg.PCM), from, to)
+				}
+				if seg.Duration() > cfg.MaxSegment+2*cfg.Padding && cfg.MaxSegment > 0 {
+					t.Errorf("segment %v-%v is longer than %v", seg.Start, seg.End, cfg.MaxSegment)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("segments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+// Format describes interleaved signed 16-bit PCM audio
+type Format struct {
+	SampleRate int
+	Channels   int
+}
+
+// String returns a human readable description of the format
+func (f Format) String() string {
+	return fmt.Sprintf("%d Hz, %d channel(s)", f.SampleRate, f.Channels)
+}
+
+// RecognizerFormat is the format the transcription backends expect
+var RecognizerFormat = Format{SampleRate: config.SampleRate, Channels: config.Channels}
+
+// AudioSource produces interleaved signed 16-bit PCM samples for the recorder
+type AudioSource interface {
+	// Open prepares the source and starts producing audio
+	Open() error
+	// Read fills buf with samples, blocking until data is available.
+	// It returns io.EOF once the source has no more audio.
+	Read(buf []int16) (int, error)
+	// Close stops the source and releases its resources
+	Close() error
+	// Format reports the format of the produced samples; valid after Open
+	Format() Format
+}
+
+// ErrSourceStalled is returned by live sources that stop delivering audio, usually
+// because the device was unplugged
+var ErrSourceStalled = errors.New("audio device stopped delivering audio")
+
+// RecoverableSource is implemented by live sources that can be reopened after the
+// device fails, so the recorder can carry on with the same recording
+type RecoverableSource interface {
+	AudioSource
+	// Reopen closes the source and opens it again, falling back to the default
+	// device if the configured one is gone
+	Reopen() error
+}
+
+// CaptureStats counts the audio a live source lost
+type CaptureStats struct {
+	Buffers        uint64 // Buffers delivered by the device
+	DroppedBuffers uint64 // Buffers dropped because the reader fell behind
+	DroppedSamples uint64 // Samples in the dropped buffers
+	Overflows      uint64 // Input overflows reported by the audio driver
+}
+
+// String summarizes the counters
+func (s CaptureStats) String() string {
+	return fmt.Sprintf("%d buffers, %d dropped (%d samples), %d driver overflows",
+		s.Buffers, s.DroppedBuffers, s.DroppedSamples, s.Overflows)
+}
+
+// StatsSource is implemented by sources that can lose audio when the reader falls behind
+type StatsSource interface {
+	Stats() CaptureStats
+}
+
+// NewSource creates the audio source selected by cfg.Input:
+//
+//	mic[:<device>]        microphone, cfg.InputDevice unless a device is given;
+//	                      kept open with a pre-roll window when cfg.Preroll is set
+//	wav:<path>            WAV file (integer or float PCM, any rate and channel count)
+//	flac:<path>           FLAC file; either kind accepts both formats
+//	pcm:<path>            raw signed 16-bit little-endian PCM, "-" for stdin
+//	tone:<hz>[:<secs>]    sine wave generator
+//	noise[:<secs>]        white noise generator
+//
+// When realtime is set, file and generator sources are paced to the wall clock
+// like a microphone would be.
+func NewSource(cfg *config.AppConfig, realtime bool) (AudioSource, error) {
+	spec := cfg.Input
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "mic":
+		if cfg.CaptureRate < 0 || cfg.CaptureChannels < 1 || cfg.FramesPerBuffer < 1 {
+			return nil, fmt.Errorf("invalid capture format: %d Hz, %d channel(s), %d frames per buffer",
+				cfg.CaptureRate, cfg.CaptureChannels, cfg.FramesPerBuffer)
+		}
+		src := NewPortAudioSource(cfg.CaptureRate, cfg.CaptureChannels, cfg.FramesPerBuffer)
+		src.Device = cfg.InputDevice
+		if arg != "" {
+			src.Device = arg
+		}
+		if cfg.Preroll > 0 {
+			src.LowLatency = true
+			return NewPrerollSource(src, cfg.Preroll), nil
+		}
+		return src, nil
+	case "wav", "flac":
+		if arg == "" {
+			return nil, fmt.Errorf("%s source needs a file path", kind)
+		}
+		src := NewWavFileSource(arg)
+		src.Realtime = realtime
+		return src, nil
+	case "pcm":
+		if arg == "" {
+			return nil, fmt.Errorf("pcm source needs a file path or -")
+		}
+		// Hide Close so that stdin is not closed with the source
+		var reader io.Reader = struct{ io.Reader }{os.Stdin}
+		if arg != "-" {
+			f, err := os.Open(arg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open pcm source: %v", err)
+			}
+			reader = f
+		}
+		src := NewPCMSource(reader, RecognizerFormat)
+		src.Realtime = realtime
+		return src, nil
+	case "tone", "noise":
+		gen := NewGeneratorSource(RecognizerFormat)
+		gen.Realtime = realtime
+		gen.Duration = 5 * time.Second
+		if kind == "noise" {
+			gen.Waveform = WaveformNoise
+		} else {
+			gen.Waveform = WaveformSine
+			freqStr, secs, _ := strings.Cut(arg, ":")
+			if freqStr != "" {
+				freq, err := strconv.ParseFloat(freqStr, 64)
+				if err != nil || freq <= 0 {
+					return nil, fmt.Errorf("invalid tone frequency %q", freqStr)
+				}
+				gen.Frequency = freq
+			}
+			arg = secs
+		}
+		if arg != "" {
+			secs, err := strconv.ParseFloat(arg, 64)
+			if err != nil || secs < 0 {
+				return nil, fmt.Errorf("invalid generator duration %q", arg)
+			}
+			gen.Duration = time.Duration(secs * float64(time.Second))
+		}
+		return gen, nil
+	}
+	return nil, fmt.Errorf("unknown audio source %q", spec)
+}
+
+// pacer slows a non-live source down to real time
+type pacer struct {
+	start   time.Time
+	samples int64
+}
+
+// wait blocks until n more samples of the given format are due
+func (p *pacer) wait(n int, format Format) {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+	p.samples += int64(n)
+	frames := p.samples / int64(format.Channels)
+	due := p.start.Add(time.Duration(frames) * time.Second / time.Duration(format.SampleRate))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
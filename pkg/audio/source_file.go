@@ -0,0 +1,134 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PCMSource reads raw signed 16-bit little-endian PCM from an io.Reader
+type PCMSource struct {
+	Realtime bool
+
+	r      io.Reader
+	br     *bufio.Reader
+	format Format
+	pace   pacer
+}
+
+// NewPCMSource creates a source reading raw PCM of the given format from r
+func NewPCMSource(r io.Reader, format Format) *PCMSource {
+	return &PCMSource{r: r, format: format}
+}
+
+// Open prepares the reader
+func (s *PCMSource) Open() error {
+	s.br = bufio.NewReader(s.r)
+	s.pace = pacer{}
+	return nil
+}
+
+// Read decodes the next samples from the reader
+func (s *PCMSource) Read(buf []int16) (int, error) {
+	n, err := readPCM16(s.br, buf)
+	if n > 0 && s.Realtime {
+		s.pace.wait(n, s.format)
+	}
+	return n, err
+}
+
+// Close closes the underlying reader if it is closable
+func (s *PCMSource) Close() error {
+	if c, ok := s.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Format reports the configured format
+func (s *PCMSource) Format() Format {
+	return s.format
+}
+
+// WavFileSource plays back a WAV or FLAC file
+type WavFileSource struct {
+	Realtime bool
+
+	path   string
+	file   *os.File
+	reader SampleReader
+	pace   pacer
+}
+
+// NewWavFileSource creates a source reading the WAV or FLAC file at path
+func NewWavFileSource(path string) *WavFileSource {
+	return &WavFileSource{path: path}
+}
+
+// Open parses the file header and positions the reader at the sample data
+func (s *WavFileSource) Open() error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open audio file: %v", err)
+	}
+
+	reader, err := NewAudioReader(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %v", s.path, err)
+	}
+
+	s.file = file
+	s.reader = reader
+	s.pace = pacer{}
+	return nil
+}
+
+// Read decodes the next samples from the file
+func (s *WavFileSource) Read(buf []int16) (int, error) {
+	n, err := s.reader.ReadSamples(buf)
+	if n > 0 && s.Realtime {
+		s.pace.wait(n, s.reader.Format())
+	}
+	return n, err
+}
+
+// Close closes the file
+func (s *WavFileSource) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Format reports the format from the file header
+func (s *WavFileSource) Format() Format {
+	if s.reader == nil {
+		return Format{}
+	}
+	return s.reader.Format()
+}
+
+// readPCM16 decodes little-endian 16-bit samples into buf
+func readPCM16(r *bufio.Reader, buf []int16) (int, error) {
+	n := 0
+	var sample [2]byte
+	for n < len(buf) {
+		if _, err := io.ReadFull(r, sample[:]); err != nil {
+			if n > 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+				return n, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return n, err
+		}
+		buf[n] = int16(binary.LittleEndian.Uint16(sample[:]))
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,99 @@
+package audio
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Waveform selects the signal produced by a GeneratorSource
+type Waveform int
+
+const (
+	// WaveformSine produces a pure tone
+	WaveformSine Waveform = iota
+	// WaveformNoise produces white noise
+	WaveformNoise
+	// WaveformSilence produces digital silence
+	WaveformSilence
+)
+
+// GeneratorSource synthesizes test audio without any hardware
+type GeneratorSource struct {
+	Waveform  Waveform
+	Frequency float64       // Tone frequency in Hz
+	Amplitude float64       // Peak level between 0 and 1
+	Duration  time.Duration // Zero generates until closed
+	Realtime  bool
+
+	format   Format
+	position int64 // Frames generated so far
+	rng      *rand.Rand
+	pace     pacer
+}
+
+// NewGeneratorSource creates a 440 Hz sine generator at half scale
+func NewGeneratorSource(format Format) *GeneratorSource {
+	return &GeneratorSource{
+		Waveform:  WaveformSine,
+		Frequency: 440,
+		Amplitude: 0.5,
+		format:    format,
+	}
+}
+
+// Open resets the generator to the start of the signal
+func (g *GeneratorSource) Open() error {
+	g.position = 0
+	g.rng = rand.New(rand.NewSource(1))
+	g.pace = pacer{}
+	return nil
+}
+
+// Read fills buf with the next frames of the signal
+func (g *GeneratorSource) Read(buf []int16) (int, error) {
+	channels := g.format.Channels
+	frames := len(buf) / channels
+	if g.Duration > 0 {
+		total := int64(g.Duration.Seconds() * float64(g.format.SampleRate))
+		if remaining := total - g.position; remaining <= 0 {
+			return 0, io.EOF
+		} else if int64(frames) > remaining {
+			frames = int(remaining)
+		}
+	}
+
+	scale := g.Amplitude * math.MaxInt16
+	for i := 0; i < frames; i++ {
+		var v float64
+		switch g.Waveform {
+		case WaveformSine:
+			t := float64(g.position+int64(i)) / float64(g.format.SampleRate)
+			v = math.Sin(2 * math.Pi * g.Frequency * t)
+		case WaveformNoise:
+			v = g.rng.Float64()*2 - 1
+		}
+		sample := int16(v * scale)
+		for c := 0; c < channels; c++ {
+			buf[i*channels+c] = sample
+		}
+	}
+	g.position += int64(frames)
+
+	n := frames * channels
+	if g.Realtime {
+		g.pace.wait(n, g.format)
+	}
+	return n, nil
+}
+
+// Close does nothing for a generator
+func (g *GeneratorSource) Close() error {
+	return nil
+}
+
+// Format reports the generated format
+func (g *GeneratorSource) Format() Format {
+	return g.format
+}
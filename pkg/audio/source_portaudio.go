@@ -0,0 +1,177 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// PortAudioSource captures audio from a microphone
+type PortAudioSource struct {
+	Device       string        // Device index or name, empty for the default input device
+	LowLatency   bool          // Ask for small device buffers, for streams that stay open
+	StallTimeout time.Duration // Read fails with ErrSourceStalled after this long without audio
+
+	format          Format // Requested format
+	openFormat      Format // Format of the open stream
+	framesPerBuffer int
+	stream          *portaudio.Stream
+	ring            *sampleRing
+	closed          chan struct{}
+
+	// Counters updated on the audio thread
+	buffers        atomic.Uint64
+	droppedBuffers atomic.Uint64
+	droppedSamples atomic.Uint64
+	overflows      atomic.Uint64
+}
+
+// ringBuffers is how many device buffers the ring between the audio thread and the reader holds
+const ringBuffers = 64
+
+// NewPortAudioSource creates a microphone source with the given capture parameters.
+// A sample rate of zero uses the device's default rate.
+func NewPortAudioSource(sampleRate, channels, framesPerBuffer int) *PortAudioSource {
+	return &PortAudioSource{
+		StallTimeout:    2 * time.Second,
+		format:          Format{SampleRate: sampleRate, Channels: channels},
+		framesPerBuffer: framesPerBuffer,
+	}
+}
+
+// Open opens and starts the input stream
+func (s *PortAudioSource) Open() error {
+	return s.open(s.Device)
+}
+
+// Reopen closes the stream and opens it again. If the configured device cannot be
+// opened any more, the default input device is used.
+func (s *PortAudioSource) Reopen() error {
+	s.Close()
+	err := s.open(s.Device)
+	if err != nil && s.Device != "" {
+		log.Printf("Failed to reopen input device %q, trying the default device: %v", s.Device, err)
+		err = s.open("")
+	}
+	return err
+}
+
+// open opens and starts a stream on the selected device
+func (s *PortAudioSource) open(selector string) error {
+	s.closed = make(chan struct{})
+	s.buffers.Store(0)
+	s.droppedBuffers.Store(0)
+	s.droppedSamples.Store(0)
+	s.overflows.Store(0)
+
+	// Initialize per session so devices plugged in since the last one are seen
+	if err := portaudio.Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize audio: %v", err)
+	}
+
+	device, err := findInputDevice(selector)
+	if err != nil {
+		portaudio.Terminate()
+		return err
+	}
+	log.Printf("Using input device: %s", device.Name)
+
+	params := portaudio.HighLatencyParameters(device, nil)
+	if s.LowLatency {
+		params = portaudio.LowLatencyParameters(device, nil)
+	}
+	params.Input.Channels = s.format.Channels
+	if s.format.SampleRate > 0 {
+		params.SampleRate = float64(s.format.SampleRate)
+	}
+	params.FramesPerBuffer = s.framesPerBuffer
+	s.ring = newSampleRing(ringBuffers * s.framesPerBuffer * s.format.Channels)
+
+	stream, err := portaudio.OpenStream(params, s.audioInputCallback)
+	if err != nil {
+		portaudio.Terminate()
+		return fmt.Errorf("failed to open audio stream: %v", err)
+	}
+
+	if err := stream.Start(); err != nil {
+		stream.Close()
+		portaudio.Terminate()
+		return fmt.Errorf("failed to start audio stream: %v", err)
+	}
+	s.stream = stream
+	s.openFormat = Format{SampleRate: int(params.SampleRate), Channels: s.format.Channels}
+	return nil
+}
+
+// audioInputCallback receives buffers on the portaudio thread.
+// It only copies into the preallocated ring; everything else happens in Read.
+func (s *PortAudioSource) audioInputCallback(in []int16, _ portaudio.StreamCallbackTimeInfo, flags portaudio.StreamCallbackFlags) {
+	s.buffers.Add(1)
+	if flags&portaudio.InputOverflow != 0 {
+		s.overflows.Add(1)
+	}
+	if !s.ring.write(in) {
+		// Reader is too slow, drop the buffer rather than block the audio thread
+		s.droppedBuffers.Add(1)
+		s.droppedSamples.Add(uint64(len(in)))
+	}
+}
+
+// Read returns the next captured samples, blocking until some are available
+func (s *PortAudioSource) Read(buf []int16) (int, error) {
+	// Only hand out whole frames
+	buf = buf[:len(buf)-len(buf)%s.format.Channels]
+	var stalled <-chan time.Time
+	for {
+		if n := s.ring.read(buf); n > 0 {
+			return n, nil
+		}
+		if stalled == nil && s.StallTimeout > 0 {
+			timer := time.NewTimer(s.StallTimeout)
+			defer timer.Stop()
+			stalled = timer.C
+		}
+		select {
+		case <-s.ring.ready:
+		case <-stalled:
+			return 0, ErrSourceStalled
+		case <-s.closed:
+			if n := s.ring.read(buf); n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+	}
+}
+
+// Close stops and closes the input stream
+func (s *PortAudioSource) Close() error {
+	if s.stream == nil {
+		return nil
+	}
+	close(s.closed)
+	s.stream.Stop()
+	err := s.stream.Close()
+	s.stream = nil
+	portaudio.Terminate()
+	return err
+}
+
+// Stats reports the buffers captured and dropped since Open
+func (s *PortAudioSource) Stats() CaptureStats {
+	return CaptureStats{
+		Buffers:        s.buffers.Load(),
+		DroppedBuffers: s.droppedBuffers.Load(),
+		DroppedSamples: s.droppedSamples.Load(),
+		Overflows:      s.overflows.Load(),
+	}
+}
+
+// Format reports the capture format of the open stream
+func (s *PortAudioSource) Format() Format {
+	return s.openFormat
+}
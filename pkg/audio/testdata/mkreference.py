@@ -0,0 +1,308 @@
+#!/usr/bin/env python3
+"""Writes reference.flac and reference.pcm for the FLAC decoder tests.
+
+The encoder below is written from RFC 9639 and shares no code with the Go
+package: CRC-8, CRC-16 and the bit writer are implemented here, and the MD5 comes
+from hashlib. The stream uses what the Go encoder never produces, so the decoder
+is checked against more than its own writer: SEEKTABLE, VORBIS_COMMENT and
+PADDING blocks, every stereo decorrelation mode, constant, verbatim, fixed and
+LPC subframes, wasted bits, Rice partitions with both parameter widths and the
+escape code, and sample rate and block size codes stored in the frame header.
+
+reference.flac can be replaced by the output of the reference encoder, the test
+only compares the decoded audio with reference.pcm:
+
+    flac --force-raw-format --endian=little --sign=signed --channels=2 \\
+        --bps=16 --sample-rate=44100 -o reference.flac reference.pcm
+"""
+
+import hashlib
+import math
+import struct
+
+RATE = 44100
+BPS = 16
+BLOCK = 2304
+
+
+def crc8(data):
+    crc = 0
+    for b in data:
+        crc ^= b
+        for _ in range(8):
+            crc = ((crc << 1) ^ 0x07) & 0xFF if crc & 0x80 else (crc << 1) & 0xFF
+    return crc
+
+
+def crc16(data):
+    crc = 0
+    for b in data:
+        crc ^= b << 8
+        for _ in range(8):
+            crc = ((crc << 1) ^ 0x8005) & 0xFFFF if crc & 0x8000 else (crc << 1) & 0xFFFF
+    return crc
+
+
+class Bits:
+    def __init__(self):
+        self.bits = []
+
+    def put(self, value, n):
+        for i in range(n - 1, -1, -1):
+            self.bits.append((value >> i) & 1)
+
+    def signed(self, value, n):
+        assert -(1 << (n - 1)) <= value < (1 << (n - 1)), (value, n)
+        self.put(value & ((1 << n) - 1), n)
+
+    def unary(self, q):
+        self.bits.extend([0] * q)
+        self.bits.append(1)
+
+    def align(self):
+        while len(self.bits) % 8:
+            self.bits.append(0)
+
+    def bytes(self):
+        assert len(self.bits) % 8 == 0
+        out = bytearray()
+        for i in range(0, len(self.bits), 8):
+            v = 0
+            for b in self.bits[i:i + 8]:
+                v = v << 1 | b
+            out.append(v)
+        return bytes(out)
+
+
+def utf8_number(n):
+    if n < 0x80:
+        return bytes([n])
+    if n < 0x800:
+        return bytes([0xC0 | n >> 6, 0x80 | n & 0x3F])
+    raise ValueError(n)
+
+
+def zigzag(r):
+    return 2 * r if r >= 0 else -2 * r - 1
+
+
+def rice_cost(values, k):
+    return sum((zigzag(r) >> k) + 1 + k for r in values)
+
+
+def residual_coding(out, residual, n, order, porder, wide=False, escape=None):
+    """Writes the residual in 2**porder partitions. wide selects 5-bit parameters;
+    escape is the index of a partition to store as raw bits."""
+    out.put(1 if wide else 0, 2)
+    out.put(porder, 4)
+    pbits = 5 if wide else 4
+    limit = 30 if wide else 14
+    pos = 0
+    for p in range(1 << porder):
+        count = (n >> porder) - (order if p == 0 else 0)
+        part = residual[pos:pos + count]
+        pos += count
+        if p == escape:
+            width = max(max(abs(r) for r in part).bit_length() + 1, 1)
+            out.put((1 << pbits) - 1, pbits)
+            out.put(width, 5)
+            for r in part:
+                out.signed(r, width)
+            continue
+        k = min(range(limit + 1), key=lambda k: rice_cost(part, k))
+        out.put(k, pbits)
+        for r in part:
+            u = zigzag(r)
+            out.unary(u >> k)
+            out.put(u & ((1 << k) - 1), k)
+    assert pos == len(residual)
+
+
+def subframe_header(out, kind, wasted):
+    out.put(0, 1)
+    out.put(kind, 6)
+    out.put(1 if wasted else 0, 1)
+    if wasted:
+        out.unary(wasted - 1)
+
+
+def constant(out, x, bps):
+    assert all(v == x[0] for v in x)
+    subframe_header(out, 0, 0)
+    out.signed(x[0], bps)
+
+
+def verbatim(out, x, bps):
+    subframe_header(out, 1, 0)
+    for v in x:
+        out.signed(v, bps)
+
+
+FIXED = [[], [1], [2, -1], [3, -3, 1], [4, -6, 4, -1]]
+
+
+def fixed(out, x, bps, order, porder, wasted=0, **coding):
+    subframe_header(out, 8 | order, wasted)
+    if wasted:
+        assert all(v % (1 << wasted) == 0 for v in x)
+        x = [v >> wasted for v in x]
+        bps -= wasted
+    for v in x[:order]:
+        out.signed(v, bps)
+    c = FIXED[order]
+    residual = [x[i] - sum(c[j] * x[i - 1 - j] for j in range(order)) for i in range(order, len(x))]
+    residual_coding(out, residual, len(x), order, porder, **coding)
+
+
+def lpc_coefficients(x, order, precision):
+    """Levinson-Durbin on the windowed autocorrelation, quantized like libFLAC"""
+    n = len(x)
+    w = [x[i] * (0.5 - 0.5 * math.cos(2 * math.pi * i / (n - 1))) for i in range(n)]
+    r = [sum(w[i] * w[i + lag] for i in range(n - lag)) for lag in range(order + 1)]
+    r[0] *= 1.0001
+    a = [0.0] * order
+    err = r[0]
+    for i in range(order):
+        k = (r[i + 1] - sum(a[j] * r[i - j] for j in range(i))) / err
+        a = [a[j] - k * a[i - 1 - j] for j in range(i)] + [k] + a[i + 1:]
+        err *= 1 - k * k
+    shift = precision - 1 - max(0, math.floor(math.log2(max(abs(v) for v in a))) + 1)
+    shift = max(0, min(15, shift))
+    limit = (1 << (precision - 1)) - 1
+    return [max(-limit - 1, min(limit, round(v * (1 << shift)))) for v in a], shift
+
+
+def lpc(out, x, bps, order, porder, precision=12, **coding):
+    coeffs, shift = lpc_coefficients(x, order, precision)
+    subframe_header(out, 32 | (order - 1), 0)
+    for v in x[:order]:
+        out.signed(v, bps)
+    out.put(precision - 1, 4)
+    out.signed(shift, 5)
+    for c in coeffs:
+        out.signed(c, precision)
+    residual = [x[i] - (sum(coeffs[j] * x[i - 1 - j] for j in range(order)) >> shift)
+                for i in range(order, len(x))]
+    residual_coding(out, residual, len(x), order, porder, **coding)
+
+
+def frame(number, n, assignment, rate_code, size_code, subframes):
+    out = Bits()
+    out.put(0xFFF8, 16)
+    block_code, block_extra = {
+        BLOCK: (4, None), 784: (7, (784 - 1, 16)),
+    }[n]
+    out.put(block_code, 4)
+    out.put(rate_code, 4)
+    out.put(assignment, 4)
+    out.put(size_code, 3)
+    out.put(0, 1)
+    for b in utf8_number(number):
+        out.put(b, 8)
+    if block_extra:
+        out.put(*block_extra)
+    if rate_code == 13:
+        out.put(RATE, 16)
+    elif rate_code == 14:
+        out.put(RATE // 10, 16)
+    out.put(crc8(out.bytes()), 8)
+    for write in subframes:
+        write(out)
+    out.align()
+    data = out.bytes()
+    return data + struct.pack(">H", crc16(data))
+
+
+def signal(n, start, freq, amp, seed):
+    """A tone with a little pseudo-random noise"""
+    state = seed
+    out = []
+    for i in range(start, start + n):
+        state = (state * 1103515245 + 12345) & 0x7FFFFFFF
+        out.append(int(amp * math.sin(2 * math.pi * freq * i / RATE)) + (state >> 16) % 64 - 32)
+    return out
+
+
+def main():
+    left, right, frames = [], [], []
+    start = 0
+
+    # Independent channels: LPC with four partitions, and fixed order 2
+    l, r = signal(BLOCK, start, 440, 9000, 1), signal(BLOCK, start, 660, 6000, 2)
+    frames.append(frame(0, BLOCK, 1, 9, 4, [
+        lambda o: lpc(o, l, 16, 8, 2),
+        lambda o: fixed(o, r, 16, 2, 0),
+    ]))
+    left += l; right += r; start += BLOCK
+
+    # Left/side: the left channel has two wasted bits, the side channel uses Rice2
+    l = [v >> 2 << 2 for v in signal(BLOCK, start, 440, 9000, 3)]
+    r = signal(BLOCK, start, 440, 8000, 4)
+    side = [a - b for a, b in zip(l, r)]
+    frames.append(frame(1, BLOCK, 8, 0, 0, [
+        lambda o: fixed(o, l, 16, 1, 3, wasted=2),
+        lambda o: lpc(o, side, 17, 4, 1, wide=True),
+    ]))
+    left += l; right += r; start += BLOCK
+
+    # Side/right: a constant side channel
+    r = signal(BLOCK, start, 330, 12000, 5)
+    l = [v + 100 for v in r]
+    frames.append(frame(2, BLOCK, 9, 13, 4, [
+        lambda o: constant(o, [a - b for a, b in zip(l, r)], 17),
+        lambda o: lpc(o, r, 16, 6, 3, precision=15),
+    ]))
+    left += l; right += r; start += BLOCK
+
+    # Mid/side with an odd side channel, and an escaped partition
+    l = signal(BLOCK, start, 550, 15000, 6)
+    r = [v - 2 * (i % 5) - 1 for i, v in enumerate(signal(BLOCK, start, 550, 14000, 7))]
+    mid = [(a + b) >> 1 for a, b in zip(l, r)]
+    side = [a - b for a, b in zip(l, r)]
+    frames.append(frame(3, BLOCK, 10, 14, 0, [
+        lambda o: lpc(o, mid, 16, 3, 2),
+        lambda o: fixed(o, side, 17, 0, 2, escape=1),
+    ]))
+    left += l; right += r; start += BLOCK
+
+    # A short last frame: silence and verbatim extremes
+    n = 784
+    l = [0] * n
+    r = [(32767 if i % 2 else -32768) if i % 7 == 0 else i * 37 % 2000 - 1000 for i in range(n)]
+    frames.append(frame(4, n, 1, 0, 0, [
+        lambda o: constant(o, l, 16),
+        lambda o: verbatim(o, r, 16),
+    ]))
+    left += l; right += r
+
+    total = len(left)
+    pcm = b"".join(struct.pack("<hh", a, b) for a, b in zip(left, right))
+
+    info = struct.pack(">HH", BLOCK, BLOCK)
+    info += struct.pack(">I", min(len(f) for f in frames))[1:]
+    info += struct.pack(">I", max(len(f) for f in frames))[1:]
+    info += struct.pack(">Q", RATE << 44 | (2 - 1) << 41 | (BPS - 1) << 36 | total)
+    info += hashlib.md5(pcm).digest()
+
+    seek = struct.pack(">QQH", 0, 0, BLOCK)
+    seek += struct.pack(">QQH", 2 * BLOCK, len(frames[0]) + len(frames[1]), BLOCK)
+    seek += struct.pack(">QQH", 0xFFFFFFFFFFFFFFFF, 0, 0)
+    vendor = b"autospeech mkreference.py"
+    comment = struct.pack("<I", len(vendor)) + vendor + struct.pack("<I", 1)
+    tag = "TITLE=Referenz".encode()
+    comment += struct.pack("<I", len(tag)) + tag
+
+    def block(kind, data, last=False):
+        return struct.pack(">I", (0x80 if last else 0) << 24 | kind << 24 | len(data)) + data
+
+    stream = b"fLaC" + block(0, info) + block(3, seek) + block(4, comment) + block(1, bytes(64), last=True)
+    stream += b"".join(frames)
+
+    with open("reference.flac", "wb") as f:
+        f.write(stream)
+    with open("reference.pcm", "wb") as f:
+        f.write(pcm)
+    print("reference.flac: %d bytes, %d samples, md5 %s" % (len(stream), total, hashlib.md5(pcm).hexdigest()))
+
+
+main()
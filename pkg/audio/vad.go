@@ -0,0 +1,215 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// VADEventType identifies a voice activity transition
+type VADEventType int
+
+const (
+	// SpeechStart is reported once speech has lasted MinSpeech
+	SpeechStart VADEventType = iota
+	// SpeechEnd is reported once silence has lasted MinSilence after speech
+	SpeechEnd
+)
+
+// String returns the event name
+func (t VADEventType) String() string {
+	if t == SpeechStart {
+		return "speech start"
+	}
+	return "speech end"
+}
+
+// VADEvent is a voice activity transition at a position in the stream
+type VADEvent struct {
+	Type   VADEventType
+	Offset time.Duration // Position in the stream where the transition happened
+}
+
+// maxInitialNoiseFloor caps the seeded noise floor at -45 dBFS
+var maxInitialNoiseFloor = math.Pow(10, -45.0/10)
+
+// VADConfig tunes the voice activity detector
+type VADConfig struct {
+	FrameDuration time.Duration // Analysis frame length
+	ThresholdDB   float64       // Energy above the noise floor that counts as speech
+	MinLevelDB    float64       // Absolute level below which a frame is never speech
+	MinSpeech     time.Duration // Speech needed before SpeechStart is reported
+	MinSilence    time.Duration // Silence needed before SpeechEnd is reported
+}
+
+// DefaultVADConfig returns settings that work for close-talking microphones
+func DefaultVADConfig() VADConfig {
+	return VADConfig{
+		FrameDuration: 20 * time.Millisecond,
+		ThresholdDB:   9,
+		MinLevelDB:    -55,
+		MinSpeech:     100 * time.Millisecond,
+		MinSilence:    500 * time.Millisecond,
+	}
+}
+
+// VAD detects speech in mono audio using frame energy and zero-crossing rate
+// against an adaptive noise floor
+type VAD struct {
+	cfg        VADConfig
+	sampleRate int
+	frameSize  int
+	pending    []int16
+	position   int64   // Samples analysed so far
+	noiseFloor float64 // Mean square energy of background noise
+	frames     int     // Frames analysed so far
+	inSpeech   bool
+	speechRun  int // Consecutive speech frames
+	silenceRun int // Consecutive non-speech frames
+	runStart   int64
+	lastSpeech int64 // Sample position after the last speech frame
+}
+
+// NewVAD creates a detector for mono audio at the given sample rate
+func NewVAD(sampleRate int, cfg VADConfig) *VAD {
+	if cfg.FrameDuration <= 0 {
+		cfg.FrameDuration = DefaultVADConfig().FrameDuration
+	}
+	frameSize := int(int64(sampleRate) * int64(cfg.FrameDuration) / int64(time.Second))
+	if frameSize < 1 {
+		frameSize = 1
+	}
+	return &VAD{
+		cfg:        cfg,
+		sampleRate: sampleRate,
+		frameSize:  frameSize,
+	}
+}
+
+// Process analyses the next samples and returns any transitions they complete
+func (v *VAD) Process(samples []int16) []VADEvent {
+	var events []VADEvent
+	v.pending = append(v.pending, samples...)
+	for len(v.pending) >= v.frameSize {
+		if ev, ok := v.processFrame(v.pending[:v.frameSize]); ok {
+			events = append(events, ev)
+		}
+		v.pending = v.pending[v.frameSize:]
+	}
+	// Keep the partial frame without holding on to the old backing array
+	v.pending = append([]int16(nil), v.pending...)
+	return events
+}
+
+// InSpeech reports whether the detector is currently inside a speech segment
+func (v *VAD) InSpeech() bool {
+	return v.inSpeech
+}
+
+// TrailingSilence returns how long it has been quiet since the last speech frame
+func (v *VAD) TrailingSilence() time.Duration {
+	return v.duration(v.position - v.lastSpeech)
+}
+
+// processFrame classifies one frame and updates the speech state
+func (v *VAD) processFrame(frame []int16) (VADEvent, bool) {
+	energy, zcr := frameFeatures(frame)
+	start := v.position
+	v.position += int64(len(frame))
+	v.frames++
+
+	// Seed the noise floor with the quietest of the first frames, capped in case
+	// the user is already talking when the stream opens
+	if v.frames <= 5 {
+		if v.frames == 1 || energy < v.noiseFloor {
+			v.noiseFloor = math.Min(energy, maxInitialNoiseFloor)
+		}
+	}
+
+	speech := v.isSpeech(energy, zcr)
+	v.adaptNoiseFloor(energy, speech)
+
+	if speech {
+		if v.speechRun == 0 {
+			v.runStart = start
+		}
+		v.speechRun++
+		v.silenceRun = 0
+		v.lastSpeech = v.position
+	} else {
+		if v.silenceRun == 0 {
+			v.runStart = start
+		}
+		v.silenceRun++
+		v.speechRun = 0
+	}
+
+	if !v.inSpeech && v.duration(int64(v.speechRun*v.frameSize)) >= v.cfg.MinSpeech && v.speechRun > 0 {
+		v.inSpeech = true
+		return VADEvent{Type: SpeechStart, Offset: v.duration(v.runStart)}, true
+	}
+	if v.inSpeech && v.silenceRun > 0 && v.duration(int64(v.silenceRun*v.frameSize)) >= v.cfg.MinSilence {
+		v.inSpeech = false
+		return VADEvent{Type: SpeechEnd, Offset: v.duration(v.runStart)}, true
+	}
+	return VADEvent{}, false
+}
+
+// isSpeech decides whether a frame contains speech
+func (v *VAD) isSpeech(energy, zcr float64) bool {
+	level := energyToDB(energy)
+	if level < v.cfg.MinLevelDB {
+		return false
+	}
+	aboveFloor := level - energyToDB(v.noiseFloor)
+	if aboveFloor >= v.cfg.ThresholdDB {
+		return true
+	}
+	// Unvoiced consonants are quieter but have a high zero-crossing rate
+	return aboveFloor >= v.cfg.ThresholdDB/2 && zcr > 0.25 && zcr < 0.6
+}
+
+// adaptNoiseFloor tracks background noise quickly in silence and slowly during speech
+func (v *VAD) adaptNoiseFloor(energy float64, speech bool) {
+	if v.frames <= 5 {
+		return
+	}
+	rate := 0.05
+	if speech {
+		rate = 0.001
+	}
+	if energy < v.noiseFloor {
+		// Follow drops quickly so a loud start does not mask later speech
+		rate = 0.5
+	}
+	v.noiseFloor += (energy - v.noiseFloor) * rate
+}
+
+// duration converts a sample count to time
+func (v *VAD) duration(samples int64) time.Duration {
+	return time.Duration(samples) * time.Second / time.Duration(v.sampleRate)
+}
+
+// frameFeatures returns the mean square energy (full scale = 1) and the zero-crossing rate of a frame
+func frameFeatures(frame []int16) (float64, float64) {
+	if len(frame) == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	crossings := 0
+	for i, s := range frame {
+		x := float64(s) / 32768
+		sum += x * x
+		if i > 0 && (s >= 0) != (frame[i-1] >= 0) {
+			crossings++
+		}
+	}
+	return sum / float64(len(frame)), float64(crossings) / float64(len(frame))
+}
+
+// energyToDB converts mean square energy to dBFS
+func energyToDB(energy float64) float64 {
+	if energy <= 1e-12 {
+		return -120
+	}
+	return 10 * math.Log10(energy)
+}
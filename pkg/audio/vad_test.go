@@ -0,0 +1,136 @@
+package audio
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// scaled returns samples multiplied by gain
+func scaled(samples []int16, gain float64) []int16 {
+	out := make([]int16, len(samples))
+	for i, s := range samples {
+		out[i] = int16(float64(s) * gain)
+	}
+	return out
+}
+
+// reported is an event with the stream position at which the VAD returned it
+type reported struct {
+	VADEvent
+	At time.Duration
+}
+
+func (r reported) String() string {
+	return fmt.Sprintf("%v at %v reported at %v", r.Type, r.Offset, r.At)
+}
+
+func TestVADHangover(t *testing.T) {
+	start := func(offset, at float64) reported { return reported{VADEvent{SpeechStart, secs(offset)}, secs(at)} }
+	end := func(offset, at float64) reported { return reported{VADEvent{SpeechEnd, secs(offset)}, secs(at)} }
+	tests := []struct {
+		name  string
+		parts [][]int16
+		want  []reported
+	}{
+		{
+			// Speech counts once it lasted MinSpeech and ends once silence lasted
+			// MinSilence, both dated back to where the run began
+			name:  "word",
+			parts: [][]int16{quiet(0.5), words(1), quiet(1)},
+			want:  []reported{start(0.5, 0.6), end(0.9, 1.4)},
+		},
+		{
+			name:  "speech from the first frame",
+			parts: [][]int16{words(1), quiet(1)},
+			want:  []reported{start(0, 0.1), end(0.4, 0.9)},
+		},
+		{
+			name:  "click shorter than the minimum speech",
+			parts: [][]int16{quiet(0.5), words(1)[:dspRate*6/100], quiet(1)},
+		},
+		{
+			name:  "gaps between words",
+			parts: [][]int16{quiet(0.2), words(5), quiet(1)},
+			want:  []reported{start(0.2, 0.3), end(2.6, 3.1)},
+		},
+		{
+			name:  "pause just below the minimum silence",
+			parts: [][]int16{words(1), quiet(0.48), words(1), quiet(1)},
+			want:  []reported{start(0, 0.1), end(1.28, 1.78)},
+		},
+		{
+			name:  "pause of the minimum silence",
+			parts: [][]int16{words(1), quiet(0.5), words(1), quiet(1)},
+			want:  []reported{start(0, 0.1), end(0.4, 0.9), start(0.9, 1.0), end(1.3, 1.8)},
+		},
+		{
+			name:  "still talking at the end",
+			parts: [][]int16{quiet(0.3), words(2), quiet(0.3)},
+			want:  []reported{start(0.3, 0.4)},
+		},
+		{
+			name:  "below the minimum level",
+			parts: [][]int16{quiet(0.5), scaled(words(3), 0.004), quiet(1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var samples []int16
+			for _, part := range tt.parts {
+				samples = append(samples, part...)
+			}
+
+			// Feed 10ms at a time, half a frame, and note when each event comes out
+			vad := NewVAD(dspRate, DefaultVADConfig())
+			chunk := dspRate / 100
+			var got []reported
+			for pos := 0; pos < len(samples); pos += chunk {
+				next := min(pos+chunk, len(samples))
+				for _, ev := range vad.Process(samples[pos:next]) {
+					got = append(got, reported{ev, secs(float64(next) / dspRate)})
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+			ended := len(tt.want) == 0 || tt.want[len(tt.want)-1].Type == SpeechEnd
+			if vad.InSpeech() == ended {
+				t.Errorf("in speech at the end = %v, want %v", vad.InSpeech(), !ended)
+			}
+
+			// The same events come out when everything arrives at once
+			var events []VADEvent
+			for _, r := range got {
+				events = append(events, r.VADEvent)
+			}
+			if all := NewVAD(dspRate, DefaultVADConfig()).Process(samples); !slices.Equal(all, events) {
+				t.Errorf("events for the whole signal = %v, want %v", all, events)
+			}
+		})
+	}
+}
+
+func TestVADTrailingSilence(t *testing.T) {
+	vad := NewVAD(dspRate, DefaultVADConfig())
+	vad.Process(words(1))
+	if !vad.InSpeech() || vad.TrailingSilence() != 0 {
+		t.Fatalf("after a word: in speech %v with %v of silence", vad.InSpeech(), vad.TrailingSilence())
+	}
+	// During the hangover the VAD is still in speech, but the silence is counted
+	vad.Process(quiet(0.3))
+	if !vad.InSpeech() || vad.TrailingSilence() != 300*time.Millisecond {
+		t.Errorf("0.3s into a pause: in speech %v with %v of silence, want true and 300ms", vad.InSpeech(), vad.TrailingSilence())
+	}
+	// The end of the hangover falls in half a frame that is not analysed yet
+	if events := vad.Process(quiet(0.19)); len(events) != 0 {
+		t.Errorf("events 0.49s into a pause = %v, want none", events)
+	}
+	if events := vad.Process(quiet(0.01)); len(events) != 1 || events[0] != (VADEvent{SpeechEnd, 400 * time.Millisecond}) {
+		t.Errorf("events after 0.5s of silence = %v, want speech end at 400ms", events)
+	}
+	if vad.InSpeech() || vad.TrailingSilence() != 500*time.Millisecond {
+		t.Errorf("after the pause: in speech %v with %v of silence, want false and 500ms", vad.InSpeech(), vad.TrailingSilence())
+	}
+}
@@ -1,97 +1,193 @@
 package audio
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+)
 
-	"github.com/tarasowski/autospeech/pkg/config"
+// Header layout written by WavWriter: RIFF header, a JUNK chunk reserved for the
+// RF64 ds64 chunk, the fmt chunk and the data chunk header
+const (
+	wavJunkOffset  = 12
+	wavJunkSize    = 28
+	wavFmtOffset   = wavJunkOffset + 8 + wavJunkSize
+	wavDataOffset  = wavFmtOffset + 8 + 16
+	wavHeaderSize  = wavDataOffset + 8
+	wavMaxRiffSize = math.MaxUint32
 )
 
+// WavWriter streams 16-bit PCM to a WAV file and patches the size fields on Close.
+// Files that outgrow the 4 GiB RIFF limit are turned into RF64 files.
+type WavWriter struct {
+	w        io.WriteSeeker
+	closer   io.Closer
+	format   Format
+	dataSize int64
+	closed   bool
+}
+
+// NewWavWriter writes a provisional WAV header to w and returns a writer for the sample data
+func NewWavWriter(w io.WriteSeeker, format Format) (*WavWriter, error) {
+	if format.SampleRate <= 0 || format.Channels <= 0 {
+		return nil, fmt.Errorf("invalid WAV format %v", format)
+	}
+	ww := &WavWriter{w: w, format: format}
+	if _, err := w.Write(ww.header()); err != nil {
+		return nil, fmt.Errorf("failed to write WAV header: %v", err)
+	}
+	return ww, nil
+}
+
+// CreateWav creates the file at path, including missing directories, and returns a writer for it
+func CreateWav(path string, format Format) (*WavWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WAV file: %v", err)
+	}
+	ww, err := NewWavWriter(file, format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ww.closer = file
+	return ww, nil
+}
+
+// Write appends raw little-endian 16-bit PCM bytes
+func (w *WavWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to closed WAV writer")
+	}
+	n, err := w.w.Write(p)
+	w.dataSize += int64(n)
+	return n, err
+}
+
+// WriteSamples appends interleaved 16-bit samples
+func (w *WavWriter) WriteSamples(samples []int16) error {
+	_, err := w.Write(AppendPCM16(make([]byte, 0, len(samples)*2), samples))
+	return err
+}
+
+// DataSize returns the number of sample bytes written so far
+func (w *WavWriter) DataSize() int64 {
+	return w.dataSize
+}
+
+// Close pads the data chunk, patches the header with the final sizes and closes the file
+func (w *WavWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.finish()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// finish writes the pad byte and the final header
+func (w *WavWriter) finish() error {
+	// Chunks must have an even length
+	if w.dataSize%2 == 1 {
+		if _, err := w.w.Write([]byte{0}); err != nil {
+			return fmt.Errorf("failed to pad WAV data: %v", err)
+		}
+	}
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to WAV header: %v", err)
+	}
+	if _, err := w.w.Write(w.header()); err != nil {
+		return fmt.Errorf("failed to update WAV header: %v", err)
+	}
+	if _, err := w.w.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to seek to end of WAV file: %v", err)
+	}
+	return nil
+}
+
+// header builds the file header for the current data size
+func (w *WavWriter) header() []byte {
+	header := make([]byte, wavHeaderSize)
+	le := binary.LittleEndian
+
+	riffSize := int64(wavHeaderSize-8) + w.dataSize + w.dataSize%2
+	rf64 := riffSize > wavMaxRiffSize
+
+	if rf64 {
+		copy(header[0:4], "RF64")
+		le.PutUint32(header[4:8], math.MaxUint32)
+	} else {
+		copy(header[0:4], "RIFF")
+		le.PutUint32(header[4:8], uint32(riffSize))
+	}
+	copy(header[8:12], "WAVE")
+
+	// JUNK chunk that becomes the ds64 chunk once the file needs 64-bit sizes
+	junk := header[wavJunkOffset:]
+	le.PutUint32(junk[4:8], wavJunkSize)
+	if rf64 {
+		copy(junk[0:4], "ds64")
+		le.PutUint64(junk[8:16], uint64(riffSize))
+		le.PutUint64(junk[16:24], uint64(w.dataSize))
+		le.PutUint64(junk[24:32], uint64(w.dataSize/int64(w.format.Channels*2)))
+		le.PutUint32(junk[32:36], 0) // No table entries
+	} else {
+		copy(junk[0:4], "JUNK")
+	}
+
+	fmtChunk := header[wavFmtOffset:]
+	copy(fmtChunk[0:4], "fmt ")
+	le.PutUint32(fmtChunk[4:8], 16)
+	le.PutUint16(fmtChunk[8:10], wavFormatPCM)
+	le.PutUint16(fmtChunk[10:12], uint16(w.format.Channels))
+	le.PutUint32(fmtChunk[12:16], uint32(w.format.SampleRate))
+	le.PutUint32(fmtChunk[16:20], uint32(w.format.SampleRate*w.format.Channels*2))
+	le.PutUint16(fmtChunk[20:22], uint16(w.format.Channels*2))
+	le.PutUint16(fmtChunk[22:24], 16)
+
+	data := header[wavDataOffset:]
+	copy(data[0:4], "data")
+	if rf64 {
+		le.PutUint32(data[4:8], math.MaxUint32)
+	} else {
+		le.PutUint32(data[4:8], uint32(w.dataSize))
+	}
+	return header
+}
+
 // SaveAsWav converts raw audio data to a WAV file
 func SaveAsWav(audioData []byte, outputFile string) error {
 	log.Println("Creating WAV file...")
-	
-	// Create a simple WAV header (44 bytes)
-	header := make([]byte, 44)
-
-	// RIFF header
-	copy(header[0:4], []byte("RIFF"))
-	// File size (to be filled later)
-	// WAVE header
-	copy(header[8:12], []byte("WAVE"))
-	// fmt chunk
-	copy(header[12:16], []byte("fmt "))
-	// Chunk size: 16 for PCM
-	header[16] = 16
-	header[17] = 0
-	header[18] = 0
-	header[19] = 0
-	// Audio format: 1 for PCM
-	header[20] = 1
-	header[21] = 0
-	// Number of channels: 1 for mono
-	header[22] = byte(config.Channels)
-	header[23] = 0
-	// Sample rate (16000)
-	sampleRateBytes := uint32(config.SampleRate)
-	header[24] = byte(sampleRateBytes)
-	header[25] = byte(sampleRateBytes >> 8)
-	header[26] = byte(sampleRateBytes >> 16)
-	header[27] = byte(sampleRateBytes >> 24)
-	// Byte rate: SampleRate * NumChannels * BitsPerSample/8
-	byteRate := uint32(config.SampleRate * config.Channels * 16 / 8)
-	header[28] = byte(byteRate)
-	header[29] = byte(byteRate >> 8)
-	header[30] = byte(byteRate >> 16)
-	header[31] = byte(byteRate >> 24)
-	// Block align: NumChannels * BitsPerSample/8
-	blockAlign := config.Channels * 16 / 8
-	header[32] = byte(blockAlign)
-	header[33] = byte(blockAlign >> 8)
-	// Bits per sample
-	header[34] = 16
-	header[35] = 0
-	// data chunk
-	copy(header[36:40], []byte("data"))
-	// Data size
-	dataSize := uint32(len(audioData))
-	header[40] = byte(dataSize)
-	header[41] = byte(dataSize >> 8)
-	header[42] = byte(dataSize >> 16)
-	header[43] = byte(dataSize >> 24)
-
-	// Set file size in header (file size - 8 bytes for RIFF header)
-	fileSize := uint32(len(header) + len(audioData) - 8)
-	header[4] = byte(fileSize)
-	header[5] = byte(fileSize >> 8)
-	header[6] = byte(fileSize >> 16)
-	header[7] = byte(fileSize >> 24)
-
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(outputFile)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		log.Printf("Error creating directory: %v", err)
-		return err
-	}
 
-	// Write header and audio data to file
-	file, err := os.Create(outputFile)
+	writer, err := CreateWav(outputFile, RecognizerFormat)
 	if err != nil {
 		log.Printf("Error creating WAV file: %v", err)
 		return err
 	}
-	defer file.Close()
 
-	if _, err := file.Write(header); err != nil {
-		log.Printf("Error writing WAV header: %v", err)
+	if _, err := writer.Write(audioData); err != nil {
+		log.Printf("Error writing WAV data: %v", err)
+		writer.Close()
 		return err
 	}
-	if _, err := file.Write(audioData); err != nil {
-		log.Printf("Error writing WAV data: %v", err)
+	if err := writer.Close(); err != nil {
+		log.Printf("Error finishing WAV file: %v", err)
 		return err
 	}
 
 	log.Println("WAV file created successfully")
 	return nil
-}
\ No newline at end of file
+}
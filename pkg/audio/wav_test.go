@@ -0,0 +1,164 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+	"testing"
+)
+
+// sparseFile is an in-memory io.WriteSeeker that keeps only its first bytes, so a
+// writer can be driven past 4 GiB without the memory
+type sparseFile struct {
+	head []byte // The first len(head) bytes of the file
+	pos  int64
+	size int64
+}
+
+func newSparseFile(keep int) *sparseFile {
+	return &sparseFile{head: make([]byte, keep)}
+}
+
+func (f *sparseFile) Write(p []byte) (int, error) {
+	if f.pos < int64(len(f.head)) {
+		copy(f.head[f.pos:], p)
+	}
+	f.pos += int64(len(p))
+	f.size = max(f.size, f.pos)
+	return len(p), nil
+}
+
+func (f *sparseFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.pos = offset
+	case io.SeekCurrent:
+		f.pos += offset
+	case io.SeekEnd:
+		f.pos = f.size + offset
+	}
+	return f.pos, nil
+}
+
+func TestWavWriterHeader(t *testing.T) {
+	const riffOverhead = wavHeaderSize - 8
+	tests := []struct {
+		name     string
+		format   Format
+		dataSize int64 // Forced before Close; the writer only sees a few real bytes
+		rf64     bool
+	}{
+		{"empty", RecognizerFormat, 0, false},
+		{"small", RecognizerFormat, 32000, false},
+		{"odd size is padded", Format{SampleRate: 8000, Channels: 1}, 32001, false},
+		{"largest RIFF", Format{SampleRate: 48000, Channels: 2}, math.MaxUint32 - riffOverhead - 1, false},
+		{"just past the RIFF limit", Format{SampleRate: 48000, Channels: 2}, math.MaxUint32 - riffOverhead + 1, true},
+		{"pad byte crosses the RIFF limit", RecognizerFormat, math.MaxUint32 - riffOverhead, true},
+		{"five gigabytes", Format{SampleRate: 44100, Channels: 2}, 5 << 30, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newSparseFile(wavHeaderSize + 64)
+			w, err := NewWavWriter(f, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(make([]byte, 16)); err != nil {
+				t.Fatal(err)
+			}
+			w.dataSize = tt.dataSize
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if f.pos != f.size {
+				t.Errorf("Close left the file offset at %d of %d", f.pos, f.size)
+			}
+
+			h := f.head
+			le := binary.LittleEndian
+			padded := tt.dataSize + tt.dataSize%2
+			riffSize := uint64(riffOverhead + padded)
+			wantTag, wantJunk := "RIFF", "JUNK"
+			if tt.rf64 {
+				wantTag, wantJunk = "RF64", "ds64"
+			}
+			if string(h[0:4]) != wantTag || string(h[8:12]) != "WAVE" {
+				t.Fatalf("file starts with %q...%q, want %s...WAVE", h[0:4], h[8:12], wantTag)
+			}
+			if string(h[12:16]) != wantJunk || le.Uint32(h[16:20]) != wavJunkSize {
+				t.Errorf("reserved chunk %q of %d bytes, want %s of %d", h[12:16], le.Uint32(h[16:20]), wantJunk, wavJunkSize)
+			}
+			if string(h[wavFmtOffset:wavFmtOffset+4]) != "fmt " || string(h[wavDataOffset:wavDataOffset+4]) != "data" {
+				t.Errorf("fmt and data chunks not at %d and %d", wavFmtOffset, wavDataOffset)
+			}
+
+			riffField := le.Uint32(h[4:8])
+			dataField := le.Uint32(h[wavDataOffset+4:])
+			if !tt.rf64 {
+				if uint64(riffField) != riffSize || int64(dataField) != tt.dataSize {
+					t.Errorf("RIFF size %d and data size %d, want %d and %d", riffField, dataField, riffSize, tt.dataSize)
+				}
+				return
+			}
+			// RF64 keeps 0xFFFFFFFF in the 32-bit fields and the real sizes in ds64
+			if riffField != math.MaxUint32 || dataField != math.MaxUint32 {
+				t.Errorf("RIFF size %#x and data size %#x, want 0xFFFFFFFF sentinels", riffField, dataField)
+			}
+			ds64 := h[20:]
+			if got := le.Uint64(ds64[0:8]); got != riffSize {
+				t.Errorf("ds64 RIFF size = %d, want %d", got, riffSize)
+			}
+			if got := le.Uint64(ds64[8:16]); int64(got) != tt.dataSize {
+				t.Errorf("ds64 data size = %d, want %d", got, tt.dataSize)
+			}
+			if got, want := le.Uint64(ds64[16:24]), uint64(tt.dataSize)/uint64(2*tt.format.Channels); got != want {
+				t.Errorf("ds64 sample count = %d, want %d", got, want)
+			}
+			if got := le.Uint32(ds64[24:28]); got != 0 {
+				t.Errorf("ds64 table length = %d, want 0", got)
+			}
+
+			// The reader takes the data size from ds64
+			r, err := NewWavReader(bytes.NewReader(h[:wavHeaderSize]))
+			if err != nil {
+				t.Fatalf("reading the RF64 header: %v", err)
+			}
+			if r.Format() != tt.format || r.remaining != tt.dataSize {
+				t.Errorf("reader sees %v with %d bytes, want %v with %d", r.Format(), r.remaining, tt.format, tt.dataSize)
+			}
+		})
+	}
+}
+
+func TestWavWriterRoundTrip(t *testing.T) {
+	// A file that stays small is a plain RIFF file readers without RF64 support accept
+	f := newSparseFile(1 << 16)
+	format := Format{SampleRate: 22050, Channels: 2}
+	w, err := NewWavWriter(f, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := noise(1001, 2)
+	if err := w.WriteSamples(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteSamples(samples); err == nil {
+		t.Error("write after Close accepted")
+	}
+
+	r, err := NewWavReader(bytes.NewReader(f.head[:f.size]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Format() != format {
+		t.Errorf("format = %v, want %v", r.Format(), format)
+	}
+	if got := readAllSamples(t, r); !slices.Equal(got, samples) {
+		t.Errorf("read back %d samples that differ from the %d written", len(got), len(samples))
+	}
+}
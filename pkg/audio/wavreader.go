@@ -0,0 +1,316 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// WAV format tags
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// Limits that keep malformed headers from causing huge allocations
+const (
+	maxWavChannels   = 64
+	maxWavSampleRate = 768000
+	maxFmtChunkSize  = 1024
+)
+
+// WavReader decodes the sample data of a WAV file into interleaved 16-bit samples.
+// It walks the RIFF (or RF64) chunks, skipping LIST, fact and other chunks it does not need,
+// and accepts 8/16/24/32-bit integer and 32/64-bit float PCM in any channel count.
+type WavReader struct {
+	format        Format
+	bitsPerSample int
+	float         bool
+	blockAlign    int
+	r             *bufio.Reader
+	remaining     int64 // Bytes left in the data chunk, -1 if unknown
+	raw           []byte
+}
+
+// NewWavReader parses the WAV header from r and positions it at the first sample
+func NewWavReader(r io.Reader) (*WavReader, error) {
+	br := bufio.NewReader(r)
+
+	var riff [12]byte
+	if _, err := io.ReadFull(br, riff[:]); err != nil {
+		return nil, fmt.Errorf("invalid WAV file: missing RIFF header")
+	}
+	magic := string(riff[0:4])
+	if (magic != "RIFF" && magic != "RF64") || string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("invalid WAV file: not a RIFF/WAVE file")
+	}
+
+	w := &WavReader{r: br}
+	haveFormat := false
+	ds64DataSize := int64(-1)
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(br, chunk[:]); err != nil {
+			if !haveFormat {
+				return nil, fmt.Errorf("invalid WAV file: missing fmt chunk")
+			}
+			return nil, fmt.Errorf("invalid WAV file: missing data chunk")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "ds64":
+			// RF64 files keep the real sizes in this chunk
+			if magic != "RF64" || size < 24 || size > maxFmtChunkSize {
+				return nil, fmt.Errorf("invalid WAV file: bad ds64 chunk")
+			}
+			ds64 := make([]byte, size+size%2)
+			if _, err := io.ReadFull(br, ds64); err != nil {
+				return nil, fmt.Errorf("invalid WAV file: truncated ds64 chunk")
+			}
+			ds64DataSize = int64(binary.LittleEndian.Uint64(ds64[8:16]))
+			if ds64DataSize < 0 {
+				return nil, fmt.Errorf("invalid WAV file: bad ds64 data size")
+			}
+		case "fmt ":
+			if haveFormat {
+				return nil, fmt.Errorf("invalid WAV file: duplicate fmt chunk")
+			}
+			if err := w.parseFormat(br, size); err != nil {
+				return nil, err
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return nil, fmt.Errorf("invalid WAV file: data chunk before fmt chunk")
+			}
+			w.remaining = size
+			if size == math.MaxUint32 && ds64DataSize >= 0 {
+				w.remaining = ds64DataSize
+			} else if size == 0 || size == math.MaxUint32 {
+				// Streaming writers leave the size at 0 or the maximum when they never patch it
+				w.remaining = -1
+			}
+			return w, nil
+		default:
+			// Skip LIST, fact and any other chunk; chunks are padded to even sizes
+			if _, err := io.CopyN(io.Discard, br, size+size%2); err != nil {
+				return nil, fmt.Errorf("invalid WAV file: truncated %q chunk", id)
+			}
+		}
+	}
+}
+
+// parseFormat reads and validates the fmt chunk
+func (w *WavReader) parseFormat(r io.Reader, size int64) error {
+	if size < 16 {
+		return fmt.Errorf("invalid WAV file: fmt chunk too short (%d bytes)", size)
+	}
+	if size > maxFmtChunkSize {
+		return fmt.Errorf("invalid WAV file: fmt chunk too long (%d bytes)", size)
+	}
+	data := make([]byte, size+size%2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("invalid WAV file: truncated fmt chunk")
+	}
+
+	tag := binary.LittleEndian.Uint16(data[0:2])
+	channels := int(binary.LittleEndian.Uint16(data[2:4]))
+	sampleRate := int64(binary.LittleEndian.Uint32(data[4:8]))
+	blockAlign := int(binary.LittleEndian.Uint16(data[12:14]))
+	bits := int(binary.LittleEndian.Uint16(data[14:16]))
+
+	if tag == wavFormatExtensible {
+		// The sub-format GUID starts with the actual format tag
+		if size < 40 {
+			return fmt.Errorf("invalid WAV file: extensible fmt chunk too short (%d bytes)", size)
+		}
+		tag = binary.LittleEndian.Uint16(data[24:26])
+	}
+
+	switch {
+	case tag == wavFormatPCM && (bits == 8 || bits == 16 || bits == 24 || bits == 32):
+	case tag == wavFormatFloat && (bits == 32 || bits == 64):
+		w.float = true
+	case tag == wavFormatPCM || tag == wavFormatFloat:
+		return fmt.Errorf("unsupported WAV file: %d bits per sample", bits)
+	default:
+		return fmt.Errorf("unsupported WAV file: format tag 0x%04x", tag)
+	}
+
+	if channels < 1 || channels > maxWavChannels {
+		return fmt.Errorf("invalid WAV file: %d channels", channels)
+	}
+	if sampleRate < 1 || sampleRate > maxWavSampleRate {
+		return fmt.Errorf("invalid WAV file: sample rate %d Hz", sampleRate)
+	}
+	if blockAlign != channels*bits/8 {
+		return fmt.Errorf("invalid WAV file: block align %d does not match %d channels of %d bits", blockAlign, channels, bits)
+	}
+
+	w.format = Format{SampleRate: int(sampleRate), Channels: channels}
+	w.bitsPerSample = bits
+	w.blockAlign = blockAlign
+	return nil
+}
+
+// Format reports the sample rate and channel count of the file
+func (w *WavReader) Format() Format {
+	return w.format
+}
+
+// BitsPerSample reports the sample width stored in the file
+func (w *WavReader) BitsPerSample() int {
+	return w.bitsPerSample
+}
+
+// ReadSamples fills buf with interleaved samples converted to 16 bits.
+// Only whole frames are returned; io.EOF marks the end of the data chunk.
+func (w *WavReader) ReadSamples(buf []int16) (int, error) {
+	channels := w.format.Channels
+	frames := len(buf) / channels
+	if frames == 0 {
+		return 0, fmt.Errorf("buffer smaller than one frame")
+	}
+
+	want := int64(frames * w.blockAlign)
+	if w.remaining >= 0 && want > w.remaining {
+		want = w.remaining - w.remaining%int64(w.blockAlign)
+	}
+	if want == 0 {
+		return 0, io.EOF
+	}
+	if int64(cap(w.raw)) < want {
+		w.raw = make([]byte, want)
+	}
+	raw := w.raw[:want]
+
+	n, err := io.ReadFull(w.r, raw)
+	n -= n % w.blockAlign
+	if w.remaining >= 0 {
+		w.remaining -= int64(n)
+	}
+	if n == 0 {
+		if err == nil || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return 0, err
+	}
+
+	samples := n / (w.bitsPerSample / 8)
+	w.decode(raw[:n], buf[:samples])
+	return samples, nil
+}
+
+// decode converts raw little-endian samples to 16 bits
+func (w *WavReader) decode(raw []byte, out []int16) {
+	switch {
+	case w.float && w.bitsPerSample == 32:
+		for i := range out {
+			v := math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+			out[i] = floatToInt16(float64(v))
+		}
+	case w.float:
+		for i := range out {
+			v := math.Float64frombits(binary.LittleEndian.Uint64(raw[i*8:]))
+			out[i] = floatToInt16(v)
+		}
+	case w.bitsPerSample == 8:
+		// 8-bit WAV samples are unsigned
+		for i := range out {
+			out[i] = int16(int(raw[i])-128) << 8
+		}
+	case w.bitsPerSample == 16:
+		for i := range out {
+			out[i] = int16(binary.LittleEndian.Uint16(raw[i*2:]))
+		}
+	case w.bitsPerSample == 24:
+		for i := range out {
+			// Keep the two most significant bytes
+			out[i] = int16(uint16(raw[i*3+1]) | uint16(raw[i*3+2])<<8)
+		}
+	case w.bitsPerSample == 32:
+		for i := range out {
+			out[i] = int16(binary.LittleEndian.Uint32(raw[i*4:]) >> 16)
+		}
+	}
+}
+
+// floatToInt16 scales a float sample in [-1, 1] to 16 bits, saturating out-of-range and NaN values
+func floatToInt16(v float64) int16 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return clampInt16(v * math.MaxInt16)
+}
+
+// DecodeWav reads a WAV stream and returns its audio as 16-bit PCM bytes in the recognizer format
+func DecodeWav(r io.Reader) ([]byte, error) {
+	reader, err := NewWavReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeToRecognizerFormat(reader)
+}
+
+// decodeToRecognizerFormat reads all samples and converts them to recognizer-format PCM bytes
+func decodeToRecognizerFormat(reader SampleReader) ([]byte, error) {
+	var converter *Converter
+	if reader.Format() != RecognizerFormat {
+		var err error
+		if converter, err = NewConverter(reader.Format(), RecognizerFormat); err != nil {
+			return nil, err
+		}
+	}
+
+	var out []byte
+	buf := make([]int16, 4096*reader.Format().Channels)
+	for {
+		n, err := reader.ReadSamples(buf)
+		if n > 0 {
+			samples := buf[:n]
+			if converter != nil {
+				samples = converter.Process(samples)
+			}
+			out = AppendPCM16(out, samples)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audio data: %v", err)
+		}
+	}
+	if converter != nil {
+		out = AppendPCM16(out, converter.Flush())
+	}
+	return out, nil
+}
+
+// LoadWav reads a WAV file and returns its audio as 16-bit PCM bytes in the recognizer format
+func LoadWav(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAV file: %v", err)
+	}
+	defer file.Close()
+
+	data, err := DecodeWav(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return data, nil
+}
+
+// AppendPCM16 appends samples to buf as little-endian bytes
+func AppendPCM16(buf []byte, samples []int16) []byte {
+	for _, s := range samples {
+		buf = append(buf, byte(s), byte(s>>8))
+	}
+	return buf
+}
@@ -0,0 +1,405 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wavChunk is a RIFF chunk for building test files
+type wavChunk struct {
+	id   string
+	data []byte
+}
+
+// buildWav joins chunks into a RIFF/WAVE file, padding odd-sized chunks
+func buildWav(chunks ...wavChunk) []byte {
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	for _, c := range chunks {
+		body.WriteString(c.id)
+		binary.Write(&body, binary.LittleEndian, uint32(len(c.data)))
+		body.Write(c.data)
+		if len(c.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// fmtChunk returns a 16-byte fmt chunk
+func fmtChunk(tag uint16, channels, rate, bits, blockAlign int) wavChunk {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint16(data[0:], tag)
+	binary.LittleEndian.PutUint16(data[2:], uint16(channels))
+	binary.LittleEndian.PutUint32(data[4:], uint32(rate))
+	binary.LittleEndian.PutUint32(data[8:], uint32(rate*blockAlign))
+	binary.LittleEndian.PutUint16(data[12:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(data[14:], uint16(bits))
+	return wavChunk{"fmt ", data}
+}
+
+// pcmFmt returns the fmt chunk of integer PCM with a matching block align
+func pcmFmt(channels, rate, bits int) wavChunk {
+	return fmtChunk(wavFormatPCM, channels, rate, bits, channels*bits/8)
+}
+
+// extensibleFmt returns a 40-byte WAVE_FORMAT_EXTENSIBLE fmt chunk whose sub-format is subTag
+func extensibleFmt(subTag uint16, channels, rate, bits int) wavChunk {
+	c := fmtChunk(wavFormatExtensible, channels, rate, bits, channels*bits/8)
+	ext := make([]byte, 24)
+	binary.LittleEndian.PutUint16(ext[0:], 22) // Extension size
+	binary.LittleEndian.PutUint16(ext[2:], uint16(bits))
+	binary.LittleEndian.PutUint32(ext[4:], 0x3) // Front left and right
+	binary.LittleEndian.PutUint16(ext[8:], subTag)
+	copy(ext[10:], "\x00\x00\x00\x00\x10\x00\x80\x00\x00\xaa\x00\x38\x9b\x71")
+	c.data = append(c.data, ext...)
+	return c
+}
+
+// le returns values as little-endian bytes of the given width
+func le(width int, values ...uint64) []byte {
+	var out []byte
+	for _, v := range values {
+		for i := 0; i < width; i++ {
+			out = append(out, byte(v>>(8*i)))
+		}
+	}
+	return out
+}
+
+// floats returns values as little-endian IEEE floats of the given width
+func floats(width int, values ...float64) []byte {
+	var out []byte
+	for _, v := range values {
+		if width == 4 {
+			out = append(out, le(4, uint64(math.Float32bits(float32(v))))...)
+		} else {
+			out = append(out, le(8, math.Float64bits(v))...)
+		}
+	}
+	return out
+}
+
+// readAllSamples reads a WAV reader to the end in small buffers
+func readAllSamples(t *testing.T, r *WavReader) []int16 {
+	t.Helper()
+	var out []int16
+	buf := make([]int16, 3*r.Format().Channels)
+	for {
+		n, err := r.ReadSamples(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("ReadSamples: %v", err)
+		}
+	}
+}
+
+func TestWavReaderFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   []byte
+		format Format
+		bits   int
+		want   []int16
+	}{
+		{
+			name:   "8-bit unsigned",
+			file:   buildWav(pcmFmt(1, 8000, 8), wavChunk{"data", []byte{0, 128, 255, 129}}),
+			format: Format{SampleRate: 8000, Channels: 1},
+			bits:   8,
+			want:   []int16{-32768, 0, 32512, 256},
+		},
+		{
+			name:   "16-bit",
+			file:   buildWav(pcmFmt(1, 16000, 16), wavChunk{"data", le(2, 0, 0x7fff, 0x8000, 0xffff, 1)}),
+			format: Format{SampleRate: 16000, Channels: 1},
+			bits:   16,
+			want:   []int16{0, 32767, -32768, -1, 1},
+		},
+		{
+			name:   "24-bit",
+			file:   buildWav(pcmFmt(1, 48000, 24), wavChunk{"data", le(3, 0x7fffff, 0x800000, 0x000100, 0xffffff, 0x0000ff)}),
+			format: Format{SampleRate: 48000, Channels: 1},
+			bits:   24,
+			want:   []int16{32767, -32768, 1, -1, 0},
+		},
+		{
+			name:   "32-bit integer",
+			file:   buildWav(pcmFmt(1, 44100, 32), wavChunk{"data", le(4, 0x7fffffff, 0x80000000, 0x00010000, 0xffffffff)}),
+			format: Format{SampleRate: 44100, Channels: 1},
+			bits:   32,
+			want:   []int16{32767, -32768, 1, -1},
+		},
+		{
+			name:   "32-bit float",
+			file:   buildWav(fmtChunk(wavFormatFloat, 1, 16000, 32, 4), wavChunk{"data", floats(4, 0, 1, -1, 0.5, 2, -2, math.NaN())}),
+			format: Format{SampleRate: 16000, Channels: 1},
+			bits:   32,
+			want:   []int16{0, 32767, -32767, 16384, 32767, -32768, 0},
+		},
+		{
+			name:   "64-bit float",
+			file:   buildWav(fmtChunk(wavFormatFloat, 1, 16000, 64, 8), wavChunk{"data", floats(8, 0.25, -0.25)}),
+			format: Format{SampleRate: 16000, Channels: 1},
+			bits:   64,
+			want:   []int16{8192, -8192},
+		},
+		{
+			name:   "extensible 16-bit stereo",
+			file:   buildWav(extensibleFmt(wavFormatPCM, 2, 16000, 16), wavChunk{"data", le(2, 1, 2, 3, 4)}),
+			format: Format{SampleRate: 16000, Channels: 2},
+			bits:   16,
+			want:   []int16{1, 2, 3, 4},
+		},
+		{
+			name:   "extensible 32-bit float",
+			file:   buildWav(extensibleFmt(wavFormatFloat, 2, 16000, 32), wavChunk{"data", floats(4, 1, -1)}),
+			format: Format{SampleRate: 16000, Channels: 2},
+			bits:   32,
+			want:   []int16{32767, -32767},
+		},
+		{
+			name: "LIST and fact chunks skipped",
+			file: buildWav(
+				wavChunk{"LIST", []byte("INFOISFT\x05\x00\x00\x00test\x00\x00")},
+				pcmFmt(1, 16000, 16),
+				wavChunk{"fact", le(4, 3)},
+				wavChunk{"data", le(2, 7, 8, 9)},
+			),
+			format: Format{SampleRate: 16000, Channels: 1},
+			bits:   16,
+			want:   []int16{7, 8, 9},
+		},
+		{
+			name: "odd-sized chunks padded",
+			file: buildWav(
+				wavChunk{"junk", []byte{1, 2, 3}},
+				pcmFmt(1, 16000, 16),
+				wavChunk{"LIST", []byte("odd")},
+				wavChunk{"data", le(2, 5, 6)},
+			),
+			format: Format{SampleRate: 16000, Channels: 1},
+			bits:   16,
+			want:   []int16{5, 6},
+		},
+		{
+			name:   "partial frame at the end dropped",
+			file:   buildWav(pcmFmt(2, 16000, 16), wavChunk{"data", append(le(2, 1, 2, 3, 4), 9, 9)}),
+			format: Format{SampleRate: 16000, Channels: 2},
+			bits:   16,
+			want:   []int16{1, 2, 3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewWavReader(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatalf("NewWavReader: %v", err)
+			}
+			if r.Format() != tt.format {
+				t.Errorf("format = %v, want %v", r.Format(), tt.format)
+			}
+			if r.BitsPerSample() != tt.bits {
+				t.Errorf("bits per sample = %d, want %d", r.BitsPerSample(), tt.bits)
+			}
+			if got := readAllSamples(t, r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("samples = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWavReaderUnknownDataSize(t *testing.T) {
+	// Streaming writers leave the data size at 0; the samples run to the end of the file
+	file := buildWav(pcmFmt(1, 16000, 16), wavChunk{"data", nil})
+	file = append(file, le(2, 1, 2, 3)...)
+	r, err := NewWavReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewWavReader: %v", err)
+	}
+	if got, want := readAllSamples(t, r), []int16{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeWavDownmix(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels int
+		samples  []uint64
+		want     []int16
+	}{
+		{"stereo", 2, []uint64{100, 200, 0xff9c /* -100 */, 0xfed4 /* -300 */}, []int16{150, -200}},
+		{"four channels", 4, []uint64{4, 8, 12, 16, 0x8000, 0x8000, 0x8000, 0x8000}, []int16{10, -32768}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := buildWav(pcmFmt(tt.channels, RecognizerFormat.SampleRate, 16), wavChunk{"data", le(2, tt.samples...)})
+			data, err := DecodeWav(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("DecodeWav: %v", err)
+			}
+			if got := AppendPCM16(nil, tt.want); !bytes.Equal(data, got) {
+				t.Errorf("decoded %v, want %v", data, got)
+			}
+		})
+	}
+}
+
+func TestWavReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want string
+	}{
+		{
+			name: "missing fmt chunk",
+			file: buildWav(wavChunk{"LIST", []byte("INFO")}),
+			want: "missing fmt chunk",
+		},
+		{
+			name: "missing data chunk",
+			file: buildWav(pcmFmt(1, 16000, 16)),
+			want: "missing data chunk",
+		},
+		{
+			name: "zero block align",
+			file: buildWav(fmtChunk(wavFormatPCM, 1, 16000, 16, 0), wavChunk{"data", le(2, 1)}),
+			want: "block align 0 does not match 1 channels of 16 bits",
+		},
+		{
+			name: "data chunk before fmt",
+			file: buildWav(wavChunk{"data", le(2, 1)}, pcmFmt(1, 16000, 16)),
+			want: "data chunk before fmt chunk",
+		},
+		{
+			name: "not RIFF",
+			file: []byte("RIFX\x00\x00\x00\x00WAVE"),
+			want: "not a RIFF/WAVE file",
+		},
+		{
+			name: "truncated RIFF header",
+			file: []byte("RIFF\x00\x00"),
+			want: "missing RIFF header",
+		},
+		{
+			name: "short fmt chunk",
+			file: buildWav(wavChunk{"fmt ", make([]byte, 14)}),
+			want: "fmt chunk too short (14 bytes)",
+		},
+		{
+			name: "truncated fmt chunk",
+			file: buildWav(pcmFmt(1, 16000, 16))[:30],
+			want: "truncated fmt chunk",
+		},
+		{
+			name: "duplicate fmt chunk",
+			file: buildWav(pcmFmt(1, 16000, 16), pcmFmt(1, 16000, 16)),
+			want: "duplicate fmt chunk",
+		},
+		{
+			name: "unsupported bits",
+			file: buildWav(pcmFmt(1, 16000, 12), wavChunk{"data", nil}),
+			want: "12 bits per sample",
+		},
+		{
+			name: "unsupported format tag",
+			file: buildWav(fmtChunk(0x0055, 1, 16000, 16, 2), wavChunk{"data", nil}),
+			want: "format tag 0x0055",
+		},
+		{
+			name: "short extensible fmt chunk",
+			file: buildWav(fmtChunk(wavFormatExtensible, 1, 16000, 16, 2), wavChunk{"data", nil}),
+			want: "extensible fmt chunk too short (16 bytes)",
+		},
+		{
+			name: "no channels",
+			file: buildWav(fmtChunk(wavFormatPCM, 0, 16000, 16, 0), wavChunk{"data", nil}),
+			want: "0 channels",
+		},
+		{
+			name: "zero sample rate",
+			file: buildWav(pcmFmt(1, 0, 16), wavChunk{"data", nil}),
+			want: "sample rate 0 Hz",
+		},
+		{
+			name: "truncated LIST chunk",
+			file: buildWav(pcmFmt(1, 16000, 16), wavChunk{"LIST", make([]byte, 10)})[:50],
+			want: `truncated "LIST" chunk`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWavReader(bytes.NewReader(tt.file))
+			if err == nil {
+				t.Fatalf("NewWavReader succeeded, want error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzNewWavReader(f *testing.F) {
+	valid := [][]byte{
+		buildWav(pcmFmt(1, 16000, 16), wavChunk{"data", le(2, 1, 2, 3, 4)}),
+		buildWav(pcmFmt(2, 44100, 24), wavChunk{"data", le(3, 1, 2, 3, 4)}),
+		buildWav(fmtChunk(wavFormatFloat, 1, 16000, 32, 4), wavChunk{"data", floats(4, 0.5, -0.5)}),
+		buildWav(extensibleFmt(wavFormatPCM, 2, 48000, 16), wavChunk{"LIST", []byte("odd")}, wavChunk{"data", le(2, 1, 2)}),
+		buildWav(pcmFmt(1, 8000, 8), wavChunk{"fact", le(4, 2)}, wavChunk{"data", []byte{0, 255}}),
+	}
+	for _, file := range valid {
+		f.Add(file)
+		// Truncated inside the header, the fmt chunk and the data
+		for _, n := range []int{4, 12, 20, 36, 44, len(file) - 1} {
+			if n < len(file) {
+				f.Add(file[:n])
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := NewWavReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		format := r.Format()
+		if format.Channels < 1 || format.Channels > maxWavChannels {
+			t.Fatalf("accepted %d channels", format.Channels)
+		}
+		if format.SampleRate < 1 || format.SampleRate > maxWavSampleRate {
+			t.Fatalf("accepted sample rate %d", format.SampleRate)
+		}
+		buf := make([]int16, 64*format.Channels)
+		total := 0
+		for {
+			n, err := r.ReadSamples(buf)
+			if n%format.Channels != 0 {
+				t.Fatalf("read %d samples, not whole frames of %d channels", n, format.Channels)
+			}
+			total += n
+			if total > len(data) {
+				t.Fatalf("read %d samples from %d bytes", total, len(data))
+			}
+			if err != nil {
+				return
+			}
+			if n == 0 {
+				t.Fatal("ReadSamples returned no samples and no error")
+			}
+		}
+	})
+}
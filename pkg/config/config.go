@@ -2,31 +2,110 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Constants for audio configuration
+// Constants for the audio format the recognizers expect; capture settings live in AppConfig
 const (
 	SampleRate      = 16000
 	FramesPerBuffer = 1024
 	Channels        = 1
 )
 
+// DefaultMaxRecording is the longest session kept in memory unless configured otherwise
+const DefaultMaxRecording = 30 * time.Minute
+
 // AppConfig holds the application-wide configuration
 type AppConfig struct {
-	ModelPath      string
-	LogFilePath    string
+	ModelPath       string
+	WhisperBin      string
+	LogFilePath     string
+	Language        string
+	Backends        string
+	VoskWorker      bool
+	Input           string
+	InputDevice     string
+	CaptureRate     int
+	CaptureChannels int
+	FramesPerBuffer int
+	RecordTo        string
+	AutoStopSilence time.Duration
+	Preroll         time.Duration
+	DSP             string
+	Archive         bool
+	ArchiveDir      string
+	ArchiveMaxAge   time.Duration
+	ArchiveMaxCount int
+	ArchiveMaxSize  int64
+	ArchiveFormat   string
+	Journal         bool
+	JournalDir      string
+	Recover         bool
+	MaxRecording    time.Duration
+	SegmentMin      time.Duration
+	SegmentMax      time.Duration
+	SegmentPadding  time.Duration
+	OverflowPolicy  OverflowPolicy
+	ListDevices     bool
+	Headless        bool
 }
 
 // NewConfig creates and initializes a new configuration
 func NewConfig() *AppConfig {
 	cfg := &AppConfig{
-		LogFilePath:    "speech-reco.log",
+		LogFilePath: "speech-reco.log",
 	}
 
 	// Parse command line flags
-	flag.StringVar(&cfg.ModelPath, "model", "models/ggml-base.en.bin", "Path to Whisper model file")
+	flag.StringVar(&cfg.ModelPath, "model", "models/ggml-base.en.bin", "Path to the whisper.cpp GGML model used by the whisper backend")
+	flag.StringVar(&cfg.WhisperBin, "whisper-bin", "", "whisper.cpp binary, such as whisper-cli or main from a whisper.cpp build (default: whisper-cli, whisper-cpp or main in PATH)")
+	flag.StringVar(&cfg.Language, "lang", "", "Recognition language code, e.g. en or de; the whisper backend accepts any language whisper.cpp knows")
+	flag.StringVar(&cfg.Backends, "backends", "vosk,system", "Transcription backends to try, in order: vosk, whisper, system")
+	flag.BoolVar(&cfg.VoskWorker, "vosk-worker", true, "Keep one Vosk process with the model loaded instead of starting one per transcription")
+	flag.StringVar(&cfg.Input, "input", "mic", "Audio source: mic, wav:<file>, flac:<file>, pcm:<file|->, tone:<hz>[:<secs>] or noise[:<secs>]")
+	flag.StringVar(&cfg.InputDevice, "device", "", "Input device index or name (see -list-devices), default device if empty or unavailable")
+	flag.IntVar(&cfg.CaptureRate, "capture-rate", SampleRate, "Microphone sample rate in Hz, 0 for the device default; audio is resampled for recognition")
+	flag.IntVar(&cfg.CaptureChannels, "capture-channels", Channels, "Microphone channel count; audio is downmixed to mono for recognition")
+	flag.IntVar(&cfg.FramesPerBuffer, "frames-per-buffer", FramesPerBuffer, "Frames per capture buffer")
+	flag.StringVar(&cfg.RecordTo, "record-to", "", "Stream recordings to this WAV or .flac file (or a timestamped WAV file in this directory) instead of memory")
+	flag.DurationVar(&cfg.AutoStopSilence, "auto-stop", 0, "Stop recording after this much silence following speech, e.g. 1500ms (0 disables)")
+	flag.DurationVar(&cfg.Preroll, "preroll", 0, "Keep the microphone open and include this much audio from before recording starts, e.g. 500ms (0 disables)")
+	flag.StringVar(&cfg.DSP, "dsp", "", "Clean up audio before transcription with these stages, e.g. dc,highpass=80,denoise,gate=-45,normalize=-1")
+	flag.DurationVar(&cfg.MaxRecording, "max-duration", DefaultMaxRecording, "Maximum amount of audio kept in memory per session")
+	flag.DurationVar(&cfg.SegmentMax, "segment-max", 30*time.Second, "Transcribe longer recordings in utterances of at most this length, split at pauses (0 transcribes in one piece)")
+	flag.DurationVar(&cfg.SegmentMin, "segment-min", 3*time.Second, "Do not split utterances shorter than this at pauses")
+	flag.DurationVar(&cfg.SegmentPadding, "segment-padding", 250*time.Millisecond, "Audio from across a split added to both ends of each utterance")
+	overflow := flag.String("overflow", string(OverflowStop), "What to do when -max-duration is reached: stop, drop-oldest or spill (to disk)")
+	flag.BoolVar(&cfg.Archive, "archive", false, "Keep each session's audio, transcript and metadata in the archive")
+	flag.StringVar(&cfg.ArchiveDir, "archive-dir", "", "Archive directory (default $XDG_DATA_HOME/autospeech/recordings)")
+	flag.DurationVar(&cfg.ArchiveMaxAge, "archive-max-age", 0, "Remove archived sessions older than this, e.g. 720h (0 keeps all)")
+	flag.IntVar(&cfg.ArchiveMaxCount, "archive-max-count", 0, "Keep at most this many archived sessions (0 keeps all)")
+	flag.StringVar(&cfg.ArchiveFormat, "archive-format", "flac", "Audio format of archived sessions: flac or wav")
+	archiveMaxSize := flag.String("archive-max-size", "0", "Keep the archive below this size, e.g. 500M or 2G (0 for no limit)")
+	flag.BoolVar(&cfg.Journal, "journal", true, "Journal audio while recording so a dictation survives a crash")
+	flag.StringVar(&cfg.JournalDir, "journal-dir", "", "Journal directory (default $XDG_STATE_HOME/autospeech/journal)")
+	flag.BoolVar(&cfg.Recover, "recover", false, "Transcribe dictations left unfinished by a crash, print them and exit")
+	flag.BoolVar(&cfg.ListDevices, "list-devices", false, "List audio input devices and exit")
+	flag.BoolVar(&cfg.Headless, "headless", false, "Record one session from -input without the tray, print the transcript and exit")
 	flag.Parse()
+	// Backends check the language they are given
+	cfg.Language = strings.ToLower(strings.TrimSpace(cfg.Language))
+
+	policy, err := ParseOverflowPolicy(*overflow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg.OverflowPolicy = policy
+
+	if cfg.ArchiveMaxSize, err = ParseSize(*archiveMaxSize); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	// Validate model path
 	if _, err := os.Stat(cfg.ModelPath); os.IsNotExist(err) {
@@ -35,4 +114,28 @@ func NewConfig() *AppConfig {
 	}
 
 	return cfg
-}
\ No newline at end of file
+}
+
+// ParseSize parses a size such as 500M, 2G or 1024 (bytes); K, M and G are powers of 1024
+func ParseSize(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use bytes or a K, M or G suffix)", value)
+	}
+	return n * multiplier, nil
+}
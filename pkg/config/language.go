@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Language describes a recognition language and the Vosk models that serve it
+type Language struct {
+	Code       string
+	Name       string
+	VoskModels []string // Model directories under ~/vosk-models, preferred first
+}
+
+// Languages lists the languages the setup scripts can install models for
+var Languages = []Language{
+	{Code: "en", Name: "English", VoskModels: []string{"vosk-model-en-us-0.22", "vosk-model-small-en-us-0.15"}},
+	{Code: "de", Name: "Deutsch", VoskModels: []string{"vosk-model-de-0.21", "vosk-model-small-de-0.15"}},
+}
+
+// LookupLanguage finds a supported language by its code
+func LookupLanguage(code string) (Language, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	for _, lang := range Languages {
+		if lang.Code == code {
+			return lang, true
+		}
+	}
+	return Language{}, false
+}
+
+// VoskModelPath returns the first installed Vosk model for the language
+func (l Language) VoskModelPath() (string, bool) {
+	modelsDir := filepath.Join(os.Getenv("HOME"), "vosk-models")
+	for _, name := range l.VoskModels {
+		path := filepath.Join(modelsDir, name)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
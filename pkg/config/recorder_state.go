@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// RecorderState is a stage of a recording session
+type RecorderState int
+
+const (
+	// StateIdle means no session is active
+	StateIdle RecorderState = iota
+	// StateStarting means the audio source is being opened
+	StateStarting
+	// StateRecording means audio is being captured
+	StateRecording
+	// StatePaused means the stream stays open but captured audio is discarded
+	StatePaused
+	// StateRecovering means the audio device failed and is being reopened
+	StateRecovering
+	// StateStopping means capture is ending and the recording is being finished
+	StateStopping
+	// StateProcessing means the recording, or a dictation recovered from the journal, is being transcribed
+	StateProcessing
+	// StateError means the last session failed; a new one can be started
+	StateError
+)
+
+// String returns the state name
+func (s RecorderState) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateStarting:
+		return "starting"
+	case StateRecording:
+		return "recording"
+	case StatePaused:
+		return "paused"
+	case StateRecovering:
+		return "recovering"
+	case StateStopping:
+		return "stopping"
+	case StateProcessing:
+		return "processing"
+	case StateError:
+		return "error"
+	}
+	return fmt.Sprintf("RecorderState(%d)", int(s))
+}
+
+// validTransitions lists the states each state may move to
+var validTransitions = map[RecorderState][]RecorderState{
+	StateIdle:       {StateStarting, StateProcessing}, // Processing directly only to recover dictations
+	StateStarting:   {StateRecording, StateStopping, StateError},
+	StateRecording:  {StatePaused, StateRecovering, StateStopping, StateError},
+	StatePaused:     {StateRecording, StateRecovering, StateStopping, StateError},
+	StateRecovering: {StateRecording, StatePaused, StateStopping, StateError},
+	StateStopping:   {StateProcessing, StateIdle, StateError},
+	StateProcessing: {StateIdle, StateError},
+	StateError:      {StateStarting, StateIdle},
+}
+
+// CanTransition reports whether a session may move from one state to another
+func CanTransition(from, to RecorderState) bool {
+	for _, next := range validTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateEvent reports a state change
+type StateEvent struct {
+	From RecorderState
+	To   RecorderState
+	Err  error // Cause of the failure, set for StateError and StateRecovering and when a device loss ends the recording
+	Time time.Time
+}
+
+// State returns the current session state
+func (s *AppState) State() RecorderState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// Transition moves the session to a new state and notifies subscribers.
+// It returns an error and leaves the state unchanged if the move is not allowed.
+func (s *AppState) Transition(to RecorderState) error {
+	return s.transition(to, nil)
+}
+
+// TransitionFrom moves the session to a new state only if it is currently in from
+func (s *AppState) TransitionFrom(from, to RecorderState) error {
+	return s.transitionFrom(&from, to, nil)
+}
+
+// TransitionWithCause moves the session to a new state because of an error,
+// such as StateRecovering after a device failure
+func (s *AppState) TransitionWithCause(to RecorderState, cause error) error {
+	return s.transition(to, cause)
+}
+
+// Fail moves the session to StateError with the given cause
+func (s *AppState) Fail(err error) error {
+	return s.transition(StateError, err)
+}
+
+// SubscribeState returns a channel receiving every state change, and a function
+// that unsubscribes and closes the channel
+func (s *AppState) SubscribeState() (<-chan StateEvent, func()) {
+	ch := make(chan StateEvent, 32)
+	s.mu.Lock()
+	s.stateSubs = append(s.stateSubs, ch)
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, sub := range s.stateSubs {
+			if sub == ch {
+				s.stateSubs = append(s.stateSubs[:i], s.stateSubs[i+1:]...)
+				close(ch)
+				return
+			}
+		}
+	}
+}
+
+// transition validates and applies a state change
+func (s *AppState) transition(to RecorderState, err error) error {
+	return s.transitionFrom(nil, to, err)
+}
+
+// transitionFrom validates and applies a state change, optionally requiring a current state
+func (s *AppState) transitionFrom(required *RecorderState, to RecorderState, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := s.state
+	if required != nil && from != *required {
+		return fmt.Errorf("cannot move to %v while %v", to, from)
+	}
+	if !CanTransition(from, to) {
+		return fmt.Errorf("invalid state transition from %v to %v", from, to)
+	}
+	s.state = to
+	s.stateErr = err
+
+	if err != nil {
+		log.Printf("State: %v -> %v: %v", from, to, err)
+	} else {
+		log.Printf("State: %v -> %v", from, to)
+	}
+	// Events are sent under the lock so every subscriber sees them in order
+	event := StateEvent{From: from, To: to, Err: err, Time: time.Now()}
+	for _, ch := range s.stateSubs {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("State subscriber is not keeping up, dropped %v -> %v", from, to)
+		}
+	}
+	return nil
+}
+
+// StateError returns the cause given with the last transition, if any
+func (s *AppState) StateError() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stateErr
+}
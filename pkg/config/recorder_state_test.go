@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var allStates = []RecorderState{
+	StateIdle, StateStarting, StateRecording, StatePaused,
+	StateRecovering, StateStopping, StateProcessing, StateError,
+}
+
+// allowedTransitions is the state machine written out independently of validTransitions
+var allowedTransitions = map[string]bool{
+	"idle -> starting":        true,
+	"idle -> processing":      true, // Transcribing a dictation recovered from the journal
+	"starting -> recording":   true,
+	"starting -> stopping":    true,
+	"starting -> error":       true,
+	"recording -> paused":     true,
+	"recording -> recovering": true,
+	"recording -> stopping":   true,
+	"recording -> error":      true,
+	"paused -> recording":     true,
+	"paused -> recovering":    true,
+	"paused -> stopping":      true,
+	"paused -> error":         true,
+	"recovering -> recording": true,
+	"recovering -> paused":    true,
+	"recovering -> stopping":  true,
+	"recovering -> error":     true,
+	"stopping -> processing":  true,
+	"stopping -> idle":        true,
+	"stopping -> error":       true,
+	"processing -> idle":      true,
+	"processing -> error":     true,
+	"error -> starting":       true, // A new session after a failure
+	"error -> idle":           true,
+}
+
+// pathTo lists the transitions from StateIdle to each state
+var pathTo = map[RecorderState][]RecorderState{
+	StateIdle:       nil,
+	StateStarting:   {StateStarting},
+	StateRecording:  {StateStarting, StateRecording},
+	StatePaused:     {StateStarting, StateRecording, StatePaused},
+	StateRecovering: {StateStarting, StateRecording, StateRecovering},
+	StateStopping:   {StateStarting, StateRecording, StateStopping},
+	StateProcessing: {StateStarting, StateRecording, StateStopping, StateProcessing},
+	StateError:      {StateStarting, StateError},
+}
+
+// stateAt returns a new AppState moved to state
+func stateAt(t *testing.T, state RecorderState) *AppState {
+	t.Helper()
+	s := NewAppState(&AppConfig{})
+	for _, next := range pathTo[state] {
+		if err := s.Transition(next); err != nil {
+			t.Fatalf("moving to %v: %v", state, err)
+		}
+	}
+	return s
+}
+
+func TestTransitions(t *testing.T) {
+	for _, from := range allStates {
+		for _, to := range allStates {
+			name := fmt.Sprintf("%v -> %v", from, to)
+			t.Run(name, func(t *testing.T) {
+				want := allowedTransitions[name]
+				if got := CanTransition(from, to); got != want {
+					t.Fatalf("CanTransition = %v, want %v", got, want)
+				}
+
+				s := stateAt(t, from)
+				events, unsubscribe := s.SubscribeState()
+				defer unsubscribe()
+				err := s.Transition(to)
+				if want {
+					if err != nil || s.State() != to {
+						t.Errorf("allowed transition failed: %v, now %v", err, s.State())
+					}
+					if ev := <-events; ev.From != from || ev.To != to {
+						t.Errorf("event %v -> %v, want %s", ev.From, ev.To, name)
+					}
+					return
+				}
+				if err == nil || !strings.Contains(err.Error(), "invalid state transition") {
+					t.Errorf("error = %v, want an invalid transition", err)
+				}
+				if s.State() != from {
+					t.Errorf("rejected transition left the state at %v", s.State())
+				}
+				select {
+				case ev := <-events:
+					t.Errorf("rejected transition sent %v -> %v", ev.From, ev.To)
+				default:
+				}
+			})
+		}
+	}
+}
+
+func TestTransitionCause(t *testing.T) {
+	s := stateAt(t, StateRecording)
+	lost := errors.New("device lost")
+	if err := s.TransitionWithCause(StateRecovering, lost); err != nil || s.StateError() != lost {
+		t.Fatalf("recovering: err %v, cause %v", err, s.StateError())
+	}
+	if err := s.Transition(StateRecording); err != nil || s.StateError() != nil {
+		t.Errorf("recovered: err %v, cause %v, want the cause cleared", err, s.StateError())
+	}
+
+	failed := errors.New("no audio")
+	if err := s.Fail(failed); err != nil || s.State() != StateError || s.StateError() != failed {
+		t.Errorf("Fail: err %v, state %v, cause %v", err, s.State(), s.StateError())
+	}
+	// After a failure a new session starts without the old cause
+	if err := s.Transition(StateStarting); err != nil || s.StateError() != nil {
+		t.Errorf("restart after error: err %v, cause %v", err, s.StateError())
+	}
+}
+
+func TestTransitionFrom(t *testing.T) {
+	s := stateAt(t, StateRecording)
+	// The session moved on before the request was handled
+	if err := s.TransitionFrom(StatePaused, StateRecording); err == nil || s.State() != StateRecording {
+		t.Errorf("transition from the wrong state: err %v, now %v", err, s.State())
+	}
+	if err := s.TransitionFrom(StateRecording, StatePaused); err != nil || s.State() != StatePaused {
+		t.Errorf("transition from the current state: err %v, now %v", err, s.State())
+	}
+	// The required state must still allow the move
+	if err := s.TransitionFrom(StatePaused, StateProcessing); err == nil || s.State() != StatePaused {
+		t.Errorf("invalid transition from the current state: err %v, now %v", err, s.State())
+	}
+}
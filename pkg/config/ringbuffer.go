@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// OverflowPolicy decides what happens when the audio buffer reaches its maximum size
+type OverflowPolicy string
+
+const (
+	// OverflowStop keeps the audio recorded so far and rejects the rest
+	OverflowStop OverflowPolicy = "stop"
+	// OverflowDropOldest discards the oldest audio to make room
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowSpill moves the oldest audio to a temporary file on disk
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// ParseOverflowPolicy validates a policy name from the command line
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(name); policy {
+	case OverflowStop, OverflowDropOldest, OverflowSpill:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q (use stop, drop-oldest or spill)", name)
+}
+
+// ErrAudioBufferFull is returned by writes that exceed the buffer under OverflowStop
+var ErrAudioBufferFull = errors.New("audio buffer full")
+
+// AudioRingBuffer holds recorded audio in a fixed amount of memory.
+// Memory is allocated as audio arrives, up to the capacity, and then reused as a ring.
+// It is not safe for concurrent use; AppState guards it with its mutex.
+type AudioRingBuffer struct {
+	data     []byte
+	start    int // Index of the oldest byte once the ring has wrapped
+	size     int
+	capacity int
+	policy   OverflowPolicy
+	spill    *os.File
+	linked   bool // The spill file still has a name to remove
+	spilled  int64
+	dropped  int64
+}
+
+// NewAudioRingBuffer creates a buffer holding at most capacity bytes
+func NewAudioRingBuffer(capacity int, policy OverflowPolicy) *AudioRingBuffer {
+	// Keep whole 16-bit samples
+	capacity -= capacity % 2
+	if capacity < 2 {
+		capacity = 2
+	}
+	return &AudioRingBuffer{capacity: capacity, policy: policy}
+}
+
+// Write appends audio, applying the overflow policy when the buffer is full.
+// Under OverflowStop it stores what fits and returns ErrAudioBufferFull.
+func (b *AudioRingBuffer) Write(p []byte) error {
+	if overflow := b.size + len(p) - b.capacity; overflow > 0 {
+		overflow += overflow % 2
+		switch b.policy {
+		case OverflowDropOldest:
+			if len(p) > b.capacity {
+				b.dropped += int64(b.size + len(p) - b.capacity)
+				b.discard(b.size)
+				p = p[len(p)-b.capacity:]
+			} else {
+				b.discard(overflow)
+				b.dropped += int64(overflow)
+			}
+		case OverflowSpill:
+			// Spill at least half the buffer so the file is not written for every block
+			amount := overflow
+			if half := b.capacity / 2; amount < half {
+				amount = half
+			}
+			if amount > b.size {
+				amount = b.size
+			}
+			if err := b.spillOldest(amount); err != nil {
+				return err
+			}
+			if len(p) > b.capacity {
+				if err := b.spillBytes(p[:len(p)-b.capacity]); err != nil {
+					return err
+				}
+				p = p[len(p)-b.capacity:]
+			}
+		default:
+			fit := b.capacity - b.size
+			b.append(p[:fit])
+			return ErrAudioBufferFull
+		}
+	}
+	b.append(p)
+	return nil
+}
+
+// Len returns the total amount of audio held, including audio spilled to disk
+func (b *AudioRingBuffer) Len() int64 {
+	return b.spilled + int64(b.size)
+}
+
+// Dropped returns how many bytes were discarded under OverflowDropOldest
+func (b *AudioRingBuffer) Dropped() int64 {
+	return b.dropped
+}
+
+// Reader returns a reader over all buffered audio in recording order. It reads the
+// spill file in place and copies only the in-memory part, so memory stays bounded by
+// the buffer capacity. Later writes are not seen; the reader fails after Reset.
+func (b *AudioRingBuffer) Reader() io.Reader {
+	first, second := b.segments()
+	tail := make([]byte, 0, len(first)+len(second))
+	tail = append(append(tail, first...), second...)
+	if b.spill == nil {
+		return bytes.NewReader(tail)
+	}
+	// ReadAt leaves the file offset used by spill writes alone
+	return io.MultiReader(io.NewSectionReader(b.spill, 0, b.spilled), bytes.NewReader(tail))
+}
+
+// WriteTo writes all buffered audio to w in recording order
+func (b *AudioRingBuffer) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, b.Reader())
+	if err != nil {
+		return n, fmt.Errorf("failed to read buffered audio: %v", err)
+	}
+	return n, nil
+}
+
+// Reset clears the buffer, releases its memory and removes any spill file
+func (b *AudioRingBuffer) Reset() {
+	b.data = nil
+	b.start = 0
+	b.size = 0
+	b.dropped = 0
+	b.spilled = 0
+	if b.spill != nil {
+		b.spill.Close()
+		if b.linked {
+			os.Remove(b.spill.Name())
+		}
+		b.spill = nil
+		b.linked = false
+	}
+}
+
+// append stores p, which must fit in the free space
+func (b *AudioRingBuffer) append(p []byte) {
+	for len(p) > 0 {
+		if len(b.data) < b.capacity && b.start == 0 && b.size == len(b.data) {
+			// Still growing: plain append
+			n := min(len(p), b.capacity-len(b.data))
+			b.data = append(b.data, p[:n]...)
+			b.size += n
+			p = p[n:]
+			continue
+		}
+		if len(b.data) < b.capacity {
+			// Space was freed before the buffer grew to full size; grow it now so
+			// ring positions can wrap at the capacity
+			grown := make([]byte, b.capacity)
+			copy(grown, b.data)
+			b.data = grown
+		}
+		end := (b.start + b.size) % b.capacity
+		n := min(len(p), b.capacity-end)
+		copy(b.data[end:end+n], p[:n])
+		b.size += n
+		p = p[n:]
+	}
+}
+
+// discard drops the n oldest bytes
+func (b *AudioRingBuffer) discard(n int) {
+	if n > b.size {
+		n = b.size
+	}
+	b.size -= n
+	if b.size == 0 {
+		b.start = 0
+		b.data = b.data[:0]
+		return
+	}
+	b.start = (b.start + n) % len(b.data)
+}
+
+// spillOldest moves the n oldest bytes to the spill file
+func (b *AudioRingBuffer) spillOldest(n int) error {
+	first, second := b.segments()
+	if n <= len(first) {
+		if err := b.spillBytes(first[:n]); err != nil {
+			return err
+		}
+	} else {
+		if err := b.spillBytes(first); err != nil {
+			return err
+		}
+		if err := b.spillBytes(second[:n-len(first)]); err != nil {
+			return err
+		}
+	}
+	b.discard(n)
+	return nil
+}
+
+// spillBytes appends p to the spill file, creating it on first use
+func (b *AudioRingBuffer) spillBytes(p []byte) error {
+	if b.spill == nil {
+		file, err := os.CreateTemp("", "speech-reco-spill-*.pcm")
+		if err != nil {
+			return fmt.Errorf("failed to create spill file: %v", err)
+		}
+		log.Printf("Audio buffer full, spilling to %s", file.Name())
+		b.spill = file
+		// Unlink the file while it is open where the system allows it, so the
+		// audio never outlives the process, even after a crash
+		b.linked = os.Remove(file.Name()) != nil
+	}
+	if _, err := b.spill.Write(p); err != nil {
+		return fmt.Errorf("failed to spill audio to disk: %v", err)
+	}
+	b.spilled += int64(len(p))
+	return nil
+}
+
+// segments returns the buffered bytes as up to two slices in recording order
+func (b *AudioRingBuffer) segments() ([]byte, []byte) {
+	if b.size == 0 {
+		return nil, nil
+	}
+	if b.start+b.size <= len(b.data) {
+		return b.data[b.start : b.start+b.size], nil
+	}
+	return b.data[b.start:], b.data[:(b.start+b.size)%len(b.data)]
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// samples returns n 16-bit samples counting up from first, so order and gaps show
+func samples(first, n int) []byte {
+	out := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(first+i))
+	}
+	return out
+}
+
+// readAll returns the buffered audio through Reader and checks WriteTo agrees
+func readAll(t *testing.T, b *AudioRingBuffer) []byte {
+	t.Helper()
+	data, err := io.ReadAll(b.Reader())
+	if err != nil {
+		t.Fatalf("reading buffer: %v", err)
+	}
+	var w bytes.Buffer
+	if n, err := b.WriteTo(&w); err != nil || n != int64(len(data)) || !bytes.Equal(w.Bytes(), data) {
+		t.Errorf("WriteTo wrote %d bytes (err %v), Reader returned %d", n, err, len(data))
+	}
+	return data
+}
+
+func TestAudioRingBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   OverflowPolicy
+		capacity int   // Samples
+		writes   []int // Samples per write
+		want     [2]int
+		dropped  int // Samples
+		full     bool
+	}{
+		{"fits", OverflowStop, 100, []int{30, 30, 40}, [2]int{0, 100}, 0, false},
+		{"stop at capacity", OverflowStop, 100, []int{60, 60, 10}, [2]int{0, 100}, 0, true},
+		{"stop on a single large write", OverflowStop, 100, []int{250}, [2]int{0, 100}, 0, true},
+		{"drop oldest", OverflowDropOldest, 100, []int{60, 60}, [2]int{20, 120}, 20, false},
+		{"drop oldest wraps repeatedly", OverflowDropOldest, 100, []int{33, 33, 33, 33, 33, 33, 33}, [2]int{131, 231}, 131, false},
+		{"drop oldest in small blocks", OverflowDropOldest, 100, repeat(7, 50), [2]int{250, 350}, 250, false},
+		{"drop oldest on a write larger than the buffer", OverflowDropOldest, 100, []int{40, 250}, [2]int{190, 290}, 190, false},
+		{"spill", OverflowSpill, 100, []int{60, 60}, [2]int{0, 120}, 0, false},
+		{"spill repeatedly", OverflowSpill, 100, repeat(37, 20), [2]int{0, 740}, 0, false},
+		{"spill a write larger than the buffer", OverflowSpill, 100, []int{70, 333, 10}, [2]int{0, 413}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+			b := NewAudioRingBuffer(2*tt.capacity, tt.policy)
+			defer b.Reset()
+
+			next, full := 0, false
+			for _, n := range tt.writes {
+				err := b.Write(samples(next, n))
+				next += n
+				if errors.Is(err, ErrAudioBufferFull) {
+					full = true
+				} else if err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if full != tt.full {
+				t.Errorf("buffer full = %v, want %v", full, tt.full)
+			}
+
+			want := samples(tt.want[0], tt.want[1]-tt.want[0])
+			if got := readAll(t, b); !bytes.Equal(got, want) {
+				t.Errorf("buffer holds %d samples starting at %d, want samples %d to %d",
+					len(got)/2, firstSample(got), tt.want[0], tt.want[1])
+			}
+			if b.Len() != int64(len(want)) {
+				t.Errorf("Len = %d, want %d", b.Len(), len(want))
+			}
+			if b.Dropped() != int64(2*tt.dropped) {
+				t.Errorf("Dropped = %d bytes, want %d", b.Dropped(), 2*tt.dropped)
+			}
+			// Memory never grows past the capacity
+			if len(b.data) > 2*tt.capacity || b.size > 2*tt.capacity {
+				t.Errorf("holding %d bytes in %d of memory, capacity %d", b.size, len(b.data), 2*tt.capacity)
+			}
+			if spilled := b.spill != nil; spilled != (tt.policy == OverflowSpill && tt.want[1] > tt.capacity) {
+				t.Errorf("spill file in use = %v", spilled)
+			}
+		})
+	}
+}
+
+func repeat(n, times int) []int {
+	out := make([]int, times)
+	for i := range out {
+		out[i] = n
+	}
+	return out
+}
+
+func firstSample(data []byte) int {
+	if len(data) < 2 {
+		return -1
+	}
+	return int(binary.LittleEndian.Uint16(data))
+}
+
+func TestAudioRingBufferSpillFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	b := NewAudioRingBuffer(200, OverflowSpill)
+	if err := b.Write(samples(0, 250)); err != nil {
+		t.Fatal(err)
+	}
+	// A reader taken now does not see later audio
+	r := b.Reader()
+	if err := b.Write(samples(250, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, samples(0, 250)) {
+		t.Errorf("reader returned %d bytes (err %v), want the 250 samples written before it", len(got), err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.linked {
+		// The system could not unlink the open file; Reset removes it
+		if len(entries) != 1 {
+			t.Fatalf("%d files in TMPDIR, want the spill file", len(entries))
+		}
+	} else if len(entries) != 0 {
+		t.Errorf("spill file %s left in TMPDIR while in use", entries[0].Name())
+	}
+
+	b.Reset()
+	if b.Len() != 0 || b.spill != nil {
+		t.Errorf("Reset left %d bytes and spill file %v", b.Len(), b.spill)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spill file %s left behind after Reset", entries[0].Name())
+	}
+	// The buffer is usable again
+	if err := b.Write(samples(7, 50)); err != nil || !bytes.Equal(readAll(t, b), samples(7, 50)) {
+		t.Errorf("buffer after Reset does not hold the new audio (err %v)", err)
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, name := range []string{"stop", "drop-oldest", "spill"} {
+		if policy, err := ParseOverflowPolicy(name); err != nil || string(policy) != name {
+			t.Errorf("ParseOverflowPolicy(%q) = %q, %v", name, policy, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("wrap"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
-	"bytes"
+	"fmt"
+	"io"
+	"log"
 	"sync"
 	"time"
 )
@@ -9,33 +11,46 @@ import (
 // AppState manages the application state with thread safety
 type AppState struct {
 	mu                  sync.RWMutex
-	isRecording         bool
+	state               RecorderState
+	stateErr            error
+	stateSubs           []chan StateEvent
 	transcribedText     string
-	audioBuffer         bytes.Buffer
+	audioBuffer         *AudioRingBuffer
 	partialTranscription string
 	partialUpdateTime    time.Time
+	recordingFile        string
 	// No longer used for voice commands
 }
 
 // NewAppState creates a new application state
 func NewAppState(cfg *AppConfig) *AppState {
+	maxDuration := DefaultMaxRecording
+	policy := OverflowStop
+	if cfg != nil {
+		if cfg.MaxRecording > 0 {
+			maxDuration = cfg.MaxRecording
+		}
+		if cfg.OverflowPolicy != "" {
+			policy = cfg.OverflowPolicy
+		}
+	}
+	capacity := int(maxDuration.Seconds() * SampleRate * Channels * 2)
+
 	return &AppState{
-		isRecording: false,
+		state:       StateIdle,
+		audioBuffer: NewAudioRingBuffer(capacity, policy),
 	}
 }
 
-// IsRecording returns the current recording state
+// IsRecording reports whether a session is starting, capturing audio, paused or recovering
 func (s *AppState) IsRecording() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.isRecording
-}
-
-// SetRecording sets the recording state
-func (s *AppState) SetRecording(recording bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.isRecording = recording
+	switch s.state {
+	case StateStarting, StateRecording, StatePaused, StateRecovering:
+		return true
+	}
+	return false
 }
 
 // GetTranscribedText returns the transcribed text
@@ -52,18 +67,47 @@ func (s *AppState) SetTranscribedText(text string) {
 	s.transcribedText = text
 }
 
-// GetAudioBuffer returns a copy of the current audio buffer
+// GetAudioBuffer returns a copy of the current audio buffer, including audio spilled
+// to disk. Use AudioBufferReader for recordings that may not fit in memory.
 func (s *AppState) GetAudioBuffer() []byte {
+	data, err := io.ReadAll(s.AudioBufferReader())
+	if err != nil {
+		log.Printf("Failed to read audio buffer: %v", err)
+	}
+	return data
+}
+
+// AudioBufferReader returns a reader over the audio captured so far. Only the in-memory
+// part is copied under the lock; spilled audio is read from disk as the reader is used.
+func (s *AppState) AudioBufferReader() io.Reader {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.audioBuffer.Bytes()
+	return s.audioBuffer.Reader()
+}
+
+// WriteAudioBufferTo writes the audio captured so far to w without holding the state lock
+func (s *AppState) WriteAudioBufferTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, s.AudioBufferReader())
+	if err != nil {
+		return n, fmt.Errorf("failed to read buffered audio: %v", err)
+	}
+	return n, nil
 }
 
-// WriteToAudioBuffer writes data to the audio buffer
-func (s *AppState) WriteToAudioBuffer(data []byte) {
+// AudioBufferLen returns the number of bytes of audio captured in the current session
+func (s *AppState) AudioBufferLen() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.audioBuffer.Len()
+}
+
+// WriteToAudioBuffer writes data to the audio buffer.
+// It returns ErrAudioBufferFull once the maximum recording length is reached
+// under the stop overflow policy.
+func (s *AppState) WriteToAudioBuffer(data []byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.audioBuffer.Write(data)
+	return s.audioBuffer.Write(data)
 }
 
 // ResetAudioBuffer clears the audio buffer
@@ -73,6 +117,20 @@ func (s *AppState) ResetAudioBuffer() {
 	s.audioBuffer.Reset()
 }
 
+// GetRecordingFile returns the WAV file the last session was streamed to, if any
+func (s *AppState) GetRecordingFile() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.recordingFile
+}
+
+// SetRecordingFile sets the WAV file the current session is streamed to
+func (s *AppState) SetRecordingFile(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordingFile = path
+}
+
 // GetPartialTranscription returns the current partial transcription
 func (s *AppState) GetPartialTranscription() string {
 	s.mu.RLock()
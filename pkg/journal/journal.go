@@ -0,0 +1,394 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+)
+
+// File layout: the magic string followed by records of
+//
+//	length  uint32   payload length, little-endian
+//	type    byte
+//	payload [length]byte
+//	crc     uint32   CRC-32C of length, type and payload
+//
+// A record is only trusted if its checksum matches, so a write torn by a crash
+// or power loss is detected and cut off when the journal is read back.
+const (
+	fileMagic     = "ASJRNL01"
+	fileExt       = ".journal"
+	recordHeader  = 5
+	maxRecordSize = 16 << 20
+	syncInterval  = time.Second // How often written records are forced to disk
+)
+
+// Record types
+const (
+	recordMeta    byte = 1 // Session metadata as JSON, always first
+	recordAudio   byte = 2 // Frame sequence number, offset and PCM samples
+	recordPartial byte = 3 // Latest partial transcript
+	recordEnd     byte = 4 // The recording finished; only transcription was pending
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Meta describes the session a journal belongs to
+type Meta struct {
+	Started    time.Time `json:"started"`
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Input      string    `json:"input,omitempty"`
+	Language   string    `json:"language,omitempty"`
+}
+
+// DefaultDir returns the journal location under the XDG state directory,
+// $XDG_STATE_HOME/autospeech/journal or ~/.local/state/autospeech/journal
+func DefaultDir() string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = "."
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateHome, "autospeech", "journal")
+}
+
+// Writer appends a session's audio and partial transcripts to a journal file
+type Writer struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	buf      *bufio.Writer
+	lastSync time.Time
+	err      error // First write error; nothing more is written after it
+}
+
+// ErrLocked is returned for a journal that another running instance is still writing
+var ErrLocked = errors.New("journal is in use by another instance")
+
+// Create starts the journal of a new session in dir. The journal stays locked
+// until it is closed, so other instances leave it alone.
+func Create(dir string, meta Meta) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %v", err)
+	}
+	name := meta.Started.Format("20060102-150405.000000000") + fileExt
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal: %v", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to lock journal: %v", err)
+	}
+
+	w := &Writer{path: path, file: file, buf: bufio.NewWriter(file), lastSync: time.Now()}
+	data, err := json.Marshal(meta)
+	if err == nil {
+		w.buf.WriteString(fileMagic)
+		err = w.write(recordMeta, data)
+	}
+	if err == nil {
+		// The header must be on disk before any audio counts as saved
+		err = w.sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	log.Printf("Journaling session to %s", path)
+	return w, nil
+}
+
+// Path returns the journal file
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// WriteFrame appends a frame of recorded audio
+func (w *Writer) WriteFrame(frame audio.Frame) error {
+	payload := make([]byte, 16, 16+2*len(frame.Samples))
+	binary.LittleEndian.PutUint64(payload[0:8], frame.Seq)
+	binary.LittleEndian.PutUint64(payload[8:16], uint64(frame.Offset))
+	for _, s := range frame.Samples {
+		payload = append(payload, byte(s), byte(s>>8))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(recordAudio, payload)
+}
+
+// WritePartial appends the latest partial transcript
+func (w *Writer) WritePartial(text string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(recordPartial, []byte(text))
+}
+
+// Follow journals every frame of sub until it is unsubscribed
+func (w *Writer) Follow(sub *audio.Subscription) {
+	// Write errors are logged once by the writer; keep draining so a broken
+	// journal never holds up the recorder
+	for frame := range sub.Frames() {
+		if frame.End {
+			w.markEnded()
+		} else {
+			w.WriteFrame(frame)
+		}
+	}
+}
+
+// markEnded records that the recording finished and forces the journal to disk
+func (w *Writer) markEnded() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.write(recordEnd, nil); err != nil {
+		return err
+	}
+	return w.sync()
+}
+
+// Close flushes the journal and closes the file, keeping it for recovery
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.sync()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
+
+// Remove deletes and closes the journal once its session has been delivered. It is
+// deleted while still locked so no other instance can pick it up in between.
+func (w *Writer) Remove() error {
+	err := os.Remove(w.path)
+	w.Close()
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal: %v", err)
+	}
+	return nil
+}
+
+// write appends one record and hands it to the operating system, so an
+// application crash loses nothing; the caller holds w.mu
+func (w *Writer) write(kind byte, payload []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.file == nil {
+		return fmt.Errorf("journal is closed")
+	}
+
+	var header [recordHeader]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	header[4] = kind
+	crc := crc32.Update(crc32.Update(0, crcTable, header[:]), crcTable, payload)
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], crc)
+
+	w.buf.Write(header[:])
+	w.buf.Write(payload)
+	w.buf.Write(trailer[:])
+	if err := w.buf.Flush(); err != nil {
+		return w.fail(err)
+	}
+	// Limit fsyncs; a power loss costs at most the last second
+	if time.Since(w.lastSync) >= syncInterval {
+		return w.sync()
+	}
+	return nil
+}
+
+// sync flushes buffered records and forces them to disk; the caller holds w.mu
+func (w *Writer) sync() error {
+	if err := w.buf.Flush(); err != nil {
+		return w.fail(err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return w.fail(err)
+	}
+	w.lastSync = time.Now()
+	return nil
+}
+
+// fail records the first write error
+func (w *Writer) fail(err error) error {
+	if w.err == nil {
+		w.err = fmt.Errorf("failed to write journal: %v", err)
+		log.Println(w.err)
+	}
+	return w.err
+}
+
+// Session is a dictation read back from a journal
+type Session struct {
+	Path      string
+	Meta      Meta
+	Audio     []byte // PCM in the recognizer format, in recording order
+	Partial   string // Last partial transcript, if any
+	Frames    int
+	Ended     bool // The recording finished before the app stopped
+	Truncated bool // A torn or corrupt tail was cut off
+}
+
+// Duration returns the length of the recovered audio
+func (s *Session) Duration() time.Duration {
+	bytesPerSecond := s.Meta.SampleRate * s.Meta.Channels * 2
+	if bytesPerSecond <= 0 {
+		return 0
+	}
+	return time.Duration(len(s.Audio)) * time.Second / time.Duration(bytesPerSecond)
+}
+
+// Find returns the journals left in dir by sessions that never finished, oldest first.
+// Journals that running instances are still writing are left out.
+func Find(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var unfinished []string
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if err := lockFile(file); !errors.Is(err, ErrLocked) {
+			unfinished = append(unfinished, path)
+		}
+		file.Close()
+	}
+	return unfinished, nil
+}
+
+// Open reads a journal back. A torn or corrupt record ends the journal; it and
+// everything after it are truncated so the file is valid again. It returns ErrLocked
+// if another instance is still writing the journal.
+func Open(path string) (*Session, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %v", err)
+	}
+	defer file.Close()
+	if err := lockFile(file); err != nil {
+		if errors.Is(err, ErrLocked) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return nil, fmt.Errorf("failed to lock journal: %v", err)
+	}
+
+	r := bufio.NewReader(file)
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != fileMagic {
+		return nil, fmt.Errorf("%s is not a journal", path)
+	}
+
+	session := &Session{Path: path}
+	valid := int64(len(fileMagic))
+	haveMeta := false
+	var nextSeq uint64
+	for {
+		kind, payload, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			session.Truncated = true
+			log.Printf("Journal %s: %v, truncating after %d bytes", path, err, valid)
+			if err := os.Truncate(path, valid); err != nil {
+				log.Printf("Failed to truncate journal: %v", err)
+			}
+			break
+		}
+		valid += int64(recordHeader + len(payload) + 4)
+
+		switch kind {
+		case recordMeta:
+			if err := json.Unmarshal(payload, &session.Meta); err != nil {
+				return nil, fmt.Errorf("journal %s: bad metadata: %v", path, err)
+			}
+			haveMeta = true
+		case recordAudio:
+			if len(payload) < 16 {
+				continue
+			}
+			seq := binary.LittleEndian.Uint64(payload[0:8])
+			if seq != nextSeq {
+				log.Printf("Journal %s: frames %d to %d are missing", path, nextSeq, seq-1)
+			}
+			nextSeq = seq + 1
+			session.Audio = append(session.Audio, payload[16:]...)
+			session.Frames++
+		case recordPartial:
+			session.Partial = string(payload)
+		case recordEnd:
+			session.Ended = true
+		}
+	}
+	if !haveMeta {
+		return nil, fmt.Errorf("journal %s has no session metadata", path)
+	}
+	return session, nil
+}
+
+// readRecord reads and verifies one record
+func readRecord(r *bufio.Reader) (byte, []byte, error) {
+	var header [recordHeader]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF && n == 0 {
+		return 0, nil, io.EOF
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("torn record header")
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return 0, nil, fmt.Errorf("bad record length %d", size)
+	}
+	payload := make([]byte, size)
+	var trailer [4]byte
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("torn record")
+	}
+	if _, err := io.ReadFull(r, trailer[:]); err != nil {
+		return 0, nil, fmt.Errorf("torn record")
+	}
+	crc := crc32.Update(crc32.Update(0, crcTable, header[:]), crcTable, payload)
+	if crc != binary.LittleEndian.Uint32(trailer[:]) {
+		return 0, nil, fmt.Errorf("checksum mismatch")
+	}
+	return header[4], payload, nil
+}
+
+// Remove deletes a journal that has been recovered or discarded
+func Remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+)
+
+var testMeta = Meta{
+	Started:    time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC),
+	SampleRate: 16000,
+	Channels:   1,
+	Input:      "test",
+	Language:   "de",
+}
+
+// testFrame returns frame seq of n samples, each holding the sequence number
+func testFrame(seq uint64, n int) audio.Frame {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(seq*1000) + int16(i)
+	}
+	return audio.Frame{Seq: seq, Offset: time.Duration(seq) * 10 * time.Millisecond, Samples: samples}
+}
+
+// writeJournal creates a journal of three frames with partials in between and
+// returns its path and the audio it holds
+func writeJournal(t *testing.T, ended bool) (string, []byte) {
+	t.Helper()
+	w, err := Create(t.TempDir(), testMeta)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	var pcm []byte
+	for seq := uint64(0); seq < 3; seq++ {
+		frame := testFrame(seq, 160)
+		if err := w.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame: %v", err)
+		}
+		pcm = frame.AppendPCM(pcm)
+		if err := w.WritePartial(strings.Repeat("word ", int(seq)+1)); err != nil {
+			t.Fatalf("WritePartial: %v", err)
+		}
+	}
+	if ended {
+		if err := w.markEnded(); err != nil {
+			t.Fatalf("markEnded: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return w.Path(), pcm
+}
+
+// recordEnds returns the file offset after each record
+func recordEnds(t *testing.T, data []byte) []int {
+	t.Helper()
+	var ends []int
+	for pos := len(fileMagic); pos < len(data); {
+		pos += recordHeader + int(binary.LittleEndian.Uint32(data[pos:])) + 4
+		ends = append(ends, pos)
+	}
+	return ends
+}
+
+func TestJournalReplay(t *testing.T) {
+	for _, ended := range []bool{false, true} {
+		path, pcm := writeJournal(t, ended)
+		session, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if session.Meta != testMeta {
+			t.Errorf("meta = %+v, want %+v", session.Meta, testMeta)
+		}
+		if session.Frames != 3 || !bytes.Equal(session.Audio, pcm) {
+			t.Errorf("got %d frames and %d bytes, want 3 frames and %d bytes", session.Frames, len(session.Audio), len(pcm))
+		}
+		// Only the latest partial counts
+		if session.Partial != "word word word " {
+			t.Errorf("partial = %q, want the last one", session.Partial)
+		}
+		if session.Ended != ended {
+			t.Errorf("ended = %v, want %v", session.Ended, ended)
+		}
+		if session.Truncated {
+			t.Error("intact journal reported as truncated")
+		}
+		if want := 480 * time.Second / 16000; session.Duration() != want {
+			t.Errorf("duration = %v, want %v", session.Duration(), want)
+		}
+	}
+}
+
+func TestJournalFollow(t *testing.T) {
+	w, err := Create(t.TempDir(), testMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus := audio.NewFrameBus()
+	sub := bus.Subscribe("journal", 16, audio.Block)
+	done := make(chan struct{})
+	go func() {
+		w.Follow(sub)
+		close(done)
+	}()
+	bus.Publish(testFrame(0, 100))
+	bus.Publish(testFrame(1, 100))
+	bus.Publish(audio.Frame{Seq: 2, End: true})
+	sub.Unsubscribe()
+	<-done
+	w.Close()
+
+	session, err := Open(w.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Frames != 2 || !session.Ended {
+		t.Errorf("got %d frames, ended %v, want 2 frames and ended", session.Frames, session.Ended)
+	}
+}
+
+func TestJournalChecksum(t *testing.T) {
+	path, _ := writeJournal(t, true)
+	intact, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ends := recordEnds(t, intact)
+	// Records: meta, then frame and partial three times, then the end marker
+	if len(ends) != 8 {
+		t.Fatalf("journal has %d records, want 8", len(ends))
+	}
+
+	for i := range ends {
+		start := len(fileMagic)
+		if i > 0 {
+			start = ends[i-1]
+		}
+		// Flip one bit in the header, the payload and the checksum of each record
+		for _, pos := range []int{start + 4, start + recordHeader, ends[i] - 1} {
+			corrupt := bytes.Clone(intact)
+			corrupt[pos] ^= 0x10
+			if err := os.WriteFile(path, corrupt, 0600); err != nil {
+				t.Fatal(err)
+			}
+			session, err := Open(path)
+			if i == 0 {
+				// Without its metadata the journal cannot be recovered
+				if err == nil || !strings.Contains(err.Error(), "no session metadata") {
+					t.Errorf("corrupt metadata: error = %v, want missing metadata", err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("record %d, byte %d: %v", i, pos, err)
+			}
+			if !session.Truncated {
+				t.Errorf("record %d, byte %d: corruption not detected", i, pos)
+			}
+			// Records before the corrupt one survive; it and everything after are gone
+			if frames := i / 2; session.Frames != frames {
+				t.Errorf("record %d, byte %d: %d frames kept, want %d", i, pos, session.Frames, frames)
+			}
+			if session.Ended {
+				t.Errorf("record %d, byte %d: end marker kept after the corruption", i, pos)
+			}
+			if info, err := os.Stat(path); err != nil || info.Size() != int64(start) {
+				t.Errorf("record %d, byte %d: file truncated to %v bytes, want %d", i, pos, info.Size(), start)
+			}
+		}
+	}
+
+	// A file without the magic is not a journal
+	os.WriteFile(path, append([]byte("NOTAJRNL"), intact[len(fileMagic):]...), 0600)
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "is not a journal") {
+		t.Errorf("bad magic: error = %v", err)
+	}
+}
+
+func TestJournalTornTail(t *testing.T) {
+	path, pcm := writeJournal(t, false)
+	intact, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ends := recordEnds(t, intact)
+	// Tear the last frame record and the partial after it at every byte
+	lastFrame, prevPartial := ends[len(ends)-2], ends[len(ends)-3]
+	for size := prevPartial + 1; size < len(intact); size++ {
+		if size == lastFrame {
+			continue
+		}
+		frames, valid := 2, prevPartial
+		if size > lastFrame {
+			frames, valid = 3, lastFrame
+		}
+		if err := os.WriteFile(path, intact[:size], 0600); err != nil {
+			t.Fatal(err)
+		}
+		session, err := Open(path)
+		if err != nil {
+			t.Fatalf("torn at %d: %v", size, err)
+		}
+		if !session.Truncated {
+			t.Errorf("torn at %d: not reported as truncated", size)
+		}
+		if session.Frames != frames || !bytes.Equal(session.Audio, pcm[:frames*320]) {
+			t.Errorf("torn at %d: got %d frames, want %d", size, session.Frames, frames)
+		}
+		if session.Partial != "word word " {
+			t.Errorf("torn at %d: partial = %q, want the last complete one", size, session.Partial)
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != int64(valid) {
+			t.Fatalf("torn at %d: file is %d bytes after Open, want %d", size, info.Size(), valid)
+		}
+		// The truncated journal reads back cleanly
+		if session, err := Open(path); err != nil || session.Truncated {
+			t.Errorf("torn at %d: reopening gave truncated %v, err %v", size, session.Truncated, err)
+		}
+	}
+}
+
+func TestJournalFindAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 3; i++ {
+		meta := testMeta
+		meta.Started = meta.Started.Add(time.Duration(2-i) * time.Minute)
+		w, err := Create(dir, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		paths = append(paths, w.Path())
+	}
+	os.WriteFile(dir+"/notes.txt", []byte("not a journal"), 0600)
+
+	found, err := Find(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Oldest first
+	want := []string{paths[2], paths[1], paths[0]}
+	if strings.Join(found, ",") != strings.Join(want, ",") {
+		t.Errorf("Find = %v, want %v", found, want)
+	}
+
+	if err := Remove(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove(paths[0]); err != nil {
+		t.Errorf("removing a missing journal: %v", err)
+	}
+	if found, _ := Find(dir); len(found) != 2 {
+		t.Errorf("Find after Remove = %v, want 2 journals", found)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package journal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJournalLocked(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Create(dir, testMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// A journal that is still being written is neither recovered nor listed
+	if _, err := Open(w.Path()); !errors.Is(err, ErrLocked) {
+		t.Errorf("Open of a live journal = %v, want %v", err, ErrLocked)
+	}
+	if found, err := Find(dir); err != nil || len(found) != 0 {
+		t.Errorf("Find = %v, %v, want no journals", found, err)
+	}
+
+	// Once the writer is gone the journal is left for recovery
+	w.Close()
+	if found, _ := Find(dir); len(found) != 1 || found[0] != w.Path() {
+		t.Errorf("Find after Close = %v, want %s", found, w.Path())
+	}
+	if _, err := Open(w.Path()); err != nil {
+		t.Errorf("Open after Close: %v", err)
+	}
+}
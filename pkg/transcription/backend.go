@@ -0,0 +1,111 @@
+package transcription
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+// Capabilities describes what a backend can be used for
+type Capabilities struct {
+	Partial   bool // Fast enough to run on partial audio while recording
+	Languages bool // Honours the -lang setting
+}
+
+// Audio is the input of a backend
+type Audio struct {
+	WavFile string // WAV file in the recognizer format
+}
+
+// Result is the output of a backend
+type Result struct {
+	Text     string
+	Segments []TranscriptSegment // Timed pieces of Text, for backends that report them
+	Language string              // Language code of Text, if the backend knows it
+	Backend  string              // Name of the backend that produced the result
+}
+
+// Words returns the timed words of all segments in order; it is empty for
+// backends that do not report words
+func (r Result) Words() []Word {
+	var words []Word
+	for _, seg := range r.Segments {
+		words = append(words, seg.Words...)
+	}
+	return words
+}
+
+// Word is one recognized word and its position in the audio
+type Word struct {
+	Text       string
+	Start      time.Duration
+	End        time.Duration
+	Confidence float64 // From 0 to 1; 0 when the backend does not report it
+}
+
+// Backend is a speech recognition engine. Backends that keep processes running
+// also implement io.Closer.
+type Backend interface {
+	Name() string
+	Capabilities() Capabilities
+	Transcribe(ctx context.Context, audio Audio) (Result, error)
+}
+
+// Factory creates a backend from the configuration
+type Factory func(cfg *config.AppConfig) (Backend, error)
+
+// registry holds the backend factories by name
+var registry = map[string]Factory{}
+
+// Register makes a backend available to -backends; it panics if the name is taken
+func Register(name string, factory Factory) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("transcription backend %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Registered returns the names of the available backends, sorted
+func Registered() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewBackends creates the backends named in a comma-separated list such as
+// "vosk,system", in the order they should be tried
+func NewBackends(spec string, cfg *config.AppConfig) ([]Backend, error) {
+	var backends []Backend
+	seen := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transcription backend %q (available: %s)", name, strings.Join(Registered(), ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("transcription backend %q listed twice", name)
+		}
+		seen[name] = true
+
+		backend, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("transcription backend %s: %v", name, err)
+		}
+		backends = append(backends, backend)
+	}
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no transcription backends configured")
+	}
+	return backends, nil
+}
@@ -0,0 +1,206 @@
+package transcription
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+const (
+	// windowMax is the most audio the sliding window transcribes per update
+	windowMax = 20 * time.Second
+	// minWindowBytes is the amount of audio (0.5s) needed before the window is transcribed
+	minWindowBytes = config.SampleRate * config.Channels * 2 / 2
+)
+
+// Stream is one recognition fed with audio as it is recorded
+type Stream interface {
+	// Feed adds audio that follows what was fed before and returns the text so far
+	Feed(ctx context.Context, pcm []byte) (string, error)
+	// Final ends the stream and returns the result for all audio fed
+	Final(ctx context.Context) (Result, error)
+}
+
+// StreamingBackend is a backend that keeps its state between calls, so a stream only
+// processes the audio added since the last call
+type StreamingBackend interface {
+	Backend
+	NewStream(ctx context.Context, sampleRate int) (Stream, error)
+}
+
+// Recognizer transcribes a recording while it is made. Audio goes to the first partial
+// backend that can stream. Without one it falls back to a sliding window: audio that
+// leaves the window keeps the text it was given and only the window is transcribed
+// again, so an update costs the same in the fifth minute as in the first.
+type Recognizer struct {
+	t *Transcriber
+
+	feedMu   sync.Mutex // Held by Feed and Close
+	stream   Stream
+	name     string // Backend of the stream
+	noStream bool   // Streaming failed for this recording; use the window
+	window   []byte // Audio since the last commit, for the fallback
+	ended    bool
+
+	mu        sync.Mutex
+	committed string // Text of audio no longer in the stream or window
+	current   string // Text of the stream or window
+}
+
+// NewRecognizer starts a recognition for one recording
+func (t *Transcriber) NewRecognizer() *Recognizer {
+	return &Recognizer{t: t}
+}
+
+// Feed recognizes the audio of new frames; frames marking the end carry none
+func (r *Recognizer) Feed(ctx context.Context, frames []audio.Frame) error {
+	var pcm []byte
+	for _, frame := range frames {
+		pcm = frame.AppendPCM(pcm)
+	}
+	if len(pcm) == 0 {
+		return nil
+	}
+
+	r.feedMu.Lock()
+	defer r.feedMu.Unlock()
+	if r.ended {
+		return nil
+	}
+	if r.feedStream(ctx, pcm) {
+		return nil
+	}
+	return r.feedWindow(pcm)
+}
+
+// Partial returns the text recognized so far
+func (r *Recognizer) Partial() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return joinText(r.committed, r.current)
+}
+
+// Close ends the recognition; the final transcription does not wait for it
+func (r *Recognizer) Close() {
+	r.feedMu.Lock()
+	defer r.feedMu.Unlock()
+	r.ended = true
+	r.window = nil
+	if r.stream != nil {
+		if _, err := r.stream.Final(context.Background()); err != nil {
+			log.Printf("Failed to end %s stream: %v", r.name, err)
+		}
+		r.stream = nil
+	}
+}
+
+// feedStream sends audio to the stream and reports whether it was recognized. A stream
+// that fails is replaced once, keeping the text it produced; after that the recording
+// uses the window. The caller holds r.feedMu.
+func (r *Recognizer) feedStream(ctx context.Context, pcm []byte) bool {
+	for attempt := 0; attempt < 2 && !r.noStream; attempt++ {
+		if r.stream == nil && !r.openStream(ctx) {
+			break
+		}
+		text, err := r.stream.Feed(ctx, pcm)
+		if err == nil {
+			r.setCurrent(text)
+			return true
+		}
+		log.Printf("%s stream failed: %v", r.name, err)
+		r.stream = nil
+		r.commit()
+	}
+	r.noStream = true
+	return false
+}
+
+// openStream starts a stream on the first partial backend that supports one; the caller holds r.feedMu
+func (r *Recognizer) openStream(ctx context.Context) bool {
+	for _, backend := range r.t.backends {
+		streaming, ok := backend.(StreamingBackend)
+		if !ok || !backend.Capabilities().Partial {
+			continue
+		}
+		stream, err := streaming.NewStream(ctx, config.SampleRate)
+		if err != nil {
+			log.Printf("%s cannot stream: %v", backend.Name(), err)
+			continue
+		}
+		log.Printf("Streaming partial transcripts with %s", backend.Name())
+		r.stream, r.name = stream, backend.Name()
+		return true
+	}
+	log.Printf("No backend can stream, transcribing the last %v for partial transcripts", windowMax)
+	return false
+}
+
+// feedWindow adds audio to the sliding window and transcribes it. Once the window is
+// full its start is committed, cut at a pause so no word is split. The caller holds r.feedMu.
+func (r *Recognizer) feedWindow(pcm []byte) error {
+	r.window = append(r.window, pcm...)
+	if len(r.window) > bytesFor(windowMax) {
+		cfg := r.t.segmenter
+		cfg.MinSegment = windowMax / 4
+		cfg.MaxSegment = windowMax / 2
+		cfg.Padding = 0
+		cut := len(r.window)
+		if segments := audio.SegmentPCM(r.window, config.SampleRate, cfg); len(segments) > 0 {
+			cut = bytesFor(segments[0].End)
+			text, err := r.t.QuickTranscribe(r.window[:cut])
+			if err != nil {
+				log.Printf("Lost the partial transcript of %v of audio: %v", segments[0].End, err)
+			}
+			r.mu.Lock()
+			r.committed = joinText(r.committed, text)
+			r.mu.Unlock()
+		}
+		// Copy so the committed audio can be freed
+		r.window = append([]byte(nil), r.window[cut:]...)
+	}
+
+	if len(r.window) < minWindowBytes {
+		r.setCurrent("")
+		return nil
+	}
+	text, err := r.t.QuickTranscribe(r.window)
+	if err != nil {
+		return err
+	}
+	r.setCurrent(text)
+	return nil
+}
+
+// setCurrent replaces the text of the stream or window
+func (r *Recognizer) setCurrent(text string) {
+	r.mu.Lock()
+	r.current = strings.TrimSpace(text)
+	r.mu.Unlock()
+}
+
+// commit keeps the current text when its stream or window is dropped
+func (r *Recognizer) commit() {
+	r.mu.Lock()
+	r.committed = joinText(r.committed, r.current)
+	r.current = ""
+	r.mu.Unlock()
+}
+
+// bytesFor returns the size of d of recognizer-format audio
+func bytesFor(d time.Duration) int {
+	samples := int(int64(d) * config.SampleRate / int64(time.Second))
+	return samples * config.Channels * 2
+}
+
+// joinText joins two pieces of transcript with a space, leaving out empty ones
+func joinText(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + " " + b
+}
@@ -0,0 +1,157 @@
+package transcription
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+	"github.com/tarasowski/autospeech/pkg/config"
+	"github.com/tarasowski/autospeech/pkg/transcription/vosktest"
+)
+
+// workerBackend is a partial backend on fake Vosk workers: stream serves NewStream
+// and batch serves Transcribe, so a crashing stream does not take the window with it
+type workerBackend struct {
+	stream, batch *VoskWorker
+	streams       int // NewStream calls that succeed; -1 for any number
+	opened        int
+}
+
+func (b *workerBackend) Name() string {
+	return "fake"
+}
+
+func (b *workerBackend) Capabilities() Capabilities {
+	return Capabilities{Partial: true}
+}
+
+func (b *workerBackend) Transcribe(ctx context.Context, in Audio) (Result, error) {
+	pcm, err := audio.LoadAudio(in.WavFile)
+	if err != nil {
+		return Result{}, err
+	}
+	return b.batch.Transcribe(ctx, pcm, config.SampleRate)
+}
+
+func (b *workerBackend) NewStream(ctx context.Context, sampleRate int) (Stream, error) {
+	if b.streams >= 0 && b.opened >= b.streams {
+		return nil, errors.New("no more streams")
+	}
+	b.opened++
+	return b.stream.NewSession(ctx, sampleRate)
+}
+
+// newTestRecognizer returns a recognizer on a workerBackend whose stream worker behaves as opts says
+func newTestRecognizer(t *testing.T, streams int, opts vosktest.Options) *Recognizer {
+	t.Helper()
+	tr := NewTranscriber(&config.AppConfig{}, nil)
+	tr.SetBackends([]Backend{&workerBackend{
+		stream:  newTestWorker(t, "vosk", opts),
+		batch:   newTestWorker(t, "vosk", vosktest.Options{}),
+		streams: streams,
+	}})
+	rec := tr.NewRecognizer()
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// toneFrame returns a frame of one second of a 500 Hz tone; every VAD frame of it has the same energy
+func toneFrame() []audio.Frame {
+	samples := make([]int16, config.SampleRate)
+	for i := range samples {
+		samples[i] = int16(8000 * math.Sin(2*math.Pi*500*float64(i)/config.SampleRate))
+	}
+	return []audio.Frame{{Samples: samples}}
+}
+
+func TestRecognizerStream(t *testing.T) {
+	ctx := context.Background()
+	// Requests: start, then two half-second chunks per second; the third second kills
+	// the worker and the stream is replaced on a new one, keeping what it recognized
+	rec := newTestRecognizer(t, -1, vosktest.Options{CrashAfter: 6})
+	partials := []string{"s1", "s1 s2", "s1 s2 s1", "s1 s2 s1 s2"}
+	for i, want := range partials {
+		if err := rec.Feed(ctx, toneFrame()); err != nil {
+			t.Fatalf("Feed %d: %v", i+1, err)
+		}
+		if got := rec.Partial(); got != want {
+			t.Errorf("partial after %ds = %q, want %q", i+1, got, want)
+		}
+	}
+	if rec.noStream || rec.window != nil {
+		t.Error("recognizer fell back to the window after a single stream failure")
+	}
+
+	// Frames that only mark the end carry no audio
+	if err := rec.Feed(ctx, []audio.Frame{{End: true}}); err != nil || rec.Partial() != partials[3] {
+		t.Errorf("end frame changed the partial to %q (err %v)", rec.Partial(), err)
+	}
+	rec.Close()
+	if err := rec.Feed(ctx, toneFrame()); err != nil || rec.Partial() != partials[3] {
+		t.Errorf("feed after Close changed the partial to %q (err %v)", rec.Partial(), err)
+	}
+}
+
+func TestRecognizerStreamFailure(t *testing.T) {
+	ctx := context.Background()
+	// The stream dies on the third second and no new one can be opened: the text it
+	// produced is kept and the rest of the recording goes through the window
+	rec := newTestRecognizer(t, 1, vosktest.Options{CrashAfter: 6})
+	partials := []string{"s1", "s1 s2", "s1 s2 s1", "s1 s2 s1 s2"}
+	for i, want := range partials {
+		if err := rec.Feed(ctx, toneFrame()); err != nil {
+			t.Fatalf("Feed %d: %v", i+1, err)
+		}
+		if got := rec.Partial(); got != want {
+			t.Errorf("partial after %ds = %q, want %q", i+1, got, want)
+		}
+	}
+	if !rec.noStream || rec.stream != nil {
+		t.Error("recognizer still streams after the stream failed twice")
+	}
+	if len(rec.window) != bytesFor(2*time.Second) {
+		t.Errorf("window holds %d bytes, want the 2 seconds after the failure", len(rec.window))
+	}
+}
+
+func TestRecognizerWindow(t *testing.T) {
+	ctx := context.Background()
+	rec := newTestRecognizer(t, 0, vosktest.Options{})
+	const total = 50
+	for i := 1; i <= total; i++ {
+		if err := rec.Feed(ctx, toneFrame()); err != nil {
+			t.Fatalf("Feed %d: %v", i, err)
+		}
+		// Only the window is transcribed again, however long the recording gets
+		if len(rec.window) > bytesFor(windowMax) {
+			t.Fatalf("window holds %d bytes after %ds, more than %v", len(rec.window), i, windowMax)
+		}
+		// The tone has no pauses, so the window is cut at whole frames and each
+		// second of audio is one word, either committed or in the window
+		if words := len(strings.Fields(rec.Partial())); words != i {
+			t.Errorf("partial after %ds has %d words, want %d: %q", i, words, i, rec.Partial())
+		}
+	}
+	if rec.committed == "" {
+		t.Errorf("nothing committed after %ds with a %v window", total, windowMax)
+	}
+	if !rec.noStream {
+		t.Error("recognizer did not fall back to the window")
+	}
+}
+
+func TestRecognizerShortWindow(t *testing.T) {
+	rec := newTestRecognizer(t, 0, vosktest.Options{})
+	// Less than minWindowBytes is not worth transcribing
+	frame := audio.Frame{Samples: make([]int16, minWindowBytes/2-1)}
+	if err := rec.Feed(context.Background(), []audio.Frame{frame}); err != nil {
+		t.Fatalf("Feed: %v", err)
+	}
+	if rec.Partial() != "" || len(rec.window) != minWindowBytes-2 {
+		t.Errorf("partial %q with %d bytes in the window, want none and %d", rec.Partial(), len(rec.window), minWindowBytes-2)
+	}
+}
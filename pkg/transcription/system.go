@@ -0,0 +1,56 @@
+package transcription
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+func init() {
+	Register("system", newSystemBackend)
+}
+
+// systemCommands are the speech recognition tools the system backend looks for, in order
+var systemCommands = []string{"speech-recognition", "speech-to-text"}
+
+// systemBackend runs whichever speech recognition tool is installed on the system
+type systemBackend struct{}
+
+// newSystemBackend creates the system command backend
+func newSystemBackend(cfg *config.AppConfig) (Backend, error) {
+	return &systemBackend{}, nil
+}
+
+// Name returns the backend name
+func (b *systemBackend) Name() string {
+	return "system"
+}
+
+// Capabilities reports that system tools are tried for partial transcripts too
+func (b *systemBackend) Capabilities() Capabilities {
+	return Capabilities{Partial: true}
+}
+
+// Transcribe tries the system speech recognition tools in turn
+func (b *systemBackend) Transcribe(ctx context.Context, audio Audio) (Result, error) {
+	for _, command := range systemCommands {
+		path, err := exec.LookPath(command)
+		if err != nil {
+			continue
+		}
+		log.Printf("Found system speech recognition tool: %s at %s", command, path)
+
+		log.Printf("Running %s...", command)
+		output, err := exec.CommandContext(ctx, path, audio.WavFile).CombinedOutput()
+		if err == nil && len(output) > 0 {
+			log.Printf("Transcription result from %s: %s", command, string(output))
+			return Result{Text: string(output)}, nil
+		} else if err != nil {
+			log.Printf("%s failed: %v", command, err)
+		}
+	}
+	return Result{}, fmt.Errorf("no system speech recognition tools found")
+}
@@ -1,71 +1,408 @@
 package transcription
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/tarasowski/autospeech/pkg/audio"
 	"github.com/tarasowski/autospeech/pkg/config"
 )
 
+// ErrRecognitionFailed is returned when no backend produced a transcript
+var ErrRecognitionFailed = errors.New("speech recognition failed, install Vosk or another speech recognition backend")
+
 // Transcriber handles speech-to-text transcription
 type Transcriber struct {
-	cfg   *config.AppConfig
-	state *config.AppState
+	cfg       *config.AppConfig
+	state     *config.AppState
+	dsp       audio.DSPChain
+	segmenter audio.SegmenterConfig
+	backends  []Backend // Tried in order until one returns text
+}
+
+// TranscriptSegment is the transcript of one utterance and its position in the recording
+type TranscriptSegment struct {
+	Start    time.Duration
+	End      time.Duration
+	Text     string
+	Words    []Word // Timed words of Text, for backends that report them
+	Overlaps bool   // The audio was cut inside speech, so the text may repeat the end of the previous segment
 }
 
-// NewTranscriber creates a new transcription service
+// NewTranscriber creates a new transcription service; it has no backends until SetBackends is called
 func NewTranscriber(cfg *config.AppConfig, state *config.AppState) *Transcriber {
 	return &Transcriber{
-		cfg:   cfg,
-		state: state,
+		cfg:       cfg,
+		state:     state,
+		segmenter: audio.DefaultSegmenterConfig(),
+	}
+}
+
+// SetBackends sets the backends to try, in order
+func (t *Transcriber) SetBackends(backends []Backend) {
+	t.backends = backends
+}
+
+// Close releases backends that keep processes running
+func (t *Transcriber) Close() {
+	for _, backend := range t.backends {
+		if closer, ok := backend.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Failed to close %s backend: %v", backend.Name(), err)
+			}
+		}
+	}
+}
+
+// SetDSP sets the preprocessing applied to audio before it is transcribed
+func (t *Transcriber) SetDSP(chain audio.DSPChain) {
+	t.dsp = chain
+}
+
+// SetSegmenter sets how long recordings are split into utterances before they are transcribed
+func (t *Transcriber) SetSegmenter(cfg audio.SegmenterConfig) {
+	t.segmenter = cfg
+}
+
+// TranscribeAudio transcribes the current audio buffer, or the recording file it was
+// streamed to. The result's segments are the utterances if the recording was split, or
+// the backend's own segments. Its Backend is "none" if all backends failed.
+func (t *Transcriber) TranscribeAudio() (Result, error) {
+	tmpDir, err := audio.CreateTempDir("speech-reco")
+	if err != nil {
+		return Result{}, err
 	}
+	defer os.RemoveAll(tmpDir)
+
+	wavFile := t.state.GetRecordingFile()
+	if wavFile == "" {
+		// Stream the buffer to a file so audio spilled to disk is not read back into memory
+		if t.state.AudioBufferLen() == 0 {
+			log.Println("No audio data captured")
+			return Result{}, fmt.Errorf("no audio data captured")
+		}
+		wavFile = filepath.Join(tmpDir, "recording.wav")
+		log.Printf("Saving audio to temporary WAV file: %s", wavFile)
+		if err := audio.SaveAudioFrom(t.state.AudioBufferReader(), wavFile); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if len(t.dsp) == 0 && !audio.IsFlacPath(wavFile) && !t.needsSegmenting(wavFile) {
+		log.Printf("Transcribing recording file: %s", wavFile)
+		return t.transcribeFile(wavFile)
+	}
+	return t.transcribeBlocks(tmpDir, wavFile)
 }
 
-// TranscribeAudio transcribes the current audio buffer
-func (t *Transcriber) TranscribeAudio() (string, error) {
-	audioData := t.state.GetAudioBuffer()
+// TranscribePCM transcribes audio given as recognizer-format PCM, such as a recovered session
+func (t *Transcriber) TranscribePCM(audioData []byte) (Result, error) {
 	if len(audioData) == 0 {
 		log.Println("No audio data captured")
-		return "", fmt.Errorf("no audio data captured")
+		return Result{}, fmt.Errorf("no audio data captured")
 	}
 
 	log.Printf("Captured %d bytes of audio data", len(audioData))
+	audioData = t.preprocess(audioData)
 
-	// Save audio to a temporary WAV file
 	tmpDir, err := audio.CreateTempDir("speech-reco")
 	if err != nil {
-		return "", err
+		return Result{}, err
 	}
 	defer os.RemoveAll(tmpDir)
+	return t.transcribeProcessed(tmpDir, audioData)
+}
+
+// transcribeProcessed transcribes preprocessed PCM, split into utterances if it is long
+func (t *Transcriber) transcribeProcessed(tmpDir string, audioData []byte) (Result, error) {
+	if segments := audio.SegmentPCM(audioData, config.SampleRate, t.segmenter); len(segments) > 1 {
+		return t.transcribeSegments(tmpDir, segments)
+	}
 
 	wavFile := filepath.Join(tmpDir, "recording.wav")
 	log.Printf("Saving audio to temporary WAV file: %s", wavFile)
 	if err := audio.SaveAsWav(audioData, wavFile); err != nil {
-		return "", err
+		return Result{}, err
 	}
 
-	// Try different transcription methods
+	return t.transcribeFile(wavFile)
+}
+
+// blockDuration is how much of a recording file is read, preprocessed and segmented at a
+// time, so memory stays bounded however long the recording is
+const blockDuration = 10 * time.Minute
+
+// transcribeBlocks preprocesses and transcribes a recording file block by block. The last
+// utterance of each block is carried over to the next, so no utterance is cut at a block
+// boundary. Without a maximum segment length the recording is transcribed in one piece.
+func (t *Transcriber) transcribeBlocks(tmpDir, path string) (Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer file.Close()
+	reader, err := audio.NewAudioReader(file)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if t.segmenter.MaxSegment <= 0 || reader.Format() != audio.RecognizerFormat {
+		// Preprocessing needs the samples and recognizers need WAV, so read the recording back
+		data, err := audio.LoadAudio(path)
+		if err != nil {
+			return Result{}, err
+		}
+		return t.TranscribePCM(data)
+	}
+
+	buf := make([]int16, bytesFor(blockDuration)/2)
+	var pcm []byte           // Audio carried over from the last block, then the new block
+	var offset time.Duration // Position of pcm in the recording
+	var combined *Result     // Utterances transcribed so far
+	for {
+		n, eof, err := readBlock(reader, buf)
+		if err != nil {
+			return Result{}, fmt.Errorf("%s: %v", path, err)
+		}
+		pcm = append(pcm, t.preprocess(audio.AppendPCM16(nil, buf[:n]))...)
+		if eof && combined == nil {
+			// The whole recording fit in one block
+			return t.transcribeProcessed(tmpDir, pcm)
+		}
+		if combined == nil {
+			combined = &Result{Backend: "none"}
+		}
+
+		segments := audio.SegmentPCM(pcm, config.SampleRate, t.segmenter)
+		if eof {
+			if err := t.recognizeSegments(tmpDir, shiftSegments(segments, offset), combined); err != nil {
+				return Result{}, err
+			}
+			break
+		}
+		if len(segments) == 0 {
+			// Only silence so far
+			offset += time.Duration(len(pcm)/2) * time.Second / config.SampleRate
+			pcm = pcm[:0]
+			continue
+		}
+		carry := segments[len(segments)-1]
+		if err := t.recognizeSegments(tmpDir, shiftSegments(segments[:len(segments)-1], offset), combined); err != nil {
+			return Result{}, err
+		}
+		// Copy so the transcribed audio can be freed
+		pcm = append([]byte(nil), pcm[bytesFor(carry.Start):]...)
+		offset += carry.Start
+	}
+	return finishSegments(*combined)
+}
+
+// readBlock fills buf from reader and reports whether the end of the audio was reached
+func readBlock(reader audio.SampleReader, buf []int16) (int, bool, error) {
+	filled := 0
+	for filled < len(buf) {
+		n, err := reader.ReadSamples(buf[filled:])
+		filled += n
+		if err == io.EOF {
+			return filled, true, nil
+		}
+		if err != nil {
+			return filled, false, err
+		}
+	}
+	return filled, false, nil
+}
+
+// shiftSegments moves segments later by offset, to their place in the recording
+func shiftSegments(segments []audio.Segment, offset time.Duration) []audio.Segment {
+	for i := range segments {
+		segments[i].Start += offset
+		segments[i].End += offset
+	}
+	return segments
+}
+
+// needsSegmenting reports whether a recording file is too long to transcribe in one piece
+func (t *Transcriber) needsSegmenting(wavFile string) bool {
+	if t.segmenter.MaxSegment <= 0 {
+		return false
+	}
+	duration, err := audio.AudioDuration(wavFile)
+	return err != nil || duration > t.segmenter.MaxSegment
+}
+
+// transcribeFile runs the available transcription methods on a WAV file in the recognizer format
+func (t *Transcriber) transcribeFile(wavFile string) (Result, error) {
 	log.Println("Starting transcription...")
-	
-	// Try vosk first
-	transcript, err := t.transcribeWithVosk(wavFile)
-	if err == nil && transcript != "" {
-		return transcript, nil
+	result, ok := t.recognize(wavFile)
+	if !ok {
+		return Result{Backend: "none"}, ErrRecognitionFailed
 	}
-	
-	// Then try system commands
-	transcript, err = t.transcribeWithSystemCommand(wavFile)
-	if err == nil && transcript != "" {
-		return transcript, nil
+	return result, nil
+}
+
+// recognize tries the backends in turn and returns the first result with text,
+// with its Backend set, or false if all failed
+func (t *Transcriber) recognize(wavFile string) (Result, bool) {
+	for _, backend := range t.backends {
+		result, err := backend.Transcribe(context.Background(), Audio{WavFile: wavFile})
+		if err == nil && result.Text != "" {
+			result.Backend = backend.Name()
+			return result, true
+		}
+		if err != nil {
+			log.Printf("%s transcription failed: %v", backend.Name(), err)
+		}
 	}
-	
-	// Return a default message if all methods fail
-	return "Speech recognition failed. Please install Vosk or other speech recognition software.", nil
+	return Result{}, false
+}
+
+// transcribeSegments transcribes each utterance on its own and stitches the texts together in order
+func (t *Transcriber) transcribeSegments(tmpDir string, segments []audio.Segment) (Result, error) {
+	combined := Result{Backend: "none"}
+	if err := t.recognizeSegments(tmpDir, segments, &combined); err != nil {
+		return Result{}, err
+	}
+	return finishSegments(combined)
+}
+
+// recognizeSegments transcribes each utterance on its own and adds its transcript to combined
+func (t *Transcriber) recognizeSegments(tmpDir string, segments []audio.Segment, combined *Result) error {
+	log.Printf("Transcribing %d segments", len(segments))
+	for i, seg := range segments {
+		wavFile := filepath.Join(tmpDir, fmt.Sprintf("segment-%03d.wav", i))
+		if err := audio.SaveAsWav(seg.PCM, wavFile); err != nil {
+			return err
+		}
+		start := time.Now()
+		result, ok := t.recognize(wavFile)
+		os.Remove(wavFile)
+		if !ok {
+			log.Printf("Segment %d (%v-%v) produced no transcript", i+1, seg.Start, seg.End)
+			continue
+		}
+		log.Printf("Segment %d (%v-%v) transcribed by %s in %v", i+1, seg.Start, seg.End, result.Backend, time.Since(start))
+		combined.Backend = result.Backend
+		if result.Language != "" {
+			combined.Language = result.Language
+		}
+		if len(result.Segments) == 0 {
+			combined.Segments = append(combined.Segments, TranscriptSegment{
+				Start:    seg.Start,
+				End:      seg.End,
+				Text:     strings.TrimSpace(result.Text),
+				Overlaps: seg.Split,
+			})
+			continue
+		}
+		// Keep the backend's finer timing, moved to the segment's place in the recording
+		for j, piece := range result.Segments {
+			piece.Start += seg.Start
+			piece.End += seg.Start
+			piece.Words = shiftWords(piece.Words, seg.Start)
+			piece.Overlaps = j == 0 && seg.Split
+			combined.Segments = append(combined.Segments, piece)
+		}
+	}
+	return nil
+}
+
+// finishSegments drops the words repeated where utterances overlap and joins their texts
+func finishSegments(combined Result) (Result, error) {
+	trimOverlaps(combined.Segments)
+	combined.Text = StitchSegments(combined.Segments)
+	if len(combined.Segments) == 0 {
+		return combined, ErrRecognitionFailed
+	}
+	return combined, nil
+}
+
+// shiftWords returns a copy of words moved later by offset
+func shiftWords(words []Word, offset time.Duration) []Word {
+	if len(words) == 0 {
+		return nil
+	}
+	shifted := make([]Word, len(words))
+	for i, w := range words {
+		w.Start += offset
+		w.End += offset
+		shifted[i] = w
+	}
+	return shifted
+}
+
+// trimOverlaps drops the words that segments cut inside speech repeat from the
+// segments before them, from both the text and the word timings
+func trimOverlaps(segments []TranscriptSegment) {
+	var prev []string
+	for i := range segments {
+		seg := &segments[i]
+		words := strings.Fields(seg.Text)
+		if seg.Overlaps {
+			if n := repeatedWords(prev, words); n > 0 {
+				words = words[n:]
+				seg.Text = strings.Join(words, " ")
+				if len(seg.Words) > n {
+					seg.Words = seg.Words[n:]
+					seg.Start = seg.Words[0].Start
+				}
+			}
+			seg.Overlaps = false
+		}
+		prev = append(prev, words...)
+	}
+}
+
+// StitchSegments joins the texts of consecutive utterances. Where the audio was cut
+// inside speech, words repeated at the start of a segment because of the padding are dropped.
+func StitchSegments(segments []TranscriptSegment) string {
+	var words []string
+	for _, seg := range segments {
+		next := strings.Fields(seg.Text)
+		if seg.Overlaps {
+			next = next[repeatedWords(words, next):]
+		}
+		words = append(words, next...)
+	}
+	return strings.Join(words, " ")
+}
+
+// maxOverlapWords bounds how many words the padding of a segment can repeat
+const maxOverlapWords = 3
+
+// repeatedWords returns the length of the longest run of words at the start of next
+// that repeats the end of prev, leaving at least one word of next
+func repeatedWords(prev, next []string) int {
+	for n := min(maxOverlapWords, len(prev), len(next)-1); n > 0; n-- {
+		match := true
+		for i := 0; i < n; i++ {
+			if !strings.EqualFold(prev[len(prev)-n+i], next[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return n
+		}
+	}
+	return 0
+}
+
+// preprocess runs the DSP chain over recognizer-format PCM
+func (t *Transcriber) preprocess(audioData []byte) []byte {
+	if len(t.dsp) == 0 {
+		return audioData
+	}
+	start := time.Now()
+	processed := t.dsp.ProcessPCM(audioData, config.SampleRate)
+	log.Printf("Preprocessed %d bytes with %v in %v", len(audioData), t.dsp, time.Since(start))
+	return processed
 }
 
 // QuickTranscribe performs a fast transcription on partial audio
@@ -79,7 +416,7 @@ func (t *Transcriber) QuickTranscribe(audioData []byte) (string, error) {
 	defer os.RemoveAll(tmpDir)
 
 	wavFile := filepath.Join(tmpDir, "partial.wav")
-	if err := audio.SaveAsWav(audioData, wavFile); err != nil {
+	if err := audio.SaveAsWav(t.preprocess(audioData), wavFile); err != nil {
 		log.Printf("Failed to save partial audio: %v", err)
 		return "", err
 	}
@@ -88,117 +425,23 @@ func (t *Transcriber) QuickTranscribe(audioData []byte) (string, error) {
 	log.Printf("Created temporary WAV file for real-time transcription: %s (size: %d bytes)", 
 		wavFile, len(audioData))
 
-	// Try to transcribe with Vosk (fastest option)
-	transcript, err := t.transcribeWithVosk(wavFile)
-	if err == nil && transcript != "" {
-		log.Printf("Vosk real-time transcription: '%s'", transcript)
-		return transcript, nil
-	} else if err != nil {
-		log.Printf("Vosk transcription failed: %v", err)
-	} else {
-		log.Printf("Vosk returned empty transcription")
-	}
-
-	// Fallback to other methods if available
-	transcript, err = t.transcribeWithSystemCommand(wavFile)
-	if err == nil && transcript != "" {
-		log.Printf("System command real-time transcription: '%s'", transcript)
-		return transcript, nil
-	} else if err != nil {
-		log.Printf("System command transcription failed: %v", err)
-	} else {
-		log.Printf("System command returned empty transcription")
-	}
-
-	return "", fmt.Errorf("real-time transcription failed")
-}
-
-// transcribeWithVosk uses the Vosk speech recognition toolkit
-func (t *Transcriber) transcribeWithVosk(wavFile string) (string, error) {
-	// Try to find vosk-transcribe in various locations
-	possiblePaths := []string{
-		"./vosk-transcribe",                         // Current directory
-		"../vosk-transcribe",                        // Parent directory
-		"/usr/local/bin/vosk-transcribe",            // System-wide install
-		"/usr/bin/vosk-transcribe",                  // Alternative system location
-		filepath.Join(os.Getenv("HOME"), "vosk-transcribe"), // User's home directory
-	}
-	
-	voskCmd := ""
-	found := false
-	
-	// Check each possible path
-	for _, path := range possiblePaths {
-		if _, err := os.Stat(path); err == nil {
-			voskCmd = path
-			found = true
-			log.Printf("Found vosk-transcribe at %s", voskCmd)
-			break
+	// Try the backends that are fast enough, in order
+	for _, backend := range t.backends {
+		if !backend.Capabilities().Partial {
+			continue
 		}
-	}
-	
-	// If not found in specific locations, try PATH lookup
-	if !found {
-		var err error
-		cmd := "vosk-transcribe"
-		voskCmd, err = exec.LookPath(cmd)
-		if err != nil {
-			log.Printf("vosk-transcribe not found in PATH: %v", err)
-			return "", fmt.Errorf("vosk-transcribe not found, run setup-vosk-small.sh to install")
+		result, err := backend.Transcribe(context.Background(), Audio{WavFile: wavFile})
+		if err == nil && result.Text != "" {
+			log.Printf("%s real-time transcription: '%s'", backend.Name(), result.Text)
+			return result.Text, nil
+		} else if err != nil {
+			log.Printf("%s transcription failed: %v", backend.Name(), err)
+		} else {
+			log.Printf("%s returned empty transcription", backend.Name())
 		}
-		log.Printf("Found vosk-transcribe in PATH at %s", voskCmd)
 	}
-	
-	// Run vosk-transcribe with the WAV file
-	log.Printf("Running Vosk transcription with: %s", voskCmd)
-	cmd := exec.Command(voskCmd, wavFile)
-	
-	// Capture output
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("vosk transcription failed: %v, output: %s", err, string(output))
-	}
-	
-	// Return the transcribed text
-	transcription := strings.TrimSpace(string(output))
-	if transcription == "" {
-		return "", fmt.Errorf("no transcription output from vosk")
-	}
-	
-	return transcription, nil
-}
 
-// transcribeWithSystemCommand tries other system speech recognition tools
-func (t *Transcriber) transcribeWithSystemCommand(wavFile string) (string, error) {
-	// Try different system commands
-	cmds := []struct {
-		name    string
-		command string
-		args    []string
-	}{
-		// Try system speech-to-text commands that might be available
-		{"speech-recognition", "speech-recognition", []string{wavFile}},
-		{"speech-to-text", "speech-to-text", []string{wavFile}},
-	}
-	
-	for _, cmdInfo := range cmds {
-		path, err := exec.LookPath(cmdInfo.command)
-		if err == nil {
-			log.Printf("Found system speech recognition tool: %s at %s", cmdInfo.name, path)
-			cmd := exec.Command(path, cmdInfo.args...)
-			
-			log.Printf("Running %s...", cmdInfo.name)
-			output, err := cmd.CombinedOutput()
-			if err == nil && len(output) > 0 {
-				log.Printf("Transcription result from %s: %s", cmdInfo.name, string(output))
-				return string(output), nil
-			} else if err != nil {
-				log.Printf("%s failed: %v", cmdInfo.name, err)
-			}
-		}
-	}
-	
-	return "", fmt.Errorf("no system speech recognition tools found")
+	return "", fmt.Errorf("real-time transcription failed")
 }
 
 // ExtractTextFromJSON extracts text field from JSON output
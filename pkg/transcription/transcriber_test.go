@@ -0,0 +1,361 @@
+package transcription
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+	"github.com/tarasowski/autospeech/pkg/config"
+	"github.com/tarasowski/autospeech/pkg/transcription/vosktest"
+)
+
+// installFakeVosk puts the test binary in PATH as the only vosk-transcribe
+func installFakeVosk(t *testing.T) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(exe, filepath.Join(dir, "vosk-transcribe")); err != nil {
+		t.Skipf("cannot link the fake vosk-transcribe: %v", err)
+	}
+	t.Setenv("PATH", dir)
+	t.Setenv("HOME", dir)
+	for _, kv := range fakeVoskVars(t, "vosk", vosktest.Options{}) {
+		key, value, _ := strings.Cut(kv, "=")
+		t.Setenv(key, value)
+	}
+}
+
+// recordTone records seconds of a tone from the generator into the state, or the recording file
+func recordTone(t *testing.T, cfg *config.AppConfig, state *config.AppState, seconds int) {
+	t.Helper()
+	gen := audio.NewGeneratorSource(audio.RecognizerFormat)
+	gen.Duration = time.Duration(seconds) * time.Second
+	r := audio.NewRecorder(state, cfg)
+	r.SetSource(gen)
+	if err := r.StartRecording(context.Background(), nil); err != nil {
+		t.Fatalf("StartRecording: %v", err)
+	}
+}
+
+func TestTranscribeRecording(t *testing.T) {
+	tests := []struct {
+		name   string
+		worker bool
+		cfg    config.AppConfig
+	}{
+		{name: "buffer", worker: true},
+		{name: "buffer without worker"},
+		{name: "spilled buffer", worker: true, cfg: config.AppConfig{MaxRecording: time.Second, OverflowPolicy: config.OverflowSpill}},
+		{name: "recording file", worker: true, cfg: config.AppConfig{RecordTo: "rec.wav"}},
+		{name: "FLAC recording file", cfg: config.AppConfig{RecordTo: "rec.flac"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installFakeVosk(t)
+			cfg := tt.cfg
+			cfg.VoskWorker = tt.worker
+			if cfg.RecordTo != "" {
+				cfg.RecordTo = filepath.Join(t.TempDir(), cfg.RecordTo)
+			}
+			state := config.NewAppState(&cfg)
+			recordTone(t, &cfg, state, 3)
+
+			backends, err := NewBackends("vosk", &cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := NewTranscriber(&cfg, state)
+			tr.SetBackends(backends)
+			defer tr.Close()
+
+			result, err := tr.TranscribeAudio()
+			if err != nil {
+				t.Fatalf("TranscribeAudio: %v", err)
+			}
+			if result.Text != "s1 s2 s3" || result.Backend != "vosk" {
+				t.Errorf("got %q from %q, want %q from vosk", result.Text, result.Backend, "s1 s2 s3")
+			}
+			words := result.Words()
+			if len(words) != 3 || words[2].Text != "s3" || words[2].End != 3*time.Second {
+				t.Errorf("words = %v, want s1 to s3 one second each", words)
+			}
+		})
+	}
+}
+
+func TestTranscribeWithoutBackends(t *testing.T) {
+	// vosk-transcribe cannot be found
+	empty := t.TempDir()
+	t.Setenv("PATH", empty)
+	t.Setenv("HOME", empty)
+	cfg := config.AppConfig{}
+	state := config.NewAppState(&cfg)
+	recordTone(t, &cfg, state, 1)
+
+	backends, err := NewBackends("vosk", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTranscriber(&cfg, state)
+	tr.SetBackends(backends)
+	defer tr.Close()
+
+	result, err := tr.TranscribeAudio()
+	if !errors.Is(err, ErrRecognitionFailed) {
+		t.Errorf("error = %v, want %v", err, ErrRecognitionFailed)
+	}
+	if result.Backend != "none" || result.Text != "" {
+		t.Errorf("got %q from %q, want no text from none", result.Text, result.Backend)
+	}
+}
+
+// dictation builds a recording of numbered words: word i is 0.4s of a 500 Hz tone
+// at amplitude 1000+10*i, so a backend can tell from the audio alone which words it got
+type dictation struct {
+	samples []int16
+	starts  []time.Duration // Start of each word in the recording
+}
+
+// say adds a sentence of n words 0.1s apart
+func (d *dictation) say(n int) {
+	for w := 0; w < n; w++ {
+		if w > 0 {
+			d.pause(0.1)
+		}
+		d.starts = append(d.starts, d.at())
+		amp := float64(1000 + 10*(len(d.starts)-1))
+		for i := 0; i < config.SampleRate*4/10; i++ {
+			d.samples = append(d.samples, int16(amp*math.Sin(2*math.Pi*500*float64(i)/config.SampleRate)))
+		}
+	}
+}
+
+// pause adds secs of silence
+func (d *dictation) pause(secs float64) {
+	d.samples = append(d.samples, make([]int16, int(math.Round(secs*config.SampleRate)))...)
+}
+
+func (d *dictation) at() time.Duration {
+	return time.Duration(len(d.samples)) * time.Second / config.SampleRate
+}
+
+// text is the transcript of every word once
+func (d *dictation) text() string {
+	names := make([]string, len(d.starts))
+	for i := range names {
+		names[i] = fmt.Sprintf("w%d", i)
+	}
+	return strings.Join(names, " ")
+}
+
+// wordBackend names the words of a dictation it hears, also the parts of words at
+// the edges of a segment, and keeps the length of every piece of audio it got
+type wordBackend struct {
+	calls []time.Duration
+}
+
+func (b *wordBackend) Name() string {
+	return "words"
+}
+
+func (b *wordBackend) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func (b *wordBackend) Transcribe(ctx context.Context, in Audio) (Result, error) {
+	pcm, err := audio.LoadAudio(in.WavFile)
+	if err != nil {
+		return Result{}, err
+	}
+	b.calls = append(b.calls, time.Duration(len(pcm)/2)*time.Second/config.SampleRate)
+
+	// A word ends at the first run of zeros longer than the tone has
+	var names []string
+	peak, zeros := 0, 0
+	for i := 0; i+1 < len(pcm); i += 2 {
+		s := int(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		if s == 0 {
+			zeros++
+		} else {
+			zeros = 0
+			peak = max(peak, s, -s)
+		}
+		if peak > 0 && (zeros == 32 || i+2 >= len(pcm)) {
+			names = append(names, fmt.Sprintf("w%d", (peak-1000+5)/10))
+			peak = 0
+		}
+	}
+	return Result{Text: strings.Join(names, " ")}, nil
+}
+
+func TestTranscribeBlocks(t *testing.T) {
+	tests := []struct {
+		name       string
+		record     func(d *dictation)
+		boundaries []time.Duration // Block edges a segment must span
+	}{
+		{
+			name: "one block",
+			record: func(d *dictation) {
+				for d.at() < time.Minute {
+					d.say(9)
+					d.pause(1.2)
+				}
+			},
+		},
+		{
+			// Sentences without a pause for 40 seconds around each block boundary
+			// force cuts inside speech in the audio carried over
+			name: "three blocks",
+			record: func(d *dictation) {
+				for _, edge := range []time.Duration{blockDuration, 2 * blockDuration} {
+					for d.at() < edge-20*time.Second {
+						d.say(9)
+						d.pause(1.2)
+					}
+					d.say(80)
+					d.pause(1.2)
+				}
+				for i := 0; i < 5; i++ {
+					d.say(9)
+					d.pause(1.2)
+				}
+			},
+			boundaries: []time.Duration{blockDuration, 2 * blockDuration},
+		},
+		{
+			name: "silent first block",
+			record: func(d *dictation) {
+				d.pause(blockDuration.Seconds() + 10)
+				for i := 0; i < 5; i++ {
+					d.say(9)
+					d.pause(1.2)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d dictation
+			tt.record(&d)
+			path := filepath.Join(t.TempDir(), "recording.wav")
+			if err := audio.SaveAsWav(audio.AppendPCM16(nil, d.samples), path); err != nil {
+				t.Fatal(err)
+			}
+			d.samples = nil
+
+			backend := &wordBackend{}
+			tr := NewTranscriber(&config.AppConfig{}, nil)
+			tr.SetBackends([]Backend{backend})
+			result, err := tr.transcribeBlocks(t.TempDir(), path)
+			if err != nil {
+				t.Fatalf("transcribeBlocks: %v", err)
+			}
+			// Every word once: none lost at a cut, none repeated by the padding
+			if want := d.text(); result.Text != want {
+				got, all := strings.Fields(result.Text), strings.Fields(want)
+				t.Fatalf("got %d words, want the %d spoken once; first difference at word %d",
+					len(got), len(all), firstDifference(got, all))
+			}
+
+			seg := tr.segmenter
+			for _, call := range backend.calls {
+				if call > seg.MaxSegment+2*seg.Padding {
+					t.Errorf("recognized %v of audio at once, longer than a segment", call)
+				}
+			}
+			// Segments are placed in the recording, not in their block
+			for _, s := range result.Segments {
+				for _, name := range strings.Fields(s.Text) {
+					var i int
+					fmt.Sscanf(name, "w%d", &i)
+					if start := d.starts[i]; start < s.Start || start >= s.End {
+						t.Errorf("%s starts at %v, outside its segment %v-%v", name, start, s.Start, s.End)
+					}
+				}
+			}
+			// The last utterance of a block is carried over instead of cut at the edge
+			for _, edge := range tt.boundaries {
+				spanned := false
+				for _, s := range result.Segments {
+					spanned = spanned || s.Start < edge && s.End > edge
+				}
+				if !spanned {
+					t.Errorf("no segment spans the block boundary at %v", edge)
+				}
+			}
+		})
+	}
+}
+
+// firstDifference returns the index of the first word where got and want differ
+func firstDifference(got, want []string) int {
+	i := 0
+	for i < len(got) && i < len(want) && got[i] == want[i] {
+		i++
+	}
+	return i
+}
+
+func TestStitchSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []TranscriptSegment
+		want     string
+	}{
+		{"none", nil, ""},
+		{"cut at pauses", []TranscriptSegment{{Text: "one two"}, {Text: " three  four "}}, "one two three four"},
+		{"repeats kept without overlap", []TranscriptSegment{{Text: "one two"}, {Text: "two three"}}, "one two two three"},
+		{"one word repeated", []TranscriptSegment{{Text: "one two"}, {Text: "two three", Overlaps: true}}, "one two three"},
+		{"three words repeated", []TranscriptSegment{{Text: "a b c d"}, {Text: "b c d e", Overlaps: true}}, "a b c d e"},
+		{"longest repeat wins", []TranscriptSegment{{Text: "x y x y"}, {Text: "x y z", Overlaps: true}}, "x y x y z"},
+		{"at most three words", []TranscriptSegment{{Text: "a b c d"}, {Text: "a b c d e", Overlaps: true}}, "a b c d a b c d e"},
+		{"case does not matter", []TranscriptSegment{{Text: "Hello World"}, {Text: "world again", Overlaps: true}}, "Hello World again"},
+		{"last word kept", []TranscriptSegment{{Text: "one two"}, {Text: "two", Overlaps: true}}, "one two two"},
+		{"across an empty segment", []TranscriptSegment{{Text: "one two"}, {}, {Text: "two three", Overlaps: true}}, "one two three"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StitchSegments(tt.segments); got != tt.want {
+				t.Errorf("StitchSegments = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimOverlaps(t *testing.T) {
+	word := func(text string, at float64) Word {
+		return Word{Text: text, Start: time.Duration(at * float64(time.Second)), End: time.Duration((at + 0.4) * float64(time.Second))}
+	}
+	segments := []TranscriptSegment{
+		{Start: 0, End: 3 * time.Second, Text: "a b c", Words: []Word{word("a", 0), word("b", 1), word("c", 2)}},
+		{Start: 2 * time.Second, End: 5 * time.Second, Text: "c d e", Words: []Word{word("c", 2), word("d", 3), word("e", 4)}, Overlaps: true},
+		{Start: 5 * time.Second, End: 6 * time.Second, Text: "e f", Overlaps: true},
+	}
+	trimOverlaps(segments)
+	if segments[1].Text != "d e" || len(segments[1].Words) != 2 || segments[1].Start != 3*time.Second {
+		t.Errorf("second segment = %q from %v with %d words, want %q from 3s with 2", segments[1].Text, segments[1].Start, len(segments[1].Words), "d e")
+	}
+	if segments[2].Text != "f" || segments[2].Start != 5*time.Second {
+		t.Errorf("third segment = %q from %v, want %q from 5s", segments[2].Text, segments[2].Start, "f")
+	}
+	for i, s := range segments {
+		if s.Overlaps {
+			t.Errorf("segment %d still marked as overlapping", i)
+		}
+	}
+	if got := StitchSegments(segments); got != "a b c d e f" {
+		t.Errorf("stitched text = %q", got)
+	}
+}
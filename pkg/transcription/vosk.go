@@ -0,0 +1,287 @@
+package transcription
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+func init() {
+	Register("vosk", newVoskBackend)
+}
+
+// voskBackend runs the vosk-transcribe script, as a long-lived worker unless
+// -vosk-worker=false, or once per recording
+type voskBackend struct {
+	cfg *config.AppConfig
+
+	mu     sync.Mutex
+	worker *VoskWorker // Created once the script is found
+}
+
+// newVoskBackend creates the Vosk backend; the script is looked up on use so it can be installed while the app runs
+func newVoskBackend(cfg *config.AppConfig) (Backend, error) {
+	if cfg.Language != "" {
+		if _, ok := config.LookupLanguage(cfg.Language); !ok {
+			var codes []string
+			for _, lang := range config.Languages {
+				codes = append(codes, lang.Code)
+			}
+			return nil, fmt.Errorf("no Vosk models for language %q (available: %s); use the whisper backend for other languages",
+				cfg.Language, strings.Join(codes, ", "))
+		}
+	}
+	b := &voskBackend{cfg: cfg}
+	if cfg.VoskWorker {
+		// Load the model now so the first partial transcript does not wait for it
+		go func() {
+			if w := b.getWorker(); w != nil {
+				if err := w.Start(); err != nil {
+					log.Printf("Vosk worker unavailable, running vosk-transcribe once per transcription: %v", err)
+				}
+			}
+		}()
+	}
+	return b, nil
+}
+
+// getWorker returns the worker, or nil if workers are off or the script is missing
+func (b *voskBackend) getWorker() *VoskWorker {
+	if !b.cfg.VoskWorker {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.worker == nil {
+		voskCmd, err := findVoskScript()
+		if err != nil {
+			return nil
+		}
+		b.worker = NewVoskWorker(voskCmd, b.env())
+	}
+	return b.worker
+}
+
+// Close stops the worker
+func (b *voskBackend) Close() error {
+	b.mu.Lock()
+	w := b.worker
+	b.mu.Unlock()
+	if w == nil {
+		return nil
+	}
+	return w.Close()
+}
+
+// Name returns the backend name
+func (b *voskBackend) Name() string {
+	return "vosk"
+}
+
+// Capabilities reports that Vosk is fast enough for partial transcripts and picks its model by language
+func (b *voskBackend) Capabilities() Capabilities {
+	return Capabilities{Partial: true, Languages: true}
+}
+
+// Transcribe uses the Vosk speech recognition toolkit
+func (b *voskBackend) Transcribe(ctx context.Context, in Audio) (Result, error) {
+	if w := b.getWorker(); w != nil {
+		result, err := b.transcribeWithWorker(ctx, w, in)
+		if err == nil {
+			return result, nil
+		}
+		log.Printf("Vosk worker failed, running vosk-transcribe once: %v", err)
+	}
+
+	voskCmd, err := findVoskScript()
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Run vosk-transcribe with the WAV file
+	log.Printf("Running Vosk transcription with: %s", voskCmd)
+	cmd := exec.CommandContext(ctx, voskCmd, in.WavFile)
+	cmd.Env = b.env()
+
+	// Capture output
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return Result{}, fmt.Errorf("vosk transcription failed: %v, output: %s", err, string(output))
+	}
+
+	result := parseVoskOutput(output)
+	if result.Text == "" {
+		return Result{}, fmt.Errorf("no transcription output from vosk")
+	}
+	result.Language = b.language()
+	return result, nil
+}
+
+// NewStream starts a recognition on the worker, which keeps Vosk's state between
+// calls; without the worker Vosk cannot stream
+func (b *voskBackend) NewStream(ctx context.Context, sampleRate int) (Stream, error) {
+	w := b.getWorker()
+	if w == nil {
+		return nil, fmt.Errorf("vosk needs its worker to stream")
+	}
+	session, err := w.NewSession(ctx, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// transcribeWithWorker sends the recording through the worker, trying once more on a
+// fresh process if the worker crashed along the way
+func (b *voskBackend) transcribeWithWorker(ctx context.Context, w *VoskWorker, in Audio) (Result, error) {
+	pcm, err := audio.LoadAudio(in.WavFile)
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := w.Transcribe(ctx, pcm, config.SampleRate)
+	if errors.Is(err, errWorkerCrashed) || errors.Is(err, errWorkerRestarted) {
+		result, err = w.Transcribe(ctx, pcm, config.SampleRate)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if result.Text == "" {
+		return Result{}, fmt.Errorf("no transcription output from vosk")
+	}
+	result.Language = b.language()
+	return result, nil
+}
+
+// env points the script at the model for the selected language; nil keeps the app's environment
+func (b *voskBackend) env() []string {
+	lang, modelPath, ok := b.model()
+	if !ok {
+		if lang.Name != "" {
+			log.Printf("No Vosk model installed for %s, using the script default", lang.Name)
+		}
+		return nil
+	}
+	return append(os.Environ(), "VOSK_MODEL="+modelPath)
+}
+
+// language returns the code of the language the script recognizes, or "" for the script default
+func (b *voskBackend) language() string {
+	lang, _, ok := b.model()
+	if !ok {
+		return ""
+	}
+	return lang.Code
+}
+
+// model finds the installed model for the selected language; ok is false when the
+// script should use its default
+func (b *voskBackend) model() (lang config.Language, modelPath string, ok bool) {
+	if b.cfg.Language == "" {
+		return config.Language{}, "", false
+	}
+	lang, ok = config.LookupLanguage(b.cfg.Language)
+	if !ok {
+		return config.Language{}, "", false
+	}
+	modelPath, ok = lang.VoskModelPath()
+	return lang, modelPath, ok
+}
+
+// voskOutput is the JSON object vosk-transcribe prints for a recording, and the
+// content of the worker's final reply. Times are in seconds.
+type voskOutput struct {
+	Text     string        `json:"text"`
+	Segments []voskSegment `json:"segments,omitempty"`
+}
+
+// voskSegment is one utterance of a vosk-transcribe result
+type voskSegment struct {
+	Start float64    `json:"start"`
+	End   float64    `json:"end"`
+	Text  string     `json:"text"`
+	Words []voskWord `json:"words,omitempty"`
+}
+
+// voskWord is one word of an utterance, with Vosk's confidence
+type voskWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Conf  float64 `json:"conf"`
+}
+
+// parseVoskOutput reads what vosk-transcribe prints: a JSON object on its last line,
+// or just the text from scripts installed before the JSON output
+func parseVoskOutput(output []byte) Result {
+	trimmed := strings.TrimSpace(string(output))
+	lines := strings.Split(trimmed, "\n")
+	var out voskOutput
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &out); err != nil {
+		return Result{Text: trimmed}
+	}
+	return out.result()
+}
+
+// result converts the script's output to a Result
+func (o voskOutput) result() Result {
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second))
+	}
+	result := Result{Text: strings.TrimSpace(o.Text)}
+	for _, seg := range o.Segments {
+		piece := TranscriptSegment{
+			Start: seconds(seg.Start),
+			End:   seconds(seg.End),
+			Text:  strings.TrimSpace(seg.Text),
+		}
+		for _, w := range seg.Words {
+			piece.Words = append(piece.Words, Word{
+				Text:       w.Word,
+				Start:      seconds(w.Start),
+				End:        seconds(w.End),
+				Confidence: w.Conf,
+			})
+		}
+		result.Segments = append(result.Segments, piece)
+	}
+	return result
+}
+
+// findVoskScript looks for vosk-transcribe next to the app, in the usual install locations and in PATH
+func findVoskScript() (string, error) {
+	possiblePaths := []string{
+		"./vosk-transcribe",                                 // Current directory
+		"../vosk-transcribe",                                // Parent directory
+		"/usr/local/bin/vosk-transcribe",                    // System-wide install
+		"/usr/bin/vosk-transcribe",                          // Alternative system location
+		filepath.Join(os.Getenv("HOME"), "vosk-transcribe"), // User's home directory
+	}
+
+	// Check each possible path
+	for _, path := range possiblePaths {
+		if _, err := os.Stat(path); err == nil {
+			log.Printf("Found vosk-transcribe at %s", path)
+			return path, nil
+		}
+	}
+
+	// If not found in specific locations, try PATH lookup
+	voskCmd, err := exec.LookPath("vosk-transcribe")
+	if err != nil {
+		log.Printf("vosk-transcribe not found in PATH: %v", err)
+		return "", fmt.Errorf("vosk-transcribe not found, run setup-vosk-small.sh to install")
+	}
+	log.Printf("Found vosk-transcribe in PATH at %s", voskCmd)
+	return voskCmd, nil
+}
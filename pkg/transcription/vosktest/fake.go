@@ -0,0 +1,154 @@
+// Package vosktest provides a fake vosk-transcribe worker that speaks the worker
+// protocol without Python or a model, for exercising the Vosk backend
+package vosktest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Options change how the fake worker behaves
+type Options struct {
+	LoadDelay    time.Duration // Pretend to load a model before saying hello
+	CrashAfter   int           // Exit without a reply on this request, counting from 1 (0 never crashes)
+	HangAfter    int           // Stop answering from this request on, like a hung worker (0 never hangs)
+	WrongIDAfter int           // Answer this request with another request's id (0 never does)
+}
+
+// ErrCrashed is returned by Serve when it stops on purpose
+var ErrCrashed = errors.New("fake vosk worker crashed on purpose")
+
+// message mirrors the worker protocol on the wire
+type message struct {
+	Type       string    `json:"type"`
+	ID         string    `json:"id,omitempty"`
+	SampleRate int       `json:"sample_rate,omitempty"`
+	Data       []byte    `json:"data,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	Segments   []Segment `json:"segments,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+// session counts the audio received by one recognition
+type session struct {
+	sampleRate int
+	bytes      int
+}
+
+// Text is what the fake recognizes in audio of the given length: one word per
+// full second, "s1 s2 s3" for three seconds
+func Text(bytes, sampleRate int) string {
+	seconds := bytes / (2 * sampleRate)
+	words := make([]string, seconds)
+	for i := range words {
+		words[i] = fmt.Sprintf("s%d", i+1)
+	}
+	return strings.Join(words, " ")
+}
+
+// Output is a result in the form vosk-transcribe prints, with times in seconds
+type Output struct {
+	Text     string    `json:"text"`
+	Segments []Segment `json:"segments,omitempty"`
+}
+
+// Segment is one utterance of an Output
+type Segment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+	Words []Word  `json:"words,omitempty"`
+}
+
+// Word is one timed word of a Segment
+type Word struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Conf  float64 `json:"conf"`
+}
+
+// Transcript is the result the fake reports for audio of the given length: Text as
+// one utterance, each word spanning its second with a confidence of 0.9
+func Transcript(bytes, sampleRate int) Output {
+	out := Output{Text: Text(bytes, sampleRate)}
+	if out.Text == "" {
+		return out
+	}
+	seg := Segment{Text: out.Text}
+	for i, word := range strings.Fields(out.Text) {
+		seg.Words = append(seg.Words, Word{Word: word, Start: float64(i), End: float64(i + 1), Conf: 0.9})
+	}
+	seg.Start, seg.End = seg.Words[0].Start, seg.Words[len(seg.Words)-1].End
+	out.Segments = []Segment{seg}
+	return out
+}
+
+// Serve answers worker requests from in on out until in ends. It returns
+// ErrCrashed when Options.CrashAfter is reached.
+func Serve(in io.Reader, out io.Writer, opts Options) error {
+	time.Sleep(opts.LoadDelay)
+	enc := json.NewEncoder(out)
+	if err := enc.Encode(message{Type: "hello"}); err != nil {
+		return err
+	}
+
+	sessions := map[string]*session{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	requests := 0
+	for scanner.Scan() {
+		requests++
+		if opts.CrashAfter > 0 && requests >= opts.CrashAfter {
+			return ErrCrashed
+		}
+		if opts.HangAfter > 0 && requests >= opts.HangAfter {
+			continue
+		}
+
+		var req message
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			enc.Encode(message{Type: "error", Message: fmt.Sprintf("bad request: %v", err)})
+			continue
+		}
+		reply := message{ID: req.ID}
+		s := sessions[req.ID]
+		switch {
+		case req.Type == "start":
+			rate := req.SampleRate
+			if rate <= 0 {
+				rate = 16000
+			}
+			sessions[req.ID] = &session{sampleRate: rate}
+			reply.Type = "started"
+		case s == nil:
+			reply.Type = "error"
+			reply.Message = fmt.Sprintf("no session %q", req.ID)
+		case req.Type == "audio":
+			s.bytes += len(req.Data)
+			reply.Type = "partial"
+			reply.Text = Text(s.bytes, s.sampleRate)
+		case req.Type == "end":
+			delete(sessions, req.ID)
+			out := Transcript(s.bytes, s.sampleRate)
+			reply.Type = "final"
+			reply.Text = out.Text
+			reply.Segments = out.Segments
+		default:
+			reply.Type = "error"
+			reply.Message = fmt.Sprintf("unknown message type %q", req.Type)
+		}
+		if requests == opts.WrongIDAfter {
+			reply.ID += "-other"
+		}
+		if err := enc.Encode(reply); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
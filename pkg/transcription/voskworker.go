@@ -0,0 +1,340 @@
+package transcription
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Worker protocol: one JSON object per line in each direction. The worker announces
+// itself with {"type":"hello"} once its model is loaded and then answers every request
+// with exactly one reply carrying the request's id:
+//
+//	{"type":"start","id":"1","sample_rate":16000}  ->  {"type":"started","id":"1"}
+//	{"type":"audio","id":"1","data":"<base64 PCM>"} ->  {"type":"partial","id":"1","text":"..."}
+//	{"type":"end","id":"1"}                         ->  {"type":"final","id":"1","text":"...","segments":[...]}
+//
+// A request that fails is answered with {"type":"error","id":"1","message":"..."}.
+// The final reply carries the utterances and word timings in the same form as the
+// output of vosk-transcribe input.wav (see voskOutput).
+const (
+	workerStartTimeout  = 2 * time.Minute  // Loading a large model takes a while
+	workerReplyTimeout  = 30 * time.Second // A worker this slow to answer is considered hung
+	workerMaxRestarts   = 3                // Crashes tolerated within workerRestartWindow
+	workerRestartWindow = time.Minute
+	workerChunkBytes    = 16000 // Half a second of recognizer-format audio per audio message
+)
+
+var (
+	// errWorkerCrashed is returned when the worker died or hung during a request
+	errWorkerCrashed = errors.New("vosk worker crashed")
+	// errWorkerRestarted is returned by a session whose worker crashed and was replaced
+	errWorkerRestarted = errors.New("vosk worker restarted during the session")
+)
+
+// workerMessage is one line of the worker protocol
+type workerMessage struct {
+	Type       string        `json:"type"`
+	ID         string        `json:"id,omitempty"`
+	SampleRate int           `json:"sample_rate,omitempty"`
+	Data       []byte        `json:"data,omitempty"` // Encoded as base64
+	Text       string        `json:"text,omitempty"`
+	Segments   []voskSegment `json:"segments,omitempty"` // Only in final replies
+	Message    string        `json:"message,omitempty"`
+}
+
+// VoskWorker supervises a long-lived vosk-transcribe --worker process so the model
+// is loaded once instead of for every transcription. A worker that crashes is
+// restarted on the next request, unless it keeps crashing.
+type VoskWorker struct {
+	path         string
+	env          []string
+	replyTimeout time.Duration
+
+	mu          sync.Mutex // Held for a whole request so replies cannot interleave
+	proc        *workerProcess
+	crashes     []time.Time
+	unsupported bool // The script has no worker mode
+	nextID      uint64
+}
+
+// workerProcess is one run of the worker
+type workerProcess struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	replies chan workerMessage // Closed when the worker's output ends
+}
+
+// NewVoskWorker creates a supervisor for the script at path; the process starts on first use
+func NewVoskWorker(path string, env []string) *VoskWorker {
+	return &VoskWorker{path: path, env: env, replyTimeout: workerReplyTimeout}
+}
+
+// Start launches the worker if it is not running and waits until its model is loaded
+func (w *VoskWorker) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.running()
+	return err
+}
+
+// Close stops the worker
+func (w *VoskWorker) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.proc == nil {
+		return nil
+	}
+	// Closing stdin asks the worker to exit; kill it if it does not
+	w.proc.stdin.Close()
+	done := make(chan struct{})
+	go func(proc *workerProcess) {
+		for range proc.replies {
+		}
+		close(done)
+	}(w.proc)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		w.proc.cmd.Process.Kill()
+	}
+	w.proc.cmd.Wait()
+	w.proc = nil
+	return nil
+}
+
+// Transcribe runs a whole recording through one session and returns the final result
+func (w *VoskWorker) Transcribe(ctx context.Context, pcm []byte, sampleRate int) (Result, error) {
+	session, err := w.NewSession(ctx, sampleRate)
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err := session.Feed(ctx, pcm); err != nil {
+		return Result{}, err
+	}
+	return session.Final(ctx)
+}
+
+// VoskSession is one recognition on the worker. It implements Stream.
+type VoskSession struct {
+	worker *VoskWorker
+	proc   *workerProcess
+	id     string
+}
+
+// NewSession starts a recognition at the given sample rate
+func (w *VoskWorker) NewSession(ctx context.Context, sampleRate int) (*VoskSession, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	proc, err := w.running()
+	if err != nil {
+		return nil, err
+	}
+	w.nextID++
+	id := strconv.FormatUint(w.nextID, 10)
+	if _, err := w.request(ctx, proc, workerMessage{Type: "start", ID: id, SampleRate: sampleRate}, "started"); err != nil {
+		return nil, err
+	}
+	return &VoskSession{worker: w, proc: proc, id: id}, nil
+}
+
+// Feed sends audio that follows what was sent before and returns the text recognized so far
+func (s *VoskSession) Feed(ctx context.Context, pcm []byte) (string, error) {
+	var text string
+	for len(pcm) > 0 {
+		n := min(len(pcm), workerChunkBytes)
+		reply, err := s.send(ctx, workerMessage{Type: "audio", ID: s.id, Data: pcm[:n]}, "partial")
+		if err != nil {
+			return "", err
+		}
+		text = reply.Text
+		pcm = pcm[n:]
+	}
+	return text, nil
+}
+
+// Final ends the session and returns the text with its utterances and word timings
+func (s *VoskSession) Final(ctx context.Context) (Result, error) {
+	reply, err := s.send(ctx, workerMessage{Type: "end", ID: s.id}, "final")
+	if err != nil {
+		return Result{}, err
+	}
+	return voskOutput{Text: reply.Text, Segments: reply.Segments}.result(), nil
+}
+
+// send makes a request for the session, which only lives as long as its process
+func (s *VoskSession) send(ctx context.Context, msg workerMessage, want string) (workerMessage, error) {
+	s.worker.mu.Lock()
+	defer s.worker.mu.Unlock()
+	if s.worker.proc != s.proc {
+		return workerMessage{}, errWorkerRestarted
+	}
+	return s.worker.request(ctx, s.proc, msg, want)
+}
+
+// running returns the worker process, starting it if needed; the caller holds w.mu
+func (w *VoskWorker) running() (*workerProcess, error) {
+	if w.proc != nil {
+		return w.proc, nil
+	}
+	if w.unsupported {
+		return nil, fmt.Errorf("%s has no worker mode, rerun setup-vosk-small.sh to update it", w.path)
+	}
+
+	// Give up for a while if the worker keeps crashing
+	now := time.Now()
+	recent := w.crashes[:0]
+	for _, t := range w.crashes {
+		if now.Sub(t) < workerRestartWindow {
+			recent = append(recent, t)
+		}
+	}
+	w.crashes = recent
+	if len(w.crashes) >= workerMaxRestarts {
+		return nil, fmt.Errorf("vosk worker crashed %d times in the last %v, not restarting it yet", len(w.crashes), workerRestartWindow)
+	}
+
+	proc, err := w.launch()
+	if err != nil {
+		return nil, err
+	}
+	w.proc = proc
+	return proc, nil
+}
+
+// launch starts the process and waits for its hello; the caller holds w.mu
+func (w *VoskWorker) launch() (*workerProcess, error) {
+	cmd := exec.Command(w.path, "--worker")
+	cmd.Env = w.env
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start vosk worker: %v", err)
+	}
+	log.Printf("Started vosk worker %s (pid %d)", w.path, cmd.Process.Pid)
+
+	proc := &workerProcess{cmd: cmd, stdin: stdin, replies: make(chan workerMessage, 1)}
+	go readWorkerReplies(stdout, proc.replies)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Printf("vosk worker: %s", scanner.Text())
+		}
+	}()
+
+	select {
+	case hello, ok := <-proc.replies:
+		if ok && hello.Type == "hello" {
+			log.Printf("Vosk worker ready in %v", time.Since(start))
+			return proc, nil
+		}
+		w.stop(proc)
+		if ok && hello.Type == "error" {
+			w.crashes = append(w.crashes, time.Now())
+			return nil, fmt.Errorf("vosk worker: %s", hello.Message)
+		}
+		// Scripts from before the worker protocol fail on the --worker argument
+		w.unsupported = true
+		return nil, fmt.Errorf("%s did not start in worker mode, rerun setup-vosk-small.sh to update it", w.path)
+	case <-time.After(workerStartTimeout):
+		w.stop(proc)
+		w.crashes = append(w.crashes, time.Now())
+		return nil, fmt.Errorf("vosk worker did not load its model within %v", workerStartTimeout)
+	}
+}
+
+// request sends one message and waits for its reply; the caller holds w.mu.
+// Any failure other than an error reply leaves the protocol out of step, so the
+// process is stopped and replaced on the next request.
+func (w *VoskWorker) request(ctx context.Context, proc *workerProcess, msg workerMessage, want string) (workerMessage, error) {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return workerMessage{}, err
+	}
+	if _, err := proc.stdin.Write(append(line, '\n')); err != nil {
+		w.crashed(proc)
+		return workerMessage{}, fmt.Errorf("%w: %v", errWorkerCrashed, err)
+	}
+
+	timer := time.NewTimer(w.replyTimeout)
+	defer timer.Stop()
+	select {
+	case reply, ok := <-proc.replies:
+		switch {
+		case !ok:
+			w.crashed(proc)
+			return workerMessage{}, fmt.Errorf("%w: it exited", errWorkerCrashed)
+		case reply.ID != msg.ID:
+			w.crashed(proc)
+			return workerMessage{}, fmt.Errorf("%w: it answered %q to request %q", errWorkerCrashed, reply.ID, msg.ID)
+		case reply.Type == "error":
+			return workerMessage{}, fmt.Errorf("vosk worker: %s", reply.Message)
+		case reply.Type != want:
+			w.crashed(proc)
+			return workerMessage{}, fmt.Errorf("%w: it sent %q instead of %q", errWorkerCrashed, reply.Type, want)
+		}
+		return reply, nil
+	case <-timer.C:
+		w.crashed(proc)
+		return workerMessage{}, fmt.Errorf("%w: no answer within %v", errWorkerCrashed, w.replyTimeout)
+	case <-ctx.Done():
+		w.crashed(proc)
+		return workerMessage{}, ctx.Err()
+	}
+}
+
+// crashed stops a failed process and counts the failure; the caller holds w.mu
+func (w *VoskWorker) crashed(proc *workerProcess) {
+	log.Printf("Vosk worker (pid %d) failed, restarting it on the next request", proc.cmd.Process.Pid)
+	w.crashes = append(w.crashes, time.Now())
+	w.stop(proc)
+}
+
+// stop kills a process and forgets it; the caller holds w.mu
+func (w *VoskWorker) stop(proc *workerProcess) {
+	proc.stdin.Close()
+	proc.cmd.Process.Kill()
+	go proc.cmd.Wait()
+	// Let the reader finish instead of blocking on a reply nobody waits for
+	go func() {
+		for range proc.replies {
+		}
+	}()
+	if w.proc == proc {
+		w.proc = nil
+	}
+}
+
+// readWorkerReplies decodes the worker's output into replies until it ends
+func readWorkerReplies(r io.Reader, replies chan<- workerMessage) {
+	defer close(replies)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var msg workerMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Printf("Ignoring vosk worker output: %s", scanner.Text())
+			continue
+		}
+		replies <- msg
+	}
+}
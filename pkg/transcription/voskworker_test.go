@@ -0,0 +1,276 @@
+package transcription
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/audio"
+	"github.com/tarasowski/autospeech/pkg/config"
+	"github.com/tarasowski/autospeech/pkg/transcription/vosktest"
+)
+
+// TestMain lets the test binary stand in for vosk-transcribe: tests start it again
+// with FAKE_VOSK set, and it then transcribes a file or serves the worker protocol
+// instead of running tests
+func TestMain(m *testing.M) {
+	switch os.Getenv("FAKE_VOSK") {
+	case "vosk":
+		if len(os.Args) > 1 && os.Args[1] != "--worker" {
+			data, err := audio.LoadAudio(os.Args[1])
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			json.NewEncoder(os.Stdout).Encode(vosktest.Transcript(len(data), config.SampleRate))
+			os.Exit(0)
+		}
+		var opts vosktest.Options
+		if err := json.Unmarshal([]byte(os.Getenv("FAKE_VOSK_OPTIONS")), &opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := vosktest.Serve(os.Stdin, os.Stdout, opts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "legacy":
+		// Scripts from before the worker protocol only transcribe a file
+		fmt.Println("Usage: vosk-transcribe input.wav")
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeVoskVars returns the variables that make the test binary act as vosk-transcribe
+func fakeVoskVars(t *testing.T, mode string, opts vosktest.Options) []string {
+	t.Helper()
+	data, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{"FAKE_VOSK=" + mode, "FAKE_VOSK_OPTIONS=" + string(data)}
+}
+
+// newTestWorker returns a worker supervising the test binary as a fake vosk-transcribe
+func newTestWorker(t *testing.T, mode string, opts vosktest.Options) *VoskWorker {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewVoskWorker(exe, append(os.Environ(), fakeVoskVars(t, mode, opts)...))
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// seconds returns d of silent recognizer-format audio; the fake only counts its length
+func seconds(d float64) []byte {
+	return make([]byte, int(d*16000)*2)
+}
+
+func TestVoskWorkerHello(t *testing.T) {
+	w := newTestWorker(t, "vosk", vosktest.Options{LoadDelay: 50 * time.Millisecond})
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	proc := w.proc
+	if proc == nil {
+		t.Fatal("no worker process after Start")
+	}
+	if err := w.Start(); err != nil || w.proc != proc {
+		t.Errorf("second Start replaced the running worker (err %v)", err)
+	}
+	if err := w.Close(); err != nil || w.proc != nil {
+		t.Errorf("Close left the worker running (err %v)", err)
+	}
+}
+
+func TestVoskSessionFeedAndFinal(t *testing.T) {
+	ctx := context.Background()
+	w := newTestWorker(t, "vosk", vosktest.Options{})
+	session, err := w.NewSession(ctx, 16000)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+
+	partials := []string{"s1", "s1 s2", "s1 s2"}
+	for i, pcm := range [][]byte{seconds(1), seconds(1.25), seconds(0.5)} {
+		text, err := session.Feed(ctx, pcm)
+		if err != nil {
+			t.Fatalf("Feed %d: %v", i+1, err)
+		}
+		if text != partials[i] {
+			t.Errorf("partial after feed %d = %q, want %q", i+1, text, partials[i])
+		}
+	}
+
+	result, err := session.Final(ctx)
+	if err != nil {
+		t.Fatalf("Final: %v", err)
+	}
+	if result.Text != "s1 s2" {
+		t.Errorf("final text = %q, want %q", result.Text, "s1 s2")
+	}
+	if len(result.Segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(result.Segments))
+	}
+	seg := result.Segments[0]
+	if seg.Start != 0 || seg.End != 2*time.Second || seg.Text != "s1 s2" {
+		t.Errorf("segment = %+v, want s1 s2 from 0s to 2s", seg)
+	}
+	want := []Word{
+		{Text: "s1", Start: 0, End: time.Second, Confidence: 0.9},
+		{Text: "s2", Start: time.Second, End: 2 * time.Second, Confidence: 0.9},
+	}
+	if words := result.Words(); fmt.Sprint(words) != fmt.Sprint(want) {
+		t.Errorf("words = %v, want %v", words, want)
+	}
+
+	// Sessions do not share audio
+	result, err = w.Transcribe(ctx, seconds(3), 16000)
+	if err != nil || result.Text != "s1 s2 s3" {
+		t.Errorf("Transcribe = %q, %v, want %q", result.Text, err, "s1 s2 s3")
+	}
+}
+
+func TestVoskWorkerCrashMidSession(t *testing.T) {
+	ctx := context.Background()
+	// Requests: start, then audio in half-second chunks; the fourth chunk kills the worker
+	w := newTestWorker(t, "vosk", vosktest.Options{CrashAfter: 5})
+	session, err := w.NewSession(ctx, 16000)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	crashed := w.proc
+	if _, err := session.Feed(ctx, seconds(2)); !errors.Is(err, errWorkerCrashed) {
+		t.Fatalf("Feed error = %v, want %v", err, errWorkerCrashed)
+	}
+	if w.proc != nil {
+		t.Error("crashed worker still in use")
+	}
+	if _, err := session.Final(ctx); !errors.Is(err, errWorkerRestarted) {
+		t.Errorf("Final error = %v, want %v", err, errWorkerRestarted)
+	}
+
+	// The next request starts a new worker
+	result, err := w.Transcribe(ctx, seconds(1), 16000)
+	if err != nil {
+		t.Fatalf("Transcribe after crash: %v", err)
+	}
+	if result.Text != "s1" {
+		t.Errorf("text = %q, want %q", result.Text, "s1")
+	}
+	if w.proc == nil || w.proc == crashed {
+		t.Error("worker was not restarted")
+	}
+	if _, err := session.Feed(ctx, seconds(1)); !errors.Is(err, errWorkerRestarted) {
+		t.Errorf("Feed on the old session = %v, want %v", err, errWorkerRestarted)
+	}
+}
+
+func TestVoskWorkerRestartLimit(t *testing.T) {
+	ctx := context.Background()
+	// Every worker dies on its first request
+	w := newTestWorker(t, "vosk", vosktest.Options{CrashAfter: 1})
+	for i := 0; i < workerMaxRestarts; i++ {
+		if _, err := w.NewSession(ctx, 16000); !errors.Is(err, errWorkerCrashed) {
+			t.Fatalf("NewSession %d error = %v, want %v", i+1, err, errWorkerCrashed)
+		}
+	}
+	_, err := w.NewSession(ctx, 16000)
+	if err == nil || !strings.Contains(err.Error(), "not restarting it yet") {
+		t.Fatalf("NewSession after %d crashes = %v, want a refusal to restart", workerMaxRestarts, err)
+	}
+	if w.proc != nil {
+		t.Error("worker started despite the restart limit")
+	}
+
+	// Crashes older than the window no longer count
+	for i := range w.crashes {
+		w.crashes[i] = w.crashes[i].Add(-workerRestartWindow)
+	}
+	if err := w.Start(); err != nil {
+		t.Errorf("Start after the restart window: %v", err)
+	}
+}
+
+func TestVoskWorkerUnsupported(t *testing.T) {
+	w := newTestWorker(t, "legacy", vosktest.Options{})
+	err := w.Start()
+	if err == nil || !strings.Contains(err.Error(), "did not start in worker mode") {
+		t.Fatalf("Start = %v, want a worker mode error", err)
+	}
+	if !w.unsupported {
+		t.Error("script not marked as lacking worker mode")
+	}
+	if len(w.crashes) != 0 {
+		t.Errorf("missing worker mode counted as %d crashes", len(w.crashes))
+	}
+	// It is not started again
+	_, err = w.NewSession(context.Background(), 16000)
+	if err == nil || !strings.Contains(err.Error(), "has no worker mode") {
+		t.Errorf("NewSession = %v, want a worker mode error", err)
+	}
+}
+
+func TestVoskWorkerReplyTimeout(t *testing.T) {
+	ctx := context.Background()
+	w := newTestWorker(t, "vosk", vosktest.Options{HangAfter: 2})
+	w.replyTimeout = 200 * time.Millisecond
+	session, err := w.NewSession(ctx, 16000)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	start := time.Now()
+	_, err = session.Feed(ctx, seconds(0.5))
+	if !errors.Is(err, errWorkerCrashed) || !strings.Contains(err.Error(), "no answer within 200ms") {
+		t.Fatalf("Feed error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timeout took %v", elapsed)
+	}
+	if w.proc != nil || len(w.crashes) != 1 {
+		t.Errorf("hung worker not stopped and counted (proc %v, %d crashes)", w.proc, len(w.crashes))
+	}
+}
+
+func TestVoskWorkerMismatchedReply(t *testing.T) {
+	ctx := context.Background()
+	w := newTestWorker(t, "vosk", vosktest.Options{WrongIDAfter: 2})
+	session, err := w.NewSession(ctx, 16000)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	_, err = session.Feed(ctx, seconds(0.5))
+	if !errors.Is(err, errWorkerCrashed) || !strings.Contains(err.Error(), `answered "1-other" to request "1"`) {
+		t.Fatalf("Feed error = %v, want a mismatched id", err)
+	}
+	if w.proc != nil {
+		t.Error("worker out of step with the protocol still in use")
+	}
+}
+
+func TestVoskWorkerErrorReply(t *testing.T) {
+	ctx := context.Background()
+	w := newTestWorker(t, "vosk", vosktest.Options{})
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	proc := w.proc
+	// A session the worker does not know is answered with an error; the worker stays up
+	session := &VoskSession{worker: w, proc: proc, id: "unknown"}
+	_, err := session.Feed(ctx, seconds(0.5))
+	if err == nil || !strings.Contains(err.Error(), `no session "unknown"`) {
+		t.Fatalf("Feed error = %v, want the worker's error", err)
+	}
+	if errors.Is(err, errWorkerCrashed) || w.proc != proc {
+		t.Error("an error reply stopped the worker")
+	}
+}
@@ -0,0 +1,216 @@
+package transcription
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+func init() {
+	Register("whisper", newWhisperBackend)
+}
+
+// whisperBinaries are the names of the whisper.cpp command line tool, newest first;
+// builds from before the rename only have main
+var whisperBinaries = []string{"whisper-cli", "whisper-cpp", "main"}
+
+// whisperLanguages are the language codes whisper.cpp accepts for -l
+var whisperLanguages = strings.Fields(`
+	en zh de es ru ko fr ja pt tr pl ca nl ar sv it id hi fi vi he uk el ms cs ro da hu
+	ta no th ur hr bg lt la mi ml cy sk te fa lv bn sr az sl kn et mk br eu is hy ne mn
+	bs kk sq sw gl mr pa si km sn yo so af oc ka be tg sd gu am yi lo uz fo ht ps tk nn
+	mt sa lb my bo tl mg as tt haw ln ha ba jw su yue`)
+
+// whisperTimestamp matches a line of whisper.cpp's text output, such as
+// "[00:00:01.240 --> 00:00:03.500]   Hello there."
+var whisperTimestamp = regexp.MustCompile(`^\[(\d+):(\d\d):(\d\d)\.(\d\d\d) --> (\d+):(\d\d):(\d\d)\.(\d\d\d)\](.*)$`)
+
+// whisperDetected matches the log line reporting the language found with -l auto
+var whisperDetected = regexp.MustCompile(`auto-detected language: ([a-z]+)`)
+
+// whisperBackend runs the whisper.cpp command line tool with the GGML model given by -model
+type whisperBackend struct {
+	bin      string
+	model    string
+	language string // Passed to -l; "auto" detects it
+}
+
+// newWhisperBackend checks that the whisper.cpp binary and model exist
+func newWhisperBackend(cfg *config.AppConfig) (Backend, error) {
+	if _, err := os.Stat(cfg.ModelPath); err != nil {
+		return nil, fmt.Errorf("model %s not found; download a GGML model from https://huggingface.co/ggerganov/whisper.cpp and pass it with -model", cfg.ModelPath)
+	}
+	if cfg.Language != "" && !slices.Contains(whisperLanguages, cfg.Language) {
+		return nil, fmt.Errorf("whisper.cpp does not know the language %q", cfg.Language)
+	}
+	if strings.Contains(filepath.Base(cfg.ModelPath), ".en.") && cfg.Language != "" && cfg.Language != "en" {
+		return nil, fmt.Errorf("model %s only recognizes English, use a multilingual model for -lang %s", cfg.ModelPath, cfg.Language)
+	}
+
+	bin, err := findWhisperBinary(cfg.WhisperBin)
+	if err != nil {
+		return nil, err
+	}
+	// whisper.cpp assumes English unless told otherwise; let multilingual models detect the language
+	language := cfg.Language
+	if language == "" {
+		language = "auto"
+		if strings.Contains(filepath.Base(cfg.ModelPath), ".en.") {
+			language = "en"
+		}
+	}
+	log.Printf("Using whisper.cpp at %s with model %s", bin, cfg.ModelPath)
+	return &whisperBackend{bin: bin, model: cfg.ModelPath, language: language}, nil
+}
+
+// findWhisperBinary returns bin if set, otherwise the first whisper.cpp tool found in PATH
+func findWhisperBinary(bin string) (string, error) {
+	if bin != "" {
+		path, err := exec.LookPath(bin)
+		if err != nil {
+			return "", fmt.Errorf("whisper.cpp binary %s not found: %v", bin, err)
+		}
+		return path, nil
+	}
+	for _, name := range whisperBinaries {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("whisper.cpp not found in PATH (looked for %s); build it or pass its binary with -whisper-bin",
+		strings.Join(whisperBinaries, ", "))
+}
+
+// Name returns the backend name
+func (b *whisperBackend) Name() string {
+	return "whisper"
+}
+
+// Capabilities reports that whisper.cpp honours the language but is too slow to load for partial transcripts
+func (b *whisperBackend) Capabilities() Capabilities {
+	return Capabilities{Languages: true}
+}
+
+// whisperOutput is the part of the whisper.cpp JSON output (-oj) that is used
+type whisperOutput struct {
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"` // Milliseconds
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+	Result struct {
+		Language string `json:"language"` // Detected when -l is auto
+	} `json:"result"`
+}
+
+// Transcribe runs whisper.cpp on the WAV file and reads the JSON it writes
+func (b *whisperBackend) Transcribe(ctx context.Context, audio Audio) (Result, error) {
+	// Write the output next to nothing the user owns; the input may be their recording
+	outDir, err := os.MkdirTemp("", "autospeech-whisper")
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create whisper output directory: %v", err)
+	}
+	defer os.RemoveAll(outDir)
+	outPrefix := filepath.Join(outDir, "transcript")
+
+	// Timestamps stay on (no -nt) so the offsets are segment boundaries rather than whole windows
+	args := []string{"-m", b.model, "-f", audio.WavFile, "-oj", "-of", outPrefix, "-np", "-l", b.language}
+	log.Printf("Running whisper.cpp: %s %s", b.bin, strings.Join(args, " "))
+	output, err := exec.CommandContext(ctx, b.bin, args...).CombinedOutput()
+	if err != nil {
+		return Result{}, fmt.Errorf("whisper.cpp failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// Builds too old for -oj only print the timestamped text
+	data, err := os.ReadFile(outPrefix + ".json")
+	if err != nil {
+		log.Printf("whisper.cpp wrote no JSON output, reading its text output: %v", err)
+		data = output
+	}
+	result, err := parseWhisperOutput(data)
+	if err == nil && result.Language == "" && b.language != "auto" {
+		result.Language = b.language
+	}
+	return result, err
+}
+
+// parseWhisperOutput turns whisper.cpp JSON, or its timestamped text output, into a
+// result with one segment per timestamped piece. Annotations such as [BLANK_AUDIO]
+// are left out.
+func parseWhisperOutput(data []byte) (Result, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return parseWhisperText(data)
+	}
+	var out whisperOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Result{}, fmt.Errorf("invalid whisper.cpp output: %v", err)
+	}
+
+	var result Result
+	for _, piece := range out.Transcription {
+		addWhisperPiece(&result, time.Duration(piece.Offsets.From)*time.Millisecond,
+			time.Duration(piece.Offsets.To)*time.Millisecond, piece.Text)
+	}
+	result.Language = out.Result.Language
+	return result, nil
+}
+
+// parseWhisperText reads the "[from --> to] text" lines whisper.cpp prints, skipping
+// the log lines mixed in with them
+func parseWhisperText(data []byte) (Result, error) {
+	var result Result
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := whisperDetected.FindStringSubmatch(line); m != nil {
+			result.Language = m[1]
+		}
+		m := whisperTimestamp.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		found = true
+		addWhisperPiece(&result, whisperTime(m[1:5]), whisperTime(m[5:9]), m[9])
+	}
+	if !found && len(bytes.TrimSpace(data)) > 0 {
+		return Result{}, fmt.Errorf("no transcript in whisper.cpp output")
+	}
+	return result, nil
+}
+
+// whisperTime converts hours, minutes, seconds and milliseconds to a duration
+func whisperTime(parts []string) time.Duration {
+	var n [4]int
+	for i, part := range parts {
+		n[i], _ = strconv.Atoi(part)
+	}
+	return time.Duration(n[0])*time.Hour + time.Duration(n[1])*time.Minute +
+		time.Duration(n[2])*time.Second + time.Duration(n[3])*time.Millisecond
+}
+
+// addWhisperPiece appends a timestamped piece to the result unless it is an annotation
+func addWhisperPiece(result *Result, from, to time.Duration, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" || (strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]")) {
+		return
+	}
+	if result.Text != "" {
+		result.Text += " "
+	}
+	result.Text += text
+	result.Segments = append(result.Segments, TranscriptSegment{Start: from, End: to, Text: text})
+}
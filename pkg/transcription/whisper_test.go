@@ -0,0 +1,185 @@
+package transcription
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tarasowski/autospeech/pkg/config"
+)
+
+// whisperJSON is the output of whisper-cli -oj -l auto for a short German dictation
+const whisperJSON = `{
+	"systeminfo": "AVX = 1 | AVX2 = 1 | AVX512 = 0 | FMA = 1 | NEON = 0 | ARM_FMA = 0 | F16C = 1 | FP16_VA = 0 | WASM_SIMD = 0 | SSE3 = 1 | SSSE3 = 1 | VSX = 0 | COREML = 0 | OPENVINO = 0",
+	"model": {
+		"type": "base",
+		"multilingual": true,
+		"vocab": 51865,
+		"audio": {"ctx": 1500, "state": 512, "head": 8, "layer": 6},
+		"text": {"ctx": 448, "state": 512, "head": 8, "layer": 6},
+		"mels": 80,
+		"ftype": 1
+	},
+	"params": {"model": "models/ggml-base.bin", "language": "auto", "translate": false},
+	"result": {"language": "de"},
+	"transcription": [
+		{
+			"timestamps": {"from": "00:00:00,000", "to": "00:00:02,640"},
+			"offsets": {"from": 0, "to": 2640},
+			"text": " Guten Morgen, das ist ein Test."
+		},
+		{
+			"timestamps": {"from": "00:00:02,640", "to": "00:00:05,000"},
+			"offsets": {"from": 2640, "to": 5000},
+			"text": " [BLANK_AUDIO]"
+		},
+		{
+			"timestamps": {"from": "00:00:05,000", "to": "00:01:02,120"},
+			"offsets": {"from": 5000, "to": 62120},
+			"text": " Bis später."
+		}
+	]
+}
+`
+
+// whisperText is what an older main build prints without -oj, logs included
+const whisperText = `whisper_init_from_file_with_params_no_state: loading model from 'models/ggml-base.bin'
+whisper_model_load: n_vocab       = 51865
+system_info: n_threads = 4 / 8 | AVX = 1 | AVX2 = 1 | AVX512 = 0 |
+
+main: processing 'rec.wav' (998720 samples, 62.4 sec), 4 threads, 1 processors, 5 beams + best of 5, lang = auto, task = transcribe, timestamps = 1 ...
+
+whisper_full_with_state: auto-detected language: de (p = 0.982133)
+
+[00:00:00.000 --> 00:00:02.640]   Guten Morgen, das ist ein Test.
+[00:00:02.640 --> 00:00:05.000]   [BLANK_AUDIO]
+[00:00:05.000 --> 00:01:02.120]   Bis später.
+
+
+whisper_print_timings:     load time =    84.12 ms
+whisper_print_timings:    total time =  2310.55 ms
+`
+
+func TestParseWhisperOutput(t *testing.T) {
+	twoPieces := []TranscriptSegment{
+		{Start: 0, End: 2640 * time.Millisecond, Text: "Guten Morgen, das ist ein Test."},
+		{Start: 5 * time.Second, End: time.Minute + 2120*time.Millisecond, Text: "Bis später."},
+	}
+	tests := []struct {
+		name     string
+		output   string
+		text     string
+		segments []TranscriptSegment
+		language string
+		err      string
+	}{
+		{name: "json", output: whisperJSON, text: "Guten Morgen, das ist ein Test. Bis später.", segments: twoPieces, language: "de"},
+		{name: "text", output: whisperText, text: "Guten Morgen, das ist ein Test. Bis später.", segments: twoPieces, language: "de"},
+		{
+			name:   "text with -l en and hours",
+			output: "[01:02:03.004 --> 01:02:04.500]  Hello.\n",
+			text:   "Hello.",
+			segments: []TranscriptSegment{
+				{Start: time.Hour + 2*time.Minute + 3004*time.Millisecond, End: time.Hour + 2*time.Minute + 4500*time.Millisecond, Text: "Hello."},
+			},
+		},
+		{name: "json of silence", output: `{"result": {"language": "en"}, "transcription": [{"offsets": {"from": 0, "to": 3000}, "text": " [BLANK_AUDIO]"}]}`, language: "en"},
+		{name: "no output", output: ""},
+		{name: "invalid json", output: `{"transcription": [`, err: "invalid whisper.cpp output"},
+		{name: "text without transcript", output: "error: failed to read WAV file 'rec.wav'\n", err: "no transcript"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseWhisperOutput([]byte(tt.output))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Text != tt.text {
+				t.Errorf("text = %q, want %q", result.Text, tt.text)
+			}
+			if fmt.Sprint(result.Segments) != fmt.Sprint(tt.segments) {
+				t.Errorf("segments = %v, want %v", result.Segments, tt.segments)
+			}
+			if result.Language != tt.language {
+				t.Errorf("language = %q, want %q", result.Language, tt.language)
+			}
+		})
+	}
+}
+
+func TestFindWhisperBinary(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	if _, err := findWhisperBinary(""); err == nil || !strings.Contains(err.Error(), "whisper-cli, whisper-cpp, main") {
+		t.Errorf("error without any binary = %v", err)
+	}
+
+	// Older builds only have main; newer names win when both are present
+	for _, name := range []string{"main", "whisper-cli"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		path, err := findWhisperBinary("")
+		if err != nil || filepath.Base(path) != name {
+			t.Errorf("with %s installed found %q, %v", name, path, err)
+		}
+	}
+}
+
+func TestBackendLanguages(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	if err := os.WriteFile(filepath.Join(dir, "whisper-cli"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	multilingual := filepath.Join(dir, "ggml-base.bin")
+	english := filepath.Join(dir, "ggml-base.en.bin")
+	for _, model := range []string{multilingual, english} {
+		if err := os.WriteFile(model, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		backends string
+		language string
+		model    string
+		err      string
+	}{
+		{"vosk", "", "", ""},
+		{"vosk", "de", "", ""},
+		{"vosk", "fr", "", `no Vosk models for language "fr" (available: en, de)`},
+		{"whisper", "fr", multilingual, ""},
+		{"whisper", "yue", multilingual, ""},
+		{"whisper", "", english, ""},
+		{"whisper", "xx", multilingual, `does not know the language "xx"`},
+		{"whisper", "fr", english, "only recognizes English"},
+		// The system backend ignores the language
+		{"system", "fr", "", ""},
+	}
+	for _, tt := range tests {
+		cfg := &config.AppConfig{Language: tt.language, ModelPath: tt.model}
+		backends, err := NewBackends(tt.backends, cfg)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s with -lang %q: %v", tt.backends, tt.language, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s with -lang %q: error = %v, want %q", tt.backends, tt.language, err, tt.err)
+		}
+		for _, b := range backends {
+			if c, ok := b.(interface{ Close() error }); ok {
+				c.Close()
+			}
+		}
+	}
+}
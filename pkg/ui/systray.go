@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/getlantern/systray"
 
+	"github.com/tarasowski/autospeech/pkg/audio"
 	"github.com/tarasowski/autospeech/pkg/clipboard"
 	"github.com/tarasowski/autospeech/pkg/config"
 )
@@ -23,6 +25,12 @@ type TrayMenu struct {
 	onStop     func()
 	onQuit     func()
 	onPaste    func(string)
+	onPause    func()
+	onResume   func()
+	onRecover  func()
+	recoverMu  sync.Mutex
+	mRecover   *systray.MenuItem
+	recovery   string // Tooltip of the recover item; hidden when empty
 }
 
 // NewTrayMenu creates a new system tray interface
@@ -46,6 +54,42 @@ func (tm *TrayMenu) SetCallbacks(onStart, onStop, onQuit func(), onPaste func(st
 	tm.onPaste = onPaste
 }
 
+// SetPauseCallbacks sets the callbacks of the pause menu item
+func (tm *TrayMenu) SetPauseCallbacks(onPause, onResume func()) {
+	tm.onPause = onPause
+	tm.onResume = onResume
+}
+
+// SetRecoverCallback sets the callback of the recover menu item
+func (tm *TrayMenu) SetRecoverCallback(onRecover func()) {
+	tm.onRecover = onRecover
+}
+
+// OfferRecovery shows the recover menu item with summary as its tooltip and
+// announces it, or hides the item if summary is empty
+func (tm *TrayMenu) OfferRecovery(summary string) {
+	tm.recoverMu.Lock()
+	defer tm.recoverMu.Unlock()
+	tm.recovery = summary
+	if summary != "" {
+		tm.notifyMgr.ShowNotification(summary)
+	}
+	// Before the tray is ready setupTray picks the summary up
+	if tm.mRecover != nil {
+		tm.showRecovery()
+	}
+}
+
+// showRecovery updates the recover item; the caller holds recoverMu
+func (tm *TrayMenu) showRecovery() {
+	if tm.recovery == "" {
+		tm.mRecover.Hide()
+		return
+	}
+	tm.mRecover.SetTooltip(tm.recovery)
+	tm.mRecover.Show()
+}
+
 // Start initializes and shows the system tray
 func (tm *TrayMenu) Start() {
 	go systray.Run(
@@ -65,41 +109,70 @@ func (tm *TrayMenu) setupTray() {
 	systray.SetTooltip("Speech Recognition")
 
 	mRecord := systray.AddMenuItem("Start Recording", "Start speech recognition")
+	mPause := systray.AddMenuItem("Pause", "Pause without ending the dictation")
+	mPause.Disable()
 	
 	// Cache menu items for later use
 	tm.menuItems["Start Recording"] = mRecord
+	tm.menuItems["Pause"] = mPause
+
+	// Offered when an earlier run crashed with a dictation in progress
+	mRecover := systray.AddMenuItem("Recover Dictation", "Transcribe the dictation left by an earlier run")
+	tm.recoverMu.Lock()
+	tm.mRecover = mRecover
+	tm.showRecovery()
+	tm.recoverMu.Unlock()
 	
 	systray.AddSeparator()
 	mQuit := systray.AddMenuItem("Quit", "Quit the app")
 
+	// Follow the session state so the menu always matches the pipeline
+	events, unsubscribe := tm.state.SubscribeState()
+	go func() {
+		defer unsubscribe()
+		for {
+			select {
+			case <-tm.ctx.Done():
+				return
+			case ev := <-events:
+				tm.showState(mRecord, mPause, ev)
+			}
+		}
+	}()
+
 	go func() {
 		for {
 			select {
 			case <-tm.ctx.Done():
 				return
 			case <-mRecord.ClickedCh:
-				if !tm.state.IsRecording() {
-					// Start recording
-					tm.state.SetRecording(true)
-					mRecord.SetTitle("Stop Recording")
-					// Add this to the cache with the new title
-					tm.menuItems["Stop Recording"] = mRecord
-					
+				switch tm.state.State() {
+				case config.StateIdle, config.StateError:
 					// Clear previous transcribed text
 					tm.state.SetTranscribedText("")
-					
 					if tm.onStart != nil {
 						tm.onStart()
 					}
-				} else {
-					// Stop recording and transcribe
-					tm.state.SetRecording(false)
-					mRecord.SetTitle("Processing...")
-					
+				case config.StateStarting, config.StateRecording, config.StatePaused, config.StateRecovering:
 					if tm.onStop != nil {
 						tm.onStop()
 					}
 				}
+			case <-mPause.ClickedCh:
+				switch tm.state.State() {
+				case config.StateRecording:
+					if tm.onPause != nil {
+						tm.onPause()
+					}
+				case config.StatePaused:
+					if tm.onResume != nil {
+						tm.onResume()
+					}
+				}
+			case <-mRecover.ClickedCh:
+				if tm.onRecover != nil {
+					tm.onRecover()
+				}
 			case <-mQuit.ClickedCh:
 				log.Println("Quit requested")
 				systray.Quit()
@@ -112,6 +185,56 @@ func (tm *TrayMenu) setupTray() {
 	}()
 }
 
+// showState updates the menu items and tray title for a state change
+func (tm *TrayMenu) showState(mRecord, mPause *systray.MenuItem, ev config.StateEvent) {
+	if ev.To == config.StatePaused {
+		mPause.SetTitle("Resume")
+	} else {
+		mPause.SetTitle("Pause")
+	}
+	if ev.To == config.StateRecording || ev.To == config.StatePaused {
+		mPause.Enable()
+	} else {
+		mPause.Disable()
+	}
+
+	switch ev.To {
+	case config.StateIdle:
+		mRecord.SetTitle("Start Recording")
+		mRecord.Enable()
+		systray.SetTitle("Speech-to-Text")
+		systray.SetTooltip("Speech Recognition")
+	case config.StateStarting:
+		mRecord.SetTitle("Stop Recording")
+		mRecord.Enable()
+		systray.SetTooltip("Opening microphone...")
+	case config.StateRecording:
+		mRecord.SetTitle("Stop Recording")
+		systray.SetTooltip("Recording")
+	case config.StateRecovering:
+		systray.SetTitle("Speech-to-Text (reconnecting)")
+		if ev.Err != nil {
+			systray.SetTooltip("Microphone lost, reconnecting: " + ev.Err.Error())
+		}
+	case config.StatePaused:
+		systray.SetTitle("Speech-to-Text (paused)")
+		systray.SetTooltip("Paused - choose Resume to continue the dictation")
+	case config.StateStopping, config.StateProcessing:
+		mRecord.SetTitle("Processing...")
+		mRecord.Disable()
+		systray.SetTitle("Speech-to-Text")
+		systray.SetTooltip("Processing")
+	case config.StateError:
+		mRecord.SetTitle("Start Recording")
+		mRecord.Enable()
+		systray.SetTitle("Speech-to-Text (error)")
+		if ev.Err != nil {
+			systray.SetTooltip("Error: " + ev.Err.Error())
+			tm.notifyMgr.ShowNotification("Error: " + ev.Err.Error())
+		}
+	}
+}
+
 // UpdateMenuTitle updates a menu item's title
 func (tm *TrayMenu) UpdateMenuTitle(key, title string) {
 	if item, ok := tm.menuItems[key]; ok {
@@ -150,6 +273,19 @@ func (tm *TrayMenu) UpdateTrayTooltip(tooltip string) {
 	systray.SetTooltip(tooltip)
 }
 
+// ShowInputLevel shows a level meter in the tray title and the exact levels in the tooltip
+func (tm *TrayMenu) ShowInputLevel(level audio.Level) {
+	systray.SetTitle("🎙 " + level.Meter(8))
+
+	tooltip := fmt.Sprintf("Input level %.0f dBFS (peak %.0f dBFS)", level.RMS, level.Peak)
+	if level.Clipping {
+		tooltip += " - clipping, lower the microphone gain"
+	} else if level.Silent {
+		tooltip += " - no input, is the microphone muted?"
+	}
+	systray.SetTooltip(tooltip)
+}
+
 // GetMenuItem gets a menu item by key
 func (tm *TrayMenu) GetMenuItem(key string) (*systray.MenuItem, bool) {
 	item, ok := tm.menuItems[key]
@@ -166,7 +302,8 @@ func (tm *TrayMenu) RemoveMenuItemFromCache(key string) {
 	delete(tm.menuItems, key)
 }
 
-// SetupForTranscriptionComplete updates the UI after transcription is complete
+// SetupForTranscriptionComplete stores the transcribed text and copies it to the clipboard.
+// The menu itself follows the session state.
 func (tm *TrayMenu) SetupForTranscriptionComplete(text string) {
 	// Store the transcribed text but don't display it in the tray
 	if text != "" {
@@ -175,17 +312,4 @@ func (tm *TrayMenu) SetupForTranscriptionComplete(text string) {
 		// Copy to clipboard right away
 		tm.clipMgr.CopyToClipboard(text)
 	}
-	
-	// Just reset the recording button regardless of text content
-	if mRecord, ok := tm.menuItems["Stop Recording"]; ok {
-		mRecord.SetTitle("Start Recording")
-		// Update the cache
-		tm.menuItems["Start Recording"] = mRecord
-		// Remove the old title from the cache
-		delete(tm.menuItems, "Stop Recording")
-	}
-	
-	// Keep title simple
-	systray.SetTitle("Speech-to-Text")
-	systray.SetTooltip("Speech Recognition")
-}
\ No newline at end of file
+}
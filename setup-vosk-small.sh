@@ -28,32 +28,105 @@ fi
 echo "Creating Vosk transcription script..."
 cat > ./vosk-transcribe << 'EOL'
 #!/bin/bash
-# Activate the virtual environment and run the transcription script
+# Activate the virtual environment and run the transcription script. The code is
+# passed with -c so that stdin stays free for worker mode.
 source ~/vosk-env/bin/activate
-python3 - "$@" << 'PYCODE'
-#!/usr/bin/env python3
+SCRIPT=$(cat << 'PYCODE'
 import sys
 import json
 import os
+import base64
 from vosk import Model, KaldiRecognizer, SetLogLevel
 import wave
 
 SetLogLevel(-1)  # Disable debug messages
 
 if len(sys.argv) < 2:
-    print("Usage: vosk-transcribe input.wav")
+    print("Usage: vosk-transcribe input.wav | --worker")
     sys.exit(1)
 
-wav_file = sys.argv[1]
+worker = sys.argv[1] == "--worker"
+
+
+def send(msg):
+    sys.stdout.write(json.dumps(msg) + "\n")
+    sys.stdout.flush()
+
 
-# Find model in the user's home directory
-model_path = os.path.expanduser("~/vosk-models/vosk-model-small-en-us-0.15")
+# Use the model selected by the app, or the one in the user's home directory
+model_path = os.environ.get("VOSK_MODEL") or os.path.expanduser("~/vosk-models/vosk-model-small-en-us-0.15")
 if not os.path.exists(model_path):
-    print(f"Error: Model not found at {model_path}")
+    if worker:
+        send({"type": "error", "message": f"Model not found at {model_path}"})
+    else:
+        print(f"Error: Model not found at {model_path}")
     sys.exit(1)
 
 model = Model(model_path)
 
+
+def join(texts):
+    return " ".join(t for t in texts if t)
+
+
+def utterance(res):
+    # One Vosk result as a segment with word timings in seconds, or None if empty
+    text = res.get("text", "")
+    if not text:
+        return None
+    words = [
+        {"word": w["word"], "start": w["start"], "end": w["end"], "conf": w.get("conf", 0)}
+        for w in res.get("result", [])
+    ]
+    seg = {"text": text, "words": words}
+    if words:
+        seg["start"], seg["end"] = words[0]["start"], words[-1]["end"]
+    return seg
+
+
+def transcript(results):
+    # The output contract: {"text": ..., "segments": [{"start", "end", "text", "words": [...]}]}
+    segments = [s for s in map(utterance, results) if s]
+    return {"text": join(s["text"] for s in segments), "segments": segments}
+
+
+if worker:
+    # One JSON request per line on stdin, one reply per request on stdout
+    sessions = {}
+    send({"type": "hello"})
+    for line in sys.stdin:
+        if not line.strip():
+            continue
+        sid = ""
+        try:
+            msg = json.loads(line)
+            kind, sid = msg.get("type"), msg.get("id", "")
+            if kind == "start":
+                rec = KaldiRecognizer(model, msg.get("sample_rate", 16000))
+                rec.SetWords(True)
+                sessions[sid] = (rec, [])
+                send({"type": "started", "id": sid})
+            elif kind == "audio":
+                rec, results = sessions[sid]
+                partial = ""
+                if rec.AcceptWaveform(base64.b64decode(msg.get("data", ""))):
+                    results.append(json.loads(rec.Result()))
+                else:
+                    partial = json.loads(rec.PartialResult()).get("partial", "")
+                texts = [r.get("text", "") for r in results]
+                send({"type": "partial", "id": sid, "text": join(texts + [partial])})
+            elif kind == "end":
+                rec, results = sessions.pop(sid)
+                results.append(json.loads(rec.FinalResult()))
+                send({"type": "final", "id": sid, **transcript(results)})
+            else:
+                send({"type": "error", "id": sid, "message": f"unknown message type {kind}"})
+        except Exception as e:
+            send({"type": "error", "id": sid, "message": str(e)})
+    sys.exit(0)
+
+wav_file = sys.argv[1]
+
 # Open the WAV file
 wf = wave.open(wav_file, "rb")
 if wf.getnchannels() != 1 or wf.getsampwidth() != 2 or wf.getcomptype() != "NONE":
@@ -77,10 +150,11 @@ while True:
 part_result = json.loads(rec.FinalResult())
 results.append(part_result)
 
-# Extract text from results
-full_text = " ".join([res.get("text", "") for res in results if "text" in res])
-print(full_text)
+# Print the text with its utterances and word timings as one JSON object
+print(json.dumps(transcript(results)))
 PYCODE
+)
+exec python3 -c "$SCRIPT" "$@"
 EOL
 
 chmod +x ./vosk-transcribe